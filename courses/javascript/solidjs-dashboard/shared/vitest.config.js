@@ -6,6 +6,9 @@ export default defineConfig({
   test: {
     environment: "jsdom",
     setupFiles: ["@testing-library/jest-dom/vitest"],
+    // The JUnit report lets the web runner show per-test results.
+    reporters: ["default", "junit"],
+    outputFile: { junit: "test-results.xml" },
   },
   resolve: {
     conditions: ["development", "browser"],
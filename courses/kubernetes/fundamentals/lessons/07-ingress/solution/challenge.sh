@@ -2,15 +2,6 @@
 set -euo pipefail
 SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
 
-echo "🔧 Installing NGINX Ingress Controller..."
-kubectl apply -f https://raw.githubusercontent.com/kubernetes/ingress-nginx/controller-v1.12.0/deploy/static/provider/cloud/deploy.yaml
-
-echo "⏳ Waiting for ingress controller..."
-kubectl wait --namespace ingress-nginx \
-  --for=condition=ready pod \
-  --selector=app.kubernetes.io/component=controller \
-  --timeout=120s
-
 echo "🚀 Deploying app..."
 kubectl apply -f "$SCRIPT_DIR/app.yaml"
 kubectl rollout status deployment/echo-deploy --timeout=90s
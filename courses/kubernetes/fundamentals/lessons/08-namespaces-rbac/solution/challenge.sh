@@ -1,18 +1,22 @@
 #!/usr/bin/env bash
 set -euo pipefail
 
+# On the course site you get a namespace of your own, named in
+# $KUBE_NAMESPACE, and can't create others. Run locally, use "sandbox".
+NS="${KUBE_NAMESPACE:-sandbox}"
+
 echo "🏗️  Creating namespace..."
-kubectl create namespace sandbox --dry-run=client -o yaml | kubectl apply -f -
+kubectl get namespace "$NS" >/dev/null 2>&1 || kubectl create namespace "$NS"
 
 echo "👤 Creating ServiceAccount..."
-kubectl create serviceaccount deployer -n sandbox --dry-run=client -o yaml | kubectl apply -f -
+kubectl create serviceaccount deployer -n "$NS" --dry-run=client -o yaml | kubectl apply -f -
 
 echo "🔐 Creating Role..."
-cat <<'YAML' | kubectl apply -f -
+cat <<YAML | kubectl apply -f -
 apiVersion: rbac.authorization.k8s.io/v1
 kind: Role
 metadata:
-  namespace: sandbox
+  namespace: $NS
   name: deploy-manager
 rules:
   - apiGroups: ["apps"]
@@ -21,16 +25,16 @@ rules:
 YAML
 
 echo "🔗 Creating RoleBinding..."
-cat <<'YAML' | kubectl apply -f -
+cat <<YAML | kubectl apply -f -
 apiVersion: rbac.authorization.k8s.io/v1
 kind: RoleBinding
 metadata:
-  namespace: sandbox
+  namespace: $NS
   name: deployer-binding
 subjects:
   - kind: ServiceAccount
     name: deployer
-    namespace: sandbox
+    namespace: $NS
 roleRef:
   kind: Role
   name: deploy-manager
@@ -38,16 +42,16 @@ roleRef:
 YAML
 
 echo "🚀 Deploying nginx..."
-kubectl create deployment web --image=nginx:1.27 --replicas=2 -n sandbox --dry-run=client -o yaml | kubectl apply -f -
-kubectl rollout status deployment/web -n sandbox --timeout=90s
+kubectl create deployment web --image=nginx:1.27 --replicas=2 -n "$NS" --dry-run=client -o yaml | kubectl apply -f -
+kubectl rollout status deployment/web -n "$NS" --timeout=90s
 
 echo "🔍 Checking permissions..."
 kubectl auth can-i list deployments.apps \
-  --as=system:serviceaccount:sandbox:deployer \
-  -n sandbox > ./can-list-deployments.txt 2>&1 || true
+  --as="system:serviceaccount:$NS:deployer" \
+  -n "$NS" > ./can-list-deployments.txt 2>&1 || true
 
 kubectl auth can-i list secrets \
-  --as=system:serviceaccount:sandbox:deployer \
-  -n sandbox > ./can-list-secrets.txt 2>&1 || true
+  --as="system:serviceaccount:$NS:deployer" \
+  -n "$NS" > ./can-list-secrets.txt 2>&1 || true
 
 echo "✅ Done!"
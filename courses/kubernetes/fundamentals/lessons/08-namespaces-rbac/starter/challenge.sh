@@ -1,16 +1,20 @@
 #!/usr/bin/env bash
 set -euo pipefail
 
+# On the course site you get a namespace of your own, named in
+# $KUBE_NAMESPACE, and can't create others. Run locally, use "sandbox".
+NS="${KUBE_NAMESPACE:-sandbox}"
+
 echo "🏗️  Creating namespace..."
-# TODO 1: Create namespace "sandbox"
+# TODO 1: Create namespace "$NS", unless it already exists
 
 
 echo "👤 Creating ServiceAccount..."
-# TODO 2: Create ServiceAccount "deployer" in namespace "sandbox"
+# TODO 2: Create ServiceAccount "deployer" in namespace "$NS"
 
 
 echo "🔐 Creating Role..."
-# TODO 3: Create Role "deploy-manager" in namespace "sandbox"
+# TODO 3: Create Role "deploy-manager" in namespace "$NS"
 #   Allows: get, list, create, update, delete on deployments (apiGroup: apps)
 
 
@@ -19,13 +23,13 @@ echo "🔗 Creating RoleBinding..."
 
 
 echo "🚀 Deploying nginx..."
-# TODO 5: Create a Deployment named "web" with 2 replicas of nginx:1.27 in namespace "sandbox"
+# TODO 5: Create a Deployment named "web" with 2 replicas of nginx:1.27 in namespace "$NS"
 #   Wait for rollout
 
 
 echo "🔍 Checking permissions..."
-# TODO 6: Check if deployer can list deployments.apps in sandbox → ./can-list-deployments.txt
-# TODO 7: Check if deployer can list secrets in sandbox → ./can-list-secrets.txt
+# TODO 6: Check if deployer can list deployments.apps in "$NS" → ./can-list-deployments.txt
+# TODO 7: Check if deployer can list secrets in "$NS" → ./can-list-secrets.txt
 
 
 echo "✅ Done!"
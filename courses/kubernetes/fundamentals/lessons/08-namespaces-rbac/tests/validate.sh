@@ -13,30 +13,44 @@ echo "🧪 Lesson 08: Namespaces & Access Control"
 echo "   Mode: $MODE"
 echo ""
 
-kubectl delete namespace sandbox --ignore-not-found --wait=false 2>/dev/null || true
+# On the course site the lesson works in the student's own namespace, which
+# stays; locally it gets a sandbox namespace to itself.
+NS="${KUBE_NAMESPACE:-sandbox}"
+cleanup() {
+  if [[ -n "${KUBE_NAMESPACE:-}" ]]; then
+    kubectl delete deployment web -n "$NS" --ignore-not-found --wait=false 2>/dev/null || true
+    kubectl delete rolebinding deployer-binding -n "$NS" --ignore-not-found 2>/dev/null || true
+    kubectl delete role deploy-manager -n "$NS" --ignore-not-found 2>/dev/null || true
+    kubectl delete serviceaccount deployer -n "$NS" --ignore-not-found 2>/dev/null || true
+  else
+    kubectl delete namespace sandbox --ignore-not-found --wait=false 2>/dev/null || true
+  fi
+}
+
+cleanup
 sleep 5
 
 cd "$WORK_DIR"
 bash challenge.sh 2>/dev/null
 
 # Test 1: Namespace exists
-NS=$(kubectl get namespace sandbox -o jsonpath='{.metadata.name}' 2>/dev/null || echo "")
-assert_eq "Namespace sandbox exists" "sandbox" "$NS"
+NS_NAME=$(kubectl get namespace "$NS" -o jsonpath='{.metadata.name}' 2>/dev/null || echo "")
+assert_eq "Namespace $NS exists" "$NS" "$NS_NAME"
 
 # Test 2: ServiceAccount exists
-SA=$(kubectl get serviceaccount deployer -n sandbox -o jsonpath='{.metadata.name}' 2>/dev/null || echo "")
+SA=$(kubectl get serviceaccount deployer -n "$NS" -o jsonpath='{.metadata.name}' 2>/dev/null || echo "")
 assert_eq "ServiceAccount deployer exists" "deployer" "$SA"
 
 # Test 3: Role exists with correct permissions
-ROLE_RESOURCES=$(kubectl get role deploy-manager -n sandbox -o jsonpath='{.rules[0].resources[0]}' 2>/dev/null || echo "")
+ROLE_RESOURCES=$(kubectl get role deploy-manager -n "$NS" -o jsonpath='{.rules[0].resources[0]}' 2>/dev/null || echo "")
 assert_eq "Role grants access to deployments" "deployments" "$ROLE_RESOURCES"
 
 # Test 4: RoleBinding exists
-RB=$(kubectl get rolebinding deployer-binding -n sandbox -o jsonpath='{.metadata.name}' 2>/dev/null || echo "")
+RB=$(kubectl get rolebinding deployer-binding -n "$NS" -o jsonpath='{.metadata.name}' 2>/dev/null || echo "")
 assert_eq "RoleBinding deployer-binding exists" "deployer-binding" "$RB"
 
-# Test 5: Deployment in sandbox namespace
-DEP_REPLICAS=$(kubectl get deployment web -n sandbox -o jsonpath='{.spec.replicas}' 2>/dev/null || echo "0")
+# Test 5: Deployment in the lesson namespace
+DEP_REPLICAS=$(kubectl get deployment web -n "$NS" -o jsonpath='{.spec.replicas}' 2>/dev/null || echo "0")
 assert_eq "Deployment web has 2 replicas" "2" "$DEP_REPLICAS"
 
 # Test 6: deployer CAN list deployments
@@ -56,7 +70,7 @@ fi
 assert_eq "deployer cannot list secrets" "no" "$CAN_SECRETS"
 
 # Cleanup
-kubectl delete namespace sandbox --ignore-not-found --wait=false 2>/dev/null || true
+cleanup
 rm -f ./can-list-deployments.txt ./can-list-secrets.txt
 
 print_results
@@ -53,12 +53,27 @@ else
     --wait
 fi
 
-# Merge kubeconfig into default location so kubectl just works for all processes
-mkdir -p ~/.kube
-k3d kubeconfig merge "${CLUSTER_NAME}" --kubeconfig-switch-context --kubeconfig-merge-default 2>/dev/null
+# Merge kubeconfig into default location so kubectl just works for all processes.
+# The web runner sets KUBE_NAMESPACE and points KUBECONFIG at a per-student
+# config for that namespace; leave that one alone.
+if [[ -z "${KUBE_NAMESPACE:-}" ]]; then
+  mkdir -p ~/.kube
+  k3d kubeconfig merge "${CLUSTER_NAME}" --kubeconfig-switch-context --kubeconfig-merge-default 2>/dev/null
+fi
 
 wait_for_cluster
 
+# ─── Ingress controller ─────────────────────────────────────────────────────
+# The controller is cluster-wide, so it comes with the cluster: students on
+# the web runner may only touch their own namespace.
+INGRESS_NGINX_MANIFEST="https://raw.githubusercontent.com/kubernetes/ingress-nginx/controller-v1.12.0/deploy/static/provider/cloud/deploy.yaml"
+echo "🔧  Installing the NGINX Ingress Controller..."
+kubectl apply -f "${INGRESS_NGINX_MANIFEST}" >/dev/null
+kubectl wait --namespace ingress-nginx \
+  --for=condition=ready pod \
+  --selector=app.kubernetes.io/component=controller \
+  --timeout="${TIMEOUT}s"
+
 echo "🎉  K3d cluster '${CLUSTER_NAME}' is up. kubectl is configured."
 echo "    Nodes:"
 kubectl get nodes -o wide
@@ -65,12 +65,12 @@ assert_ge() {
 }
 
 wait_for_rollout() {
-  local resource="$1" namespace="${2:-default}" timeout="${3:-90}"
+  local resource="$1" namespace="${2:-${KUBE_NAMESPACE:-default}}" timeout="${3:-90}"
   kubectl rollout status "$resource" -n "$namespace" --timeout="${timeout}s" 2>/dev/null
 }
 
 wait_for_pod_ready() {
-  local label="$1" namespace="${2:-default}" timeout="${3:-90}"
+  local label="$1" namespace="${2:-${KUBE_NAMESPACE:-default}}" timeout="${3:-90}"
   kubectl wait pod -l "$label" -n "$namespace" --for=condition=Ready --timeout="${timeout}s" 2>/dev/null
 }
 
@@ -6,6 +6,8 @@ import { CoursePage } from "@/pages/CoursePage";
 import { LessonPage } from "@/pages/LessonPage";
 import { LeaderboardPage } from "@/pages/LeaderboardPage";
 import { ProfilePage } from "@/pages/ProfilePage";
+import { GroupsPage } from "@/pages/GroupsPage";
+import { GroupPage } from "@/pages/GroupPage";
 
 function App() {
   return (
@@ -19,6 +21,8 @@ function App() {
           <Route path="/courses/:id/:slug" element={<LessonPage />} />
           <Route path="/leaderboard" element={<LeaderboardPage />} />
           <Route path="/profile" element={<ProfilePage />} />
+          <Route path="/groups" element={<GroupsPage />} />
+          <Route path="/groups/:groupId" element={<GroupPage />} />
         </Routes>
       </main>
     </div>
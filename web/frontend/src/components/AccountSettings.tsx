@@ -0,0 +1,112 @@
+import { useState } from "react";
+import { useQuery, useQueryClient } from "@tanstack/react-query";
+import { fetchSessions, revokeSession, setPassword } from "@/lib/api";
+import { useAuth } from "@/contexts/AuthContext";
+import { Button } from "@/components/ui/button";
+
+const PASSWORD_MIN_LENGTH = 8;
+
+export function AccountSettings() {
+  const { user, logout } = useAuth();
+  const queryClient = useQueryClient();
+  const [currentPassword, setCurrentPassword] = useState("");
+  const [newPassword, setNewPassword] = useState("");
+  const [message, setMessage] = useState("");
+
+  const { data: sessions } = useQuery({
+    queryKey: ["sessions"],
+    queryFn: fetchSessions,
+    enabled: !!user,
+  });
+
+  if (!user) return null;
+
+  const handleSetPassword = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (newPassword.length < PASSWORD_MIN_LENGTH) {
+      setMessage(`Password must be at least ${PASSWORD_MIN_LENGTH} characters`);
+      return;
+    }
+    try {
+      await setPassword(newPassword, user.has_password ? currentPassword : undefined);
+      setMessage("Password saved. Other devices have been signed out.");
+      setCurrentPassword("");
+      setNewPassword("");
+      queryClient.invalidateQueries({ queryKey: ["me"] });
+      queryClient.invalidateQueries({ queryKey: ["sessions"] });
+    } catch (err: any) {
+      setMessage(err.message?.includes("403") ? "Current password is wrong" : "Failed to save password");
+    }
+  };
+
+  const handleRevoke = async (id: string) => {
+    await revokeSession(id).catch(() => {});
+    queryClient.invalidateQueries({ queryKey: ["sessions"] });
+  };
+
+  const inputClass =
+    "w-full px-3 py-2 border rounded-md bg-background text-foreground focus:outline-none focus:ring-2 focus:ring-primary";
+
+  return (
+    <div className="mt-8 space-y-6">
+      <div>
+        <h2 className="text-lg font-semibold mb-1">Password</h2>
+        <p className="text-sm text-muted-foreground mb-3">
+          {user.has_password
+            ? "Change the password you use to log in on other devices."
+            : "Set a password so you can log in on another device."}
+        </p>
+        <form onSubmit={handleSetPassword} className="space-y-2 max-w-sm">
+          {user.has_password && (
+            <input
+              type="password"
+              value={currentPassword}
+              onChange={(e) => setCurrentPassword(e.target.value)}
+              placeholder="current password"
+              autoComplete="current-password"
+              className={inputClass}
+            />
+          )}
+          <input
+            type="password"
+            value={newPassword}
+            onChange={(e) => setNewPassword(e.target.value)}
+            placeholder="new password"
+            autoComplete="new-password"
+            maxLength={128}
+            className={inputClass}
+          />
+          {message && <p className="text-sm text-muted-foreground">{message}</p>}
+          <Button type="submit" size="sm">
+            {user.has_password ? "Change password" : "Set password"}
+          </Button>
+        </form>
+      </div>
+
+      <div>
+        <h2 className="text-lg font-semibold mb-3">Sessions</h2>
+        <div className="space-y-2">
+          {sessions?.map((s) => (
+            <div key={s.id} className="flex items-center gap-3 p-3 rounded-lg border">
+              <div className="flex-1 min-w-0">
+                <p className="text-sm font-medium truncate">{s.user_agent || "Unknown device"}</p>
+                <p className="text-xs text-muted-foreground">
+                  signed in {new Date(s.created_at + "Z").toLocaleDateString()}
+                  {s.current && " · this device"}
+                </p>
+              </div>
+              {!s.current && (
+                <Button size="sm" variant="outline" onClick={() => handleRevoke(s.id)}>
+                  Sign out
+                </Button>
+              )}
+            </div>
+          ))}
+        </div>
+        <Button className="mt-4" variant="outline" onClick={logout}>
+          Log out
+        </Button>
+      </div>
+    </div>
+  );
+}
@@ -1,5 +1,5 @@
 import { Link, useParams } from "react-router-dom";
-import { TrainFront, Trophy, User } from "lucide-react";
+import { TrainFront, Trophy, User, Users } from "lucide-react";
 import { Button } from "@/components/ui/button";
 import { useTheme } from "./ThemeProvider";
 import { useAuth } from "@/contexts/AuthContext";
@@ -43,6 +43,14 @@ export function Header() {
             <span className="hidden sm:inline">Leaderboard</span>
           </Button>
         </Link>
+        {user && (
+          <Link to="/groups" className="text-muted-foreground hover:text-foreground">
+            <Button variant="ghost" size="sm" className="gap-1">
+              <Users className="size-4" />
+              <span className="hidden sm:inline">Groups</span>
+            </Button>
+          </Link>
+        )}
         {user ? (
           <Link to="/profile" className="text-muted-foreground hover:text-foreground">
             <Button variant="ghost" size="sm" className="gap-1">
@@ -0,0 +1,84 @@
+import { useState } from "react";
+import { keepPreviousData, useQuery } from "@tanstack/react-query";
+import { fetchRun, fetchRuns, type Run } from "@/lib/api";
+import { Button } from "@/components/ui/button";
+
+const PAGE_SIZE = 20;
+
+function formatDuration(ms: number) {
+  return ms < 1000 ? `${ms} ms` : `${(ms / 1000).toFixed(1)} s`;
+}
+
+export function RunHistory() {
+  const [page, setPage] = useState(0);
+  const [openId, setOpenId] = useState<number | null>(null);
+
+  const { data } = useQuery({
+    queryKey: ["runs", page],
+    queryFn: () => fetchRuns({ limit: PAGE_SIZE, offset: page * PAGE_SIZE }),
+    placeholderData: keepPreviousData,
+  });
+  const { data: openRun } = useQuery({
+    queryKey: ["run", openId],
+    queryFn: () => fetchRun(openId!),
+    enabled: openId !== null,
+  });
+
+  if (!data || data.total === 0) return null;
+  const pageCount = Math.ceil(data.total / PAGE_SIZE);
+
+  const status = (run: Run) => {
+    if (run.error) return <span className="text-yellow-500">error</span>;
+    if (run.exit_code === 0) return <span className="text-green-500">passed</span>;
+    return <span className="text-red-500">failed</span>;
+  };
+
+  return (
+    <div className="mt-8">
+      <h2 className="text-lg font-semibold mb-3">Test runs</h2>
+      <div className="border rounded-lg divide-y">
+        {data.runs.map((run) => (
+          <div key={run.id}>
+            <button
+              type="button"
+              onClick={() => setOpenId(openId === run.id ? null : run.id)}
+              className="w-full flex items-center gap-3 px-3 py-2 text-sm text-left hover:bg-muted/50"
+            >
+              <span className="flex-1 min-w-0 truncate">
+                {run.course_id} / {run.lesson_slug}
+              </span>
+              {run.passed + run.failed > 0 && (
+                <span className="text-xs text-muted-foreground font-mono">
+                  {run.passed}/{run.passed + run.failed}
+                </span>
+              )}
+              <span className="w-14 text-right text-xs">{status(run)}</span>
+              <span className="w-16 text-right text-xs text-muted-foreground">{formatDuration(run.duration_ms)}</span>
+              <span className="w-36 text-right text-xs text-muted-foreground">
+                {new Date(run.started_at + "Z").toLocaleString()}
+              </span>
+            </button>
+            {openId === run.id && (
+              <pre className="px-3 py-2 text-xs bg-muted/30 overflow-auto max-h-80 whitespace-pre-wrap">
+                {openRun?.id === run.id ? openRun.output || run.error || "(no output)" : "Loading..."}
+              </pre>
+            )}
+          </div>
+        ))}
+      </div>
+      {pageCount > 1 && (
+        <div className="flex items-center justify-between mt-3 text-sm text-muted-foreground">
+          <Button size="sm" variant="outline" disabled={page === 0} onClick={() => setPage(page - 1)}>
+            Newer
+          </Button>
+          <span>
+            Page {page + 1} of {pageCount}
+          </span>
+          <Button size="sm" variant="outline" disabled={page + 1 >= pageCount} onClick={() => setPage(page + 1)}>
+            Older
+          </Button>
+        </div>
+      )}
+    </div>
+  );
+}
@@ -4,11 +4,14 @@ import "@xterm/xterm/css/xterm.css";
 
 interface TerminalPanelProps {
   courseId: string;
+  lessonSlug: string;
+  files: Record<string, string>;
+  onFileChange: (path: string, content: string) => void;
   visible: boolean;
 }
 
-export function TerminalPanel({ courseId, visible }: TerminalPanelProps) {
-  const { attach, refit, status, reconnect } = useTerminal({ courseId });
+export function TerminalPanel({ courseId, lessonSlug, files, onFileChange, visible }: TerminalPanelProps) {
+  const { attach, refit, status, reconnect } = useTerminal({ courseId, lessonSlug, files, onFileChange });
 
   // Re-fit when becoming visible (container may have resized while hidden)
   useEffect(() => {
@@ -3,9 +3,11 @@ import { useRef, useEffect } from "react";
 interface TestOutputProps {
   lines: { type: string; data: string }[];
   isRunning: boolean;
+  queuePosition?: number | null;
+  clusterState?: string | null;
 }
 
-export function TestOutput({ lines, isRunning }: TestOutputProps) {
+export function TestOutput({ lines, isRunning, queuePosition, clusterState }: TestOutputProps) {
   const bottomRef = useRef<HTMLDivElement>(null);
 
   useEffect(() => {
@@ -23,7 +25,13 @@ export function TestOutput({ lines, isRunning }: TestOutputProps) {
         </div>
       ))}
       {isRunning && (
-        <div className="text-yellow-400 animate-pulse">Running tests...</div>
+        <div className="text-yellow-400 animate-pulse">
+          {clusterState
+            ? `Waiting for the Kubernetes cluster (${clusterState})...`
+            : queuePosition
+              ? `Waiting for a free runner (position ${queuePosition} in queue)...`
+              : "Running tests..."}
+        </div>
       )}
       <div ref={bottomRef} />
     </div>
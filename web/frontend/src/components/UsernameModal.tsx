@@ -1,15 +1,25 @@
 import { useState } from "react";
+import { useQuery } from "@tanstack/react-query";
 import { useAuth } from "@/contexts/AuthContext";
+import { fetchAuthProviders, ssoLoginURL } from "@/lib/api";
 import { Card, CardHeader, CardTitle, CardDescription, CardContent } from "@/components/ui/card";
 import { Button } from "@/components/ui/button";
 
 const USERNAME_REGEX = /^[a-zA-Z0-9_]{2,24}$/;
+const PASSWORD_MIN_LENGTH = 8;
 
 export function UsernameModal() {
-  const { user, isLoading, login, logout } = useAuth();
+  const { user, isLoading, register, login, skip } = useAuth();
+  const [mode, setMode] = useState<"register" | "login">("register");
   const [username, setUsername] = useState("");
+  const [password, setPassword] = useState("");
   const [error, setError] = useState("");
   const [submitting, setSubmitting] = useState(false);
+  const { data: providers } = useQuery({
+    queryKey: ["auth-providers"],
+    queryFn: fetchAuthProviders,
+    staleTime: Infinity,
+  });
 
   const dismissed = localStorage.getItem("vt_guest") === "true";
 
@@ -23,14 +33,24 @@ export function UsernameModal() {
       setError("2-24 characters, letters, numbers, and underscores only");
       return;
     }
+    if ((mode === "login" || password) && password.length < PASSWORD_MIN_LENGTH) {
+      setError(`Password must be at least ${PASSWORD_MIN_LENGTH} characters`);
+      return;
+    }
 
     setSubmitting(true);
     try {
-      await login(username);
+      if (mode === "login") {
+        await login(username, password);
+      } else {
+        await register(username, password);
+      }
     } catch (err: any) {
-      const msg = err.message || "Failed to create user";
+      const msg = err.message || "Failed to sign in";
       if (msg.includes("409")) {
         setError("Username already taken");
+      } else if (msg.includes("401")) {
+        setError("Wrong username or password");
       } else {
         setError(msg);
       }
@@ -39,16 +59,30 @@ export function UsernameModal() {
     }
   };
 
+  const isLogin = mode === "login";
+
   return (
     <div className="fixed inset-0 z-50 flex items-center justify-center bg-black/50">
       <Card className="w-full max-w-sm mx-4">
         <CardHeader>
-          <CardTitle>Choose a username</CardTitle>
-          <CardDescription>Track your progress and earn points as you learn.</CardDescription>
+          <CardTitle>{isLogin ? "Log in" : "Choose a username"}</CardTitle>
+          <CardDescription>
+            {isLogin
+              ? "Pick up where you left off on another device."
+              : "Track your progress and earn points as you learn."}
+          </CardDescription>
         </CardHeader>
         <CardContent>
+          {providers?.oidc && (
+            <>
+              <Button asChild variant="outline" className="w-full">
+                <a href={ssoLoginURL(window.location.pathname)}>Sign in with {providers.oidc.name}</a>
+              </Button>
+              <p className="my-3 text-center text-xs text-muted-foreground">or</p>
+            </>
+          )}
           <form onSubmit={handleSubmit} className="space-y-4">
-            <div>
+            <div className="space-y-2">
               <input
                 type="text"
                 value={username}
@@ -56,18 +90,40 @@ export function UsernameModal() {
                 placeholder="username"
                 className="w-full px-3 py-2 border rounded-md bg-background text-foreground focus:outline-none focus:ring-2 focus:ring-primary"
                 autoFocus
+                autoComplete="username"
                 maxLength={24}
               />
+              <input
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                placeholder={isLogin ? "password" : "password (optional, to log in elsewhere)"}
+                className="w-full px-3 py-2 border rounded-md bg-background text-foreground focus:outline-none focus:ring-2 focus:ring-primary"
+                autoComplete={isLogin ? "current-password" : "new-password"}
+                maxLength={128}
+              />
               {error && <p className="mt-1 text-sm text-destructive">{error}</p>}
             </div>
             <div className="flex gap-2">
               <Button type="submit" className="flex-1" disabled={submitting}>
-                {submitting ? "Creating..." : "Claim username"}
+                {isLogin
+                  ? submitting ? "Logging in..." : "Log in"
+                  : submitting ? "Creating..." : "Claim username"}
               </Button>
-              <Button type="button" variant="ghost" onClick={logout}>
+              <Button type="button" variant="ghost" onClick={skip}>
                 Skip
               </Button>
             </div>
+            <button
+              type="button"
+              className="text-sm text-muted-foreground hover:text-foreground"
+              onClick={() => {
+                setMode(isLogin ? "register" : "login");
+                setError("");
+              }}
+            >
+              {isLogin ? "New here? Choose a username" : "Already have an account? Log in"}
+            </button>
           </form>
         </CardContent>
       </Card>
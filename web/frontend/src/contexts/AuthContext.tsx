@@ -1,12 +1,14 @@
 import { createContext, useContext, useState, useCallback, type ReactNode } from "react";
 import { useQueryClient, useQuery } from "@tanstack/react-query";
-import { fetchMe, createUser, type User } from "@/lib/api";
+import { fetchMe, createUser, login as loginRequest, logout as logoutRequest, type User } from "@/lib/api";
 
 interface AuthContextValue {
   user: User | null;
   isLoading: boolean;
-  login: (username: string) => Promise<void>;
-  logout: () => void;
+  register: (username: string, password?: string) => Promise<void>;
+  login: (username: string, password: string) => Promise<void>;
+  logout: () => Promise<void>;
+  skip: () => void;
 }
 
 const AuthContext = createContext<AuthContextValue | null>(null);
@@ -24,20 +26,36 @@ export function AuthProvider({ children }: { children: ReactNode }) {
     staleTime: 5 * 60 * 1000, // 5 minutes — refetch on invalidation after completions
   });
 
-  const login = useCallback(async (username: string) => {
-    await createUser(username);
+  const refreshUser = useCallback(async () => {
     await queryClient.invalidateQueries({ queryKey: ["me"] });
     // Also refetch courses since they now include user progress
     await queryClient.invalidateQueries({ queryKey: ["courses"] });
   }, [queryClient]);
 
-  const logout = useCallback(() => {
+  const register = useCallback(async (username: string, password?: string) => {
+    await createUser(username, password || undefined);
+    await refreshUser();
+  }, [refreshUser]);
+
+  const login = useCallback(async (username: string, password: string) => {
+    await loginRequest(username, password);
+    await refreshUser();
+  }, [refreshUser]);
+
+  const logout = useCallback(async () => {
+    await logoutRequest();
+    queryClient.setQueryData(["me"], null);
+    queryClient.removeQueries({ queryKey: ["progress"] });
+    await queryClient.invalidateQueries({ queryKey: ["courses"] });
+  }, [queryClient]);
+
+  const skip = useCallback(() => {
     setDismissed(true);
     localStorage.setItem("vt_guest", "true");
   }, []);
 
   return (
-    <AuthContext.Provider value={{ user: user ?? null, isLoading, login, logout }}>
+    <AuthContext.Provider value={{ user: user ?? null, isLoading, register, login, logout, skip }}>
       {children}
     </AuthContext.Provider>
   );
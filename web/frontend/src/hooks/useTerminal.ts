@@ -6,21 +6,54 @@ type TerminalStatus = "connected" | "disconnected";
 
 interface UseTerminalOptions {
   courseId: string;
+  lessonSlug: string;
+  // The editor's files. The terminal starts with them and gets every edit.
+  files: Record<string, string>;
+  // Called when a file changes in the terminal.
+  onFileChange: (path: string, content: string) => void;
 }
 
-export function useTerminal({ courseId }: UseTerminalOptions) {
+// How long the editor must be idle before edits are sent to the terminal.
+const FILE_SYNC_DELAY = 300;
+// How many times a dropped connection is retried before giving up, and how
+// long to wait before the first retry (later ones wait longer).
+const MAX_RECONNECTS = 3;
+const RECONNECT_DELAY = 1000;
+
+// The server keeps a terminal's shell running for a while after the socket
+// drops. The session ID is kept per tab so a reload reattaches to it.
+function sessionKey(courseId: string, lessonSlug: string) {
+  return `vt-terminal:${courseId}:${lessonSlug}`;
+}
+
+export function useTerminal({ courseId, lessonSlug, files, onFileChange }: UseTerminalOptions) {
   const termRef = useRef<Terminal | null>(null);
   const fitRef = useRef<FitAddon | null>(null);
   const wsRef = useRef<WebSocket | null>(null);
   const containerRef = useRef<HTMLDivElement | null>(null);
   const [status, setStatus] = useState<TerminalStatus>("disconnected");
+  const filesRef = useRef(files);
+  filesRef.current = files;
+  const onFileChangeRef = useRef(onFileChange);
+  onFileChangeRef.current = onFileChange;
+  // What the terminal's copy of each file contains, as far as we know
+  const syncedRef = useRef<Record<string, string>>({});
+  const reconnectsRef = useRef(0);
+  const reconnectTimerRef = useRef<ReturnType<typeof setTimeout> | null>(null);
+  const reconnectRef = useRef<() => void>(() => {});
 
   const refit = useCallback(() => {
     fitRef.current?.fit();
   }, []);
 
   const cleanup = useCallback(() => {
+    if (reconnectTimerRef.current) {
+      clearTimeout(reconnectTimerRef.current);
+      reconnectTimerRef.current = null;
+    }
     if (wsRef.current) {
+      // Closing on purpose: leave the session for the next connection
+      wsRef.current.onclose = null;
       wsRef.current.close();
       wsRef.current = null;
     }
@@ -57,8 +90,23 @@ export function useTerminal({ courseId }: UseTerminalOptions) {
       const ws = new WebSocket(`${protocol}//${window.location.host}/api/terminal`);
       wsRef.current = ws;
 
+      const key = sessionKey(courseId, lessonSlug);
+      const init = () => {
+        ws.send(JSON.stringify({ type: "init", course_id: courseId, lesson_slug: lessonSlug, code: filesRef.current }));
+      };
+      // Set once the server ends the session or refuses the connection;
+      // retrying wouldn't help then.
+      let ended = false;
+
       ws.onopen = () => {
-        ws.send(JSON.stringify({ type: "init", course_id: courseId }));
+        syncedRef.current = { ...filesRef.current };
+        const sessionId = sessionStorage.getItem(key);
+        if (sessionId) {
+          ws.send(JSON.stringify({ type: "attach", session_id: sessionId, code: filesRef.current }));
+        } else {
+          init();
+        }
+        ws.send(JSON.stringify({ type: "resize", cols: term.cols, rows: term.rows }));
         setStatus("connected");
       };
 
@@ -66,11 +114,35 @@ export function useTerminal({ courseId }: UseTerminalOptions) {
         const msg = JSON.parse(event.data);
         if (msg.type === "output") {
           term.write(msg.data);
+        } else if (msg.type === "session") {
+          sessionStorage.setItem(key, msg.session_id);
+          reconnectsRef.current = 0;
+        } else if (msg.type === "expired") {
+          sessionStorage.removeItem(key);
+          init();
+        } else if (msg.type === "file") {
+          syncedRef.current[msg.path] = msg.data;
+          onFileChangeRef.current(msg.path, msg.data);
+        } else if (msg.type === "exit") {
+          ended = true;
+          sessionStorage.removeItem(key);
+          if (msg.data) {
+            term.write(`\r\n\x1b[90m${msg.data}\x1b[0m`);
+          }
+        } else if (msg.type === "error") {
+          ended = true;
+          term.write(`\x1b[31m${msg.data}\x1b[0m\r\n`);
         }
       };
 
       ws.onclose = () => {
         setStatus("disconnected");
+        if (!ended && reconnectsRef.current < MAX_RECONNECTS) {
+          reconnectsRef.current++;
+          term.write("\r\n\x1b[90m[connection lost, reconnecting...]\x1b[0m\r\n");
+          reconnectTimerRef.current = setTimeout(() => reconnectRef.current(), RECONNECT_DELAY * reconnectsRef.current);
+          return;
+        }
         term.write("\r\n\x1b[90m[session ended]\x1b[0m\r\n");
       };
 
@@ -92,12 +164,13 @@ export function useTerminal({ courseId }: UseTerminalOptions) {
         }
       });
     },
-    [courseId]
+    [courseId, lessonSlug]
   );
 
   const attach = useCallback(
     (el: HTMLDivElement | null) => {
       cleanup();
+      reconnectsRef.current = 0;
       containerRef.current = el;
       if (!el) return;
       createSession(el);
@@ -111,6 +184,22 @@ export function useTerminal({ courseId }: UseTerminalOptions) {
     if (!el) return;
     createSession(el);
   }, [cleanup, createSession]);
+  reconnectRef.current = reconnect;
+
+  // Send editor changes to the terminal
+  useEffect(() => {
+    const timer = setTimeout(() => {
+      const ws = wsRef.current;
+      if (!ws || ws.readyState !== WebSocket.OPEN) return;
+      for (const [path, content] of Object.entries(files)) {
+        if (syncedRef.current[path] !== content) {
+          syncedRef.current[path] = content;
+          ws.send(JSON.stringify({ type: "file", path, data: content }));
+        }
+      }
+    }, FILE_SYNC_DELAY);
+    return () => clearTimeout(timer);
+  }, [files]);
 
   // Handle window resize
   useEffect(() => {
@@ -122,6 +211,8 @@ export function useTerminal({ courseId }: UseTerminalOptions) {
   // Cleanup on unmount
   useEffect(() => {
     return () => {
+      if (reconnectTimerRef.current) clearTimeout(reconnectTimerRef.current);
+      if (wsRef.current) wsRef.current.onclose = null;
       wsRef.current?.close();
       termRef.current?.dispose();
     };
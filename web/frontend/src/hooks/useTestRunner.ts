@@ -1,36 +1,71 @@
 import { useState, useCallback, useRef } from "react";
 
 interface RunMessage {
-  type: "stdout" | "stderr" | "exit" | "error";
+  type: "cluster" | "queued" | "stdout" | "stderr" | "test_start" | "test_pass" | "test_fail" | "test_skip" | "summary" | "exit" | "error";
   data: string;
   points?: number;
+  position?: number;
+  test?: string;
+  duration?: number;
+  message?: string;
+  passed?: number;
+  failed?: number;
+  skipped?: number;
+  total?: number;
+}
+
+export interface TestResult {
+  name: string;
+  status: "pass" | "fail" | "skip";
+  duration?: number;
+  message?: string;
+}
+
+export interface TestSummary {
+  passed: number;
+  failed: number;
+  skipped: number;
+  total: number;
 }
 
 interface UseTestRunnerReturn {
   output: RunMessage[];
+  results: TestResult[];
+  summary: TestSummary | null;
   isRunning: boolean;
+  queuePosition: number | null;
+  clusterState: string | null;
   exitCode: number | null;
   pointsEarned: number | null;
-  runTests: (courseId: string, lessonSlug: string, code: Record<string, string>, viewedSolution?: boolean) => void;
+  runTests: (courseId: string, lessonSlug: string, code: Record<string, string>) => void;
+  cancelRun: () => void;
   reset: () => void;
 }
 
 export function useTestRunner(): UseTestRunnerReturn {
   const [output, setOutput] = useState<RunMessage[]>([]);
+  const [results, setResults] = useState<TestResult[]>([]);
+  const [summary, setSummary] = useState<TestSummary | null>(null);
   const [isRunning, setIsRunning] = useState(false);
+  const [queuePosition, setQueuePosition] = useState<number | null>(null);
+  const [clusterState, setClusterState] = useState<string | null>(null);
   const [exitCode, setExitCode] = useState<number | null>(null);
   const [pointsEarned, setPointsEarned] = useState<number | null>(null);
   const wsRef = useRef<WebSocket | null>(null);
 
   const runTests = useCallback(
-    (courseId: string, lessonSlug: string, code: Record<string, string>, viewedSolution: boolean = false) => {
+    (courseId: string, lessonSlug: string, code: Record<string, string>) => {
       // Close existing connection
       if (wsRef.current) {
         wsRef.current.close();
       }
 
       setOutput([]);
+      setResults([]);
+      setSummary(null);
       setIsRunning(true);
+      setQueuePosition(null);
+      setClusterState(null);
       setExitCode(null);
       setPointsEarned(null);
 
@@ -44,20 +79,44 @@ export function useTestRunner(): UseTestRunnerReturn {
             course_id: courseId,
             lesson_slug: lessonSlug,
             code,
-            viewed_solution: viewedSolution,
           })
         );
       };
 
       ws.onmessage = (event) => {
         const msg: RunMessage = JSON.parse(event.data);
+        if (msg.type === "cluster") {
+          setClusterState(msg.data);
+          return;
+        }
+        setClusterState(null);
+        if (msg.type === "queued") {
+          setQueuePosition(msg.position ?? null);
+          return;
+        }
+        setQueuePosition(null);
         if (msg.type === "exit") {
           setExitCode(parseInt(msg.data, 10));
           if (msg.points && msg.points > 0) {
             setPointsEarned(msg.points);
           }
           setIsRunning(false);
-        } else {
+        } else if (msg.type === "test_pass" || msg.type === "test_fail" || msg.type === "test_skip") {
+          const result: TestResult = {
+            name: msg.test ?? "",
+            status: msg.type.slice(5) as TestResult["status"],
+            duration: msg.duration,
+            message: msg.message,
+          };
+          setResults((prev) => [...prev, result]);
+        } else if (msg.type === "summary") {
+          setSummary({
+            passed: msg.passed ?? 0,
+            failed: msg.failed ?? 0,
+            skipped: msg.skipped ?? 0,
+            total: msg.total ?? 0,
+          });
+        } else if (msg.type !== "test_start") {
           setOutput((prev) => [...prev, msg]);
         }
       };
@@ -73,20 +132,33 @@ export function useTestRunner(): UseTestRunnerReturn {
 
       ws.onclose = () => {
         setIsRunning(false);
+        setQueuePosition(null);
+        setClusterState(null);
       };
     },
     []
   );
 
+  // Ask the runner to stop the run; it replies with an error and the exit.
+  const cancelRun = useCallback(() => {
+    if (wsRef.current?.readyState === WebSocket.OPEN) {
+      wsRef.current.send(JSON.stringify({ type: "cancel" }));
+    }
+  }, []);
+
   const reset = useCallback(() => {
     if (wsRef.current) {
       wsRef.current.close();
     }
     setOutput([]);
+    setResults([]);
+    setSummary(null);
     setIsRunning(false);
+    setQueuePosition(null);
+    setClusterState(null);
     setExitCode(null);
     setPointsEarned(null);
   }, []);
 
-  return { output, isRunning, exitCode, pointsEarned, runTests, reset };
+  return { output, results, summary, isRunning, queuePosition, clusterState, exitCode, pointsEarned, runTests, cancelRun, reset };
 }
@@ -33,14 +33,44 @@ export interface LessonDetail {
   title: string;
   readme: string;
   starter_code: Record<string, string>;
+  has_solution: boolean;
+  previous_code?: Record<string, string>;
+  previous_lesson?: string;
+}
+
+export interface LessonSolution {
+  slug: string;
   solution_code: Record<string, string>;
 }
 
+export interface DraftRevision {
+  revision: number;
+  saved_at: string;
+}
+
+export interface Draft extends DraftRevision {
+  code: Record<string, string>;
+  revisions: DraftRevision[];
+}
+
 export interface User {
   id: string;
   username: string;
   created_at: string;
   total_points?: number;
+  has_password?: boolean;
+}
+
+export interface AuthProviders {
+  oidc?: { name: string };
+}
+
+export interface Session {
+  id: string;
+  user_agent: string;
+  created_at: string;
+  expires_at: string;
+  current: boolean;
 }
 
 export interface UserProgress {
@@ -60,12 +90,112 @@ export interface Completion {
 }
 
 export interface LeaderboardEntry {
+  rank: number;
   user_id: string;
   username: string;
   total_points: number;
   completed_count: number;
 }
 
+export type LeaderboardWindow = "all" | "month" | "week";
+
+export interface LeaderboardParams {
+  groupId?: string; // rank only this group's students
+  courseId?: string;
+  since?: LeaderboardWindow;
+  limit?: number;
+  offset?: number;
+}
+
+export interface Leaderboard {
+  entries: LeaderboardEntry[];
+  total: number;
+  limit: number;
+  offset: number;
+  since?: string;
+  me: LeaderboardEntry | null;
+}
+
+export type GroupRole = "instructor" | "student";
+
+export interface Group {
+  id: string;
+  name: string;
+  course_id?: string;
+  join_code?: string; // instructors only
+  created_at: string;
+  members: number;
+  role?: GroupRole;
+}
+
+export interface GroupMember {
+  user_id: string;
+  username: string;
+  role: GroupRole;
+  joined_at: string;
+}
+
+export interface GroupDetail {
+  group: Group;
+  members: GroupMember[];
+}
+
+export interface LessonStatus {
+  completed: boolean;
+  completed_at?: string;
+  points: number;
+  attempts: number;
+  viewed_solution: boolean;
+}
+
+export interface GroupDashboard {
+  group: Group;
+  course: { id: string; title: string };
+  lessons: LessonSummary[];
+  students: {
+    user_id: string;
+    username: string;
+    completed: number;
+    lessons: Record<string, LessonStatus>;
+  }[];
+}
+
+export interface Run {
+  id: number;
+  course_id: string;
+  lesson_slug: string;
+  exit_code: number;
+  duration_ms: number;
+  passed: number;
+  failed: number;
+  skipped: number;
+  error?: string;
+  started_at: string;
+  output_size: number;
+  output?: string; // only when fetching a single run
+}
+
+export interface RunPage {
+  runs: Run[];
+  total: number;
+  limit: number;
+  offset: number;
+}
+
+export interface CourseStatus {
+  id: string;
+  title: string;
+  runnable: boolean;
+  message?: string;
+  cluster?: { state: "provisioning" | "ready" | "failed" | "draining"; since: string };
+  npm_cache?: "ready" | "pending" | "failed";
+}
+
+export interface ServiceStatus {
+  courses: CourseStatus[];
+  runs: { running: number; queued: number };
+}
+
 const BASE = "/api";
 
 async function fetchJSON<T>(path: string, init?: RequestInit): Promise<T> {
@@ -74,9 +204,14 @@ async function fetchJSON<T>(path: string, init?: RequestInit): Promise<T> {
     ...init,
   });
   if (!res.ok) throw new Error(`${res.status}: ${res.statusText}`);
+  if (res.status === 204) return undefined as T;
   return res.json();
 }
 
+export function fetchStatus() {
+  return fetchJSON<ServiceStatus>("/status");
+}
+
 export function fetchCourses() {
   return fetchJSON<CourseListItem[]>("/courses");
 }
@@ -89,14 +224,68 @@ export function fetchLesson(courseId: string, slug: string) {
   return fetchJSON<LessonDetail>(`/courses/${courseId}/lessons/${slug}`);
 }
 
-export function createUser(username: string) {
+export function fetchSolution(courseId: string, slug: string) {
+  return fetchJSON<LessonSolution>(`/courses/${courseId}/lessons/${slug}/solution`);
+}
+
+export function fetchDraft(courseId: string, slug: string, revision?: number) {
+  const query = revision ? `?revision=${revision}` : "";
+  return fetchJSON<Draft>(`/courses/${courseId}/lessons/${slug}/draft${query}`);
+}
+
+export function saveDraft(courseId: string, slug: string, code: Record<string, string>) {
+  return fetchJSON<DraftRevision>(`/courses/${courseId}/lessons/${slug}/draft`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ code }),
+  });
+}
+
+export function createUser(username: string, password?: string) {
   return fetchJSON<User>("/users", {
     method: "POST",
     headers: { "Content-Type": "application/json" },
-    body: JSON.stringify({ username }),
+    body: JSON.stringify({ username, password }),
+  });
+}
+
+export function login(username: string, password: string) {
+  return fetchJSON<User>("/auth/login", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ username, password }),
   });
 }
 
+export function fetchAuthProviders() {
+  return fetchJSON<AuthProviders>("/auth/providers");
+}
+
+// Single sign-on is a full-page redirect through the identity provider
+export function ssoLoginURL(returnTo: string) {
+  return `${BASE}/auth/login?return_to=${encodeURIComponent(returnTo)}`;
+}
+
+export function logout() {
+  return fetchJSON<void>("/auth/logout", { method: "POST" });
+}
+
+export function setPassword(newPassword: string, currentPassword?: string) {
+  return fetchJSON<void>("/users/me/password", {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ current_password: currentPassword, new_password: newPassword }),
+  });
+}
+
+export function fetchSessions() {
+  return fetchJSON<Session[]>("/auth/sessions");
+}
+
+export function revokeSession(id: string) {
+  return fetchJSON<void>(`/auth/sessions/${id}`, { method: "DELETE" });
+}
+
 export function fetchMe() {
   return fetchJSON<User>("/users/me");
 }
@@ -105,6 +294,72 @@ export function fetchProgress() {
   return fetchJSON<UserProgress>("/users/me/progress");
 }
 
-export function fetchLeaderboard() {
-  return fetchJSON<LeaderboardEntry[]>("/leaderboard");
+export function fetchRuns(params: { courseId?: string; lesson?: string; limit?: number; offset?: number } = {}) {
+  const q = new URLSearchParams();
+  if (params.courseId) q.set("course_id", params.courseId);
+  if (params.lesson) q.set("lesson", params.lesson);
+  if (params.limit) q.set("limit", String(params.limit));
+  if (params.offset) q.set("offset", String(params.offset));
+  const qs = q.toString();
+  return fetchJSON<RunPage>(`/users/me/runs${qs ? `?${qs}` : ""}`);
+}
+
+export function fetchRun(id: number) {
+  return fetchJSON<Run>(`/users/me/runs/${id}`);
+}
+
+export function fetchLeaderboard(params: LeaderboardParams = {}) {
+  const q = new URLSearchParams();
+  if (params.courseId) q.set("course_id", params.courseId);
+  if (params.since && params.since !== "all") q.set("since", params.since);
+  if (params.limit) q.set("limit", String(params.limit));
+  if (params.offset) q.set("offset", String(params.offset));
+  const qs = q.toString();
+  const path = params.groupId ? `/groups/${params.groupId}/leaderboard` : "/leaderboard";
+  return fetchJSON<Leaderboard>(`${path}${qs ? `?${qs}` : ""}`);
+}
+
+export function fetchGroups() {
+  return fetchJSON<Group[]>("/groups");
+}
+
+export function fetchGroup(id: string) {
+  return fetchJSON<GroupDetail>(`/groups/${id}`);
+}
+
+export function createGroup(name: string, courseId?: string) {
+  return fetchJSON<Group>("/groups", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ name, course_id: courseId }),
+  });
+}
+
+export function joinGroup(code: string) {
+  return fetchJSON<Group>("/groups/join", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ code }),
+  });
+}
+
+export function rotateJoinCode(groupId: string) {
+  return fetchJSON<{ join_code: string }>(`/groups/${groupId}/code`, { method: "POST" });
+}
+
+export function setGroupRole(groupId: string, userId: string, role: GroupRole) {
+  return fetchJSON<void>(`/groups/${groupId}/members/${userId}`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ role }),
+  });
+}
+
+export function removeGroupMember(groupId: string, userId: string) {
+  return fetchJSON<void>(`/groups/${groupId}/members/${userId}`, { method: "DELETE" });
+}
+
+export function fetchGroupDashboard(groupId: string, courseId?: string) {
+  const query = courseId ? `?course_id=${encodeURIComponent(courseId)}` : "";
+  return fetchJSON<GroupDashboard>(`/groups/${groupId}/dashboard${query}`);
 }
@@ -0,0 +1,238 @@
+import { useState } from "react";
+import { useNavigate, useParams } from "react-router-dom";
+import { useQuery, useQueryClient } from "@tanstack/react-query";
+import {
+  fetchCourses,
+  fetchGroup,
+  fetchGroupDashboard,
+  fetchLeaderboard,
+  removeGroupMember,
+  rotateJoinCode,
+  setGroupRole,
+  type GroupRole,
+  type LessonStatus,
+} from "@/lib/api";
+import { useAuth } from "@/contexts/AuthContext";
+import { Badge } from "@/components/ui/badge";
+import { Button } from "@/components/ui/button";
+
+export function GroupPage() {
+  const { groupId } = useParams<{ groupId: string }>();
+  const { user } = useAuth();
+  const navigate = useNavigate();
+  const queryClient = useQueryClient();
+  const [courseId, setCourseId] = useState("");
+  const [message, setMessage] = useState("");
+
+  const { data, isLoading, error } = useQuery({
+    queryKey: ["group", groupId],
+    queryFn: () => fetchGroup(groupId!),
+    enabled: !!user && !!groupId,
+  });
+  const group = data?.group;
+  const isInstructor = group?.role === "instructor";
+  const dashboardCourse = courseId || group?.course_id || "";
+
+  const { data: leaderboard } = useQuery({
+    queryKey: ["leaderboard", "group", groupId, group?.course_id],
+    queryFn: () => fetchLeaderboard({ groupId, courseId: group?.course_id }),
+    enabled: !!group,
+  });
+  const { data: courses } = useQuery({
+    queryKey: ["courses"],
+    queryFn: fetchCourses,
+    enabled: isInstructor,
+  });
+  const { data: dashboard } = useQuery({
+    queryKey: ["group-dashboard", groupId, dashboardCourse],
+    queryFn: () => fetchGroupDashboard(groupId!, dashboardCourse),
+    enabled: isInstructor && !!dashboardCourse,
+  });
+
+  if (!user) return <div className="p-8 text-muted-foreground">Sign in to view this group.</div>;
+  if (isLoading) return <div className="p-8 text-muted-foreground">Loading...</div>;
+  if (error || !data || !group) return <div className="p-8 text-muted-foreground">Group not found.</div>;
+
+  const refresh = () => {
+    queryClient.invalidateQueries({ queryKey: ["group", groupId] });
+    queryClient.invalidateQueries({ queryKey: ["groups"] });
+  };
+
+  const handleRotate = async () => {
+    await rotateJoinCode(group.id).catch(() => setMessage("Failed to change the join code"));
+    refresh();
+  };
+
+  const handleRole = async (userId: string, role: GroupRole) => {
+    try {
+      await setGroupRole(group.id, userId, role);
+    } catch (err: any) {
+      setMessage(err.message?.includes("409") ? "A group needs at least one instructor" : "Failed to change role");
+    }
+    refresh();
+  };
+
+  const handleRemove = async (userId: string) => {
+    try {
+      await removeGroupMember(group.id, userId);
+    } catch (err: any) {
+      setMessage(err.message?.includes("409") ? "A group needs at least one instructor" : "Failed to remove member");
+      return;
+    }
+    if (userId === user.id) {
+      queryClient.invalidateQueries({ queryKey: ["groups"] });
+      navigate("/groups");
+      return;
+    }
+    refresh();
+  };
+
+  return (
+    <div className="p-8 max-w-5xl mx-auto overflow-auto h-full space-y-8">
+      <div>
+        <h1 className="text-3xl font-bold mb-1">{group.name}</h1>
+        <p className="text-sm text-muted-foreground">
+          {group.members} member{group.members === 1 ? "" : "s"}
+          {group.course_id && <> · {group.course_id}</>}
+        </p>
+        {isInstructor && group.join_code && (
+          <div className="mt-3 flex items-center gap-3">
+            <span className="text-sm text-muted-foreground">Join code</span>
+            <span className="font-mono text-lg tracking-widest">{group.join_code}</span>
+            <Button size="sm" variant="ghost" onClick={handleRotate}>
+              New code
+            </Button>
+          </div>
+        )}
+        {message && <p className="mt-2 text-sm text-muted-foreground">{message}</p>}
+      </div>
+
+      {isInstructor && (
+        <section>
+          <div className="flex items-center justify-between mb-3">
+            <h2 className="text-lg font-semibold">Progress</h2>
+            <select
+              className="h-9 rounded-md border bg-background px-2 text-sm"
+              value={dashboardCourse}
+              onChange={(e) => setCourseId(e.target.value)}
+            >
+              {!dashboardCourse && <option value="">Choose a course</option>}
+              {courses?.map((c) => (
+                <option key={c.id} value={c.id}>
+                  {c.title}
+                </option>
+              ))}
+            </select>
+          </div>
+          {!dashboard ? null : dashboard.students.length === 0 ? (
+            <p className="text-sm text-muted-foreground">No students yet. Share the join code to get started.</p>
+          ) : (
+            <div className="border rounded-lg overflow-auto">
+              <table className="text-sm">
+                <thead>
+                  <tr className="border-b bg-muted/50">
+                    <th className="text-left px-3 py-2 font-medium text-muted-foreground">Student</th>
+                    {dashboard.lessons.map((l, i) => (
+                      <th key={l.slug} className="px-2 py-2 font-medium text-muted-foreground" title={l.title}>
+                        {i + 1}
+                      </th>
+                    ))}
+                    <th className="text-right px-3 py-2 font-medium text-muted-foreground">Done</th>
+                  </tr>
+                </thead>
+                <tbody>
+                  {dashboard.students.map((s) => (
+                    <tr key={s.user_id} className="border-b last:border-0">
+                      <td className="px-3 py-2 whitespace-nowrap">{s.username}</td>
+                      {dashboard.lessons.map((l) => (
+                        <td key={l.slug} className="px-2 py-2 text-center">
+                          <LessonCell status={s.lessons[l.slug]} title={l.title} />
+                        </td>
+                      ))}
+                      <td className="px-3 py-2 text-right text-muted-foreground">
+                        {s.completed}/{dashboard.lessons.length}
+                      </td>
+                    </tr>
+                  ))}
+                </tbody>
+              </table>
+            </div>
+          )}
+        </section>
+      )}
+
+      <section>
+        <h2 className="text-lg font-semibold mb-3">Leaderboard</h2>
+        {!leaderboard || leaderboard.entries.length === 0 ? (
+          <p className="text-sm text-muted-foreground">No completions yet.</p>
+        ) : (
+          <div className="border rounded-lg divide-y">
+            {leaderboard.entries.map((e) => (
+              <div
+                key={e.user_id}
+                className={`flex items-center gap-4 px-4 py-2 text-sm ${e.user_id === user.id ? "bg-primary/5 font-medium" : ""}`}
+              >
+                <span className="w-8 text-muted-foreground">{e.rank}</span>
+                <span className="flex-1">{e.username}</span>
+                <span className="font-mono">{e.total_points}</span>
+              </div>
+            ))}
+          </div>
+        )}
+      </section>
+
+      <section>
+        <h2 className="text-lg font-semibold mb-3">Members</h2>
+        <div className="border rounded-lg divide-y">
+          {data.members.map((m) => (
+            <div key={m.user_id} className="flex items-center gap-3 px-4 py-2 text-sm">
+              <span className="flex-1">
+                {m.username}
+                {m.user_id === user.id && <span className="ml-2 text-xs text-muted-foreground">(you)</span>}
+              </span>
+              <Badge variant="outline">{m.role}</Badge>
+              {isInstructor && m.user_id !== user.id && (
+                <>
+                  <Button
+                    size="sm"
+                    variant="ghost"
+                    onClick={() => handleRole(m.user_id, m.role === "instructor" ? "student" : "instructor")}
+                  >
+                    {m.role === "instructor" ? "Make student" : "Make instructor"}
+                  </Button>
+                  <Button size="sm" variant="ghost" onClick={() => handleRemove(m.user_id)}>
+                    Remove
+                  </Button>
+                </>
+              )}
+              {m.user_id === user.id && (
+                <Button size="sm" variant="ghost" onClick={() => handleRemove(m.user_id)}>
+                  Leave
+                </Button>
+              )}
+            </div>
+          ))}
+        </div>
+      </section>
+    </div>
+  );
+}
+
+function LessonCell({ status, title }: { status?: LessonStatus; title: string }) {
+  if (!status) return <span className="text-muted-foreground/40">·</span>;
+  const details = [
+    title,
+    status.completed ? `passed${status.completed_at ? ` ${status.completed_at}` : ""}` : "not passed yet",
+    `${status.attempts} attempt${status.attempts === 1 ? "" : "s"}`,
+    status.viewed_solution ? "viewed solution" : "",
+  ].filter(Boolean);
+  return (
+    <span title={details.join("\n")} className="inline-flex flex-col items-center leading-tight">
+      <span className={status.completed ? "text-green-500" : "text-yellow-500"}>
+        {status.completed ? "✓" : "…"}
+        {status.viewed_solution && <sup className="text-muted-foreground">s</sup>}
+      </span>
+      <span className="text-[10px] text-muted-foreground">{status.attempts}</span>
+    </span>
+  );
+}
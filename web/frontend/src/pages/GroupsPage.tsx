@@ -0,0 +1,131 @@
+import { useState } from "react";
+import { Link, useNavigate } from "react-router-dom";
+import { useQuery } from "@tanstack/react-query";
+import { createGroup, fetchCourses, fetchGroups, joinGroup } from "@/lib/api";
+import { useAuth } from "@/contexts/AuthContext";
+import { Badge } from "@/components/ui/badge";
+import { Button } from "@/components/ui/button";
+
+export function GroupsPage() {
+  const { user } = useAuth();
+  const navigate = useNavigate();
+  const [code, setCode] = useState("");
+  const [name, setName] = useState("");
+  const [courseId, setCourseId] = useState("");
+  const [message, setMessage] = useState("");
+
+  const { data: groups, isLoading } = useQuery({
+    queryKey: ["groups"],
+    queryFn: fetchGroups,
+    enabled: !!user,
+  });
+  const { data: courses } = useQuery({
+    queryKey: ["courses"],
+    queryFn: fetchCourses,
+    enabled: !!user,
+  });
+
+  if (!user) {
+    return <div className="p-8 text-muted-foreground">Sign in to join a group.</div>;
+  }
+
+  const handleJoin = async (e: React.FormEvent) => {
+    e.preventDefault();
+    try {
+      const group = await joinGroup(code);
+      navigate(`/groups/${group.id}`);
+    } catch (err: any) {
+      setMessage(err.message?.includes("404") ? "No group has that join code" : "Failed to join group");
+    }
+  };
+
+  const handleCreate = async (e: React.FormEvent) => {
+    e.preventDefault();
+    try {
+      const group = await createGroup(name.trim(), courseId || undefined);
+      navigate(`/groups/${group.id}`);
+    } catch {
+      setMessage("Failed to create group");
+    }
+  };
+
+  const inputClass =
+    "px-3 py-2 border rounded-md bg-background text-foreground focus:outline-none focus:ring-2 focus:ring-primary";
+
+  return (
+    <div className="p-8 max-w-3xl mx-auto overflow-auto h-full">
+      <h1 className="text-3xl font-bold mb-6">Groups</h1>
+
+      {isLoading ? (
+        <div className="text-muted-foreground">Loading...</div>
+      ) : !groups || groups.length === 0 ? (
+        <p className="text-muted-foreground mb-8">You're not in any groups yet.</p>
+      ) : (
+        <div className="border rounded-lg divide-y mb-8">
+          {groups.map((g) => (
+            <Link
+              key={g.id}
+              to={`/groups/${g.id}`}
+              className="flex items-center justify-between px-4 py-3 hover:bg-muted/50"
+            >
+              <div>
+                <div className="font-medium">{g.name}</div>
+                <div className="text-xs text-muted-foreground">
+                  {g.members} member{g.members === 1 ? "" : "s"}
+                  {g.course_id && <> · {g.course_id}</>}
+                </div>
+              </div>
+              <Badge variant="outline">{g.role}</Badge>
+            </Link>
+          ))}
+        </div>
+      )}
+
+      {message && <p className="text-sm text-muted-foreground mb-4">{message}</p>}
+
+      <div className="grid gap-8 sm:grid-cols-2">
+        <form onSubmit={handleJoin} className="space-y-2">
+          <h2 className="text-lg font-semibold">Join a group</h2>
+          <p className="text-sm text-muted-foreground">Enter the code your instructor gave you.</p>
+          <input
+            value={code}
+            onChange={(e) => setCode(e.target.value.toUpperCase())}
+            placeholder="JOIN CODE"
+            maxLength={8}
+            className={`${inputClass} w-full font-mono tracking-widest`}
+          />
+          <Button type="submit" size="sm" disabled={!code.trim()}>
+            Join
+          </Button>
+        </form>
+
+        <form onSubmit={handleCreate} className="space-y-2">
+          <h2 className="text-lg font-semibold">Run a workshop</h2>
+          <p className="text-sm text-muted-foreground">Create a group and share its code with your students.</p>
+          <input
+            value={name}
+            onChange={(e) => setName(e.target.value)}
+            placeholder="group name"
+            maxLength={80}
+            className={`${inputClass} w-full`}
+          />
+          <select
+            value={courseId}
+            onChange={(e) => setCourseId(e.target.value)}
+            className={`${inputClass} w-full`}
+          >
+            <option value="">Any course</option>
+            {courses?.map((c) => (
+              <option key={c.id} value={c.id}>
+                {c.title}
+              </option>
+            ))}
+          </select>
+          <Button type="submit" size="sm" disabled={!name.trim()}>
+            Create group
+          </Button>
+        </form>
+      </div>
+    </div>
+  );
+}
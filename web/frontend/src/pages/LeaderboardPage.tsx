@@ -1,53 +1,142 @@
-import { useQuery } from "@tanstack/react-query";
-import { fetchLeaderboard } from "@/lib/api";
+import { useState } from "react";
+import { keepPreviousData, useQuery } from "@tanstack/react-query";
+import { fetchCourses, fetchLeaderboard, type LeaderboardEntry, type LeaderboardWindow } from "@/lib/api";
 import { useAuth } from "@/contexts/AuthContext";
+import { Button } from "@/components/ui/button";
+
+const PAGE_SIZE = 25;
+
+const WINDOWS: { value: LeaderboardWindow; label: string }[] = [
+  { value: "all", label: "All time" },
+  { value: "month", label: "This month" },
+  { value: "week", label: "This week" },
+];
 
 export function LeaderboardPage() {
   const { user } = useAuth();
-  const { data: entries, isLoading } = useQuery({
-    queryKey: ["leaderboard"],
-    queryFn: fetchLeaderboard,
+  const [courseId, setCourseId] = useState("");
+  const [since, setSince] = useState<LeaderboardWindow>("all");
+  const [page, setPage] = useState(0);
+
+  const { data: courses } = useQuery({
+    queryKey: ["courses"],
+    queryFn: fetchCourses,
+  });
+  const { data, isLoading } = useQuery({
+    queryKey: ["leaderboard", courseId, since, page],
+    queryFn: () => fetchLeaderboard({ courseId, since, limit: PAGE_SIZE, offset: page * PAGE_SIZE }),
+    placeholderData: keepPreviousData,
   });
 
-  if (isLoading) return <div className="p-8 text-muted-foreground">Loading...</div>;
+  const entries = data?.entries ?? [];
+  const total = data?.total ?? 0;
+  const pageCount = Math.max(1, Math.ceil(total / PAGE_SIZE));
+  const meOnPage = !data?.me || entries.some((e) => e.user_id === data.me?.user_id);
+
+  const renderRow = (entry: LeaderboardEntry) => {
+    const isCurrentUser = user?.id === entry.user_id;
+    return (
+      <tr
+        key={entry.user_id}
+        className={`border-b last:border-0 ${isCurrentUser ? "bg-primary/5 font-medium" : ""}`}
+      >
+        <td className="px-4 py-3 text-sm text-muted-foreground">{entry.rank}</td>
+        <td className="px-4 py-3 text-sm">
+          {entry.username}
+          {isCurrentUser && <span className="ml-2 text-xs text-muted-foreground">(you)</span>}
+        </td>
+        <td className="px-4 py-3 text-sm text-right font-mono">{entry.total_points}</td>
+        <td className="px-4 py-3 text-sm text-right text-muted-foreground">{entry.completed_count}</td>
+      </tr>
+    );
+  };
 
   return (
     <div className="p-8 max-w-3xl mx-auto">
       <h1 className="text-3xl font-bold mb-6">Leaderboard</h1>
-      {!entries || entries.length === 0 ? (
+      <div className="flex flex-wrap items-center justify-between gap-3 mb-4">
+        <select
+          className="h-9 rounded-md border bg-background px-2 text-sm"
+          value={courseId}
+          onChange={(e) => {
+            setCourseId(e.target.value);
+            setPage(0);
+          }}
+        >
+          <option value="">All courses</option>
+          {courses?.map((c) => (
+            <option key={c.id} value={c.id}>
+              {c.title}
+            </option>
+          ))}
+        </select>
+        <div className="flex gap-1">
+          {WINDOWS.map((w) => (
+            <Button
+              key={w.value}
+              size="sm"
+              variant={since === w.value ? "secondary" : "ghost"}
+              onClick={() => {
+                setSince(w.value);
+                setPage(0);
+              }}
+            >
+              {w.label}
+            </Button>
+          ))}
+        </div>
+      </div>
+
+      {isLoading ? (
+        <div className="text-muted-foreground">Loading...</div>
+      ) : entries.length === 0 ? (
         <p className="text-muted-foreground">No completions yet. Be the first!</p>
       ) : (
-        <div className="border rounded-lg overflow-hidden">
-          <table className="w-full">
-            <thead>
-              <tr className="border-b bg-muted/50">
-                <th className="text-left px-4 py-3 text-sm font-medium text-muted-foreground w-16">Rank</th>
-                <th className="text-left px-4 py-3 text-sm font-medium text-muted-foreground">User</th>
-                <th className="text-right px-4 py-3 text-sm font-medium text-muted-foreground">Points</th>
-                <th className="text-right px-4 py-3 text-sm font-medium text-muted-foreground">Completed</th>
-              </tr>
-            </thead>
-            <tbody>
-              {entries.map((entry, i) => {
-                const isCurrentUser = user?.id === entry.user_id;
-                return (
-                  <tr
-                    key={entry.user_id}
-                    className={`border-b last:border-0 ${isCurrentUser ? "bg-primary/5 font-medium" : ""}`}
-                  >
-                    <td className="px-4 py-3 text-sm text-muted-foreground">{i + 1}</td>
-                    <td className="px-4 py-3 text-sm">
-                      {entry.username}
-                      {isCurrentUser && <span className="ml-2 text-xs text-muted-foreground">(you)</span>}
-                    </td>
-                    <td className="px-4 py-3 text-sm text-right font-mono">{entry.total_points}</td>
-                    <td className="px-4 py-3 text-sm text-right text-muted-foreground">{entry.completed_count}</td>
-                  </tr>
-                );
-              })}
-            </tbody>
-          </table>
-        </div>
+        <>
+          <div className="border rounded-lg overflow-hidden">
+            <table className="w-full">
+              <thead>
+                <tr className="border-b bg-muted/50">
+                  <th className="text-left px-4 py-3 text-sm font-medium text-muted-foreground w-16">Rank</th>
+                  <th className="text-left px-4 py-3 text-sm font-medium text-muted-foreground">User</th>
+                  <th className="text-right px-4 py-3 text-sm font-medium text-muted-foreground">Points</th>
+                  <th className="text-right px-4 py-3 text-sm font-medium text-muted-foreground">Completed</th>
+                </tr>
+              </thead>
+              <tbody>
+                {entries.map(renderRow)}
+                {!meOnPage && data?.me && (
+                  <>
+                    <tr className="border-b">
+                      <td colSpan={4} className="px-4 py-1 text-center text-xs text-muted-foreground">
+                        &hellip;
+                      </td>
+                    </tr>
+                    {renderRow(data.me)}
+                  </>
+                )}
+              </tbody>
+            </table>
+          </div>
+          {pageCount > 1 && (
+            <div className="flex items-center justify-between mt-4 text-sm text-muted-foreground">
+              <Button size="sm" variant="outline" disabled={page === 0} onClick={() => setPage(page - 1)}>
+                Previous
+              </Button>
+              <span>
+                Page {page + 1} of {pageCount}
+              </span>
+              <Button
+                size="sm"
+                variant="outline"
+                disabled={page + 1 >= pageCount}
+                onClick={() => setPage(page + 1)}
+              >
+                Next
+              </Button>
+            </div>
+          )}
+        </>
       )}
     </div>
   );
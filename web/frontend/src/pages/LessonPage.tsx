@@ -1,7 +1,7 @@
 import { useQuery, useQueryClient } from "@tanstack/react-query";
 import { useParams, Link } from "react-router-dom";
-import { fetchCourse, fetchLesson } from "@/lib/api";
-import { useState, useEffect } from "react";
+import { fetchCourse, fetchLesson, fetchSolution, fetchDraft, fetchStatus, saveDraft, type DraftRevision } from "@/lib/api";
+import { useState, useEffect, useRef } from "react";
 import { ResizableHandle, ResizablePanel, ResizablePanelGroup } from "@/components/ui/resizable";
 import { LessonContent } from "@/components/LessonContent";
 import { CodeEditor } from "@/components/CodeEditor";
@@ -12,21 +12,31 @@ import { SuccessModal } from "@/components/SuccessModal";
 import { useTheme } from "@/components/ThemeProvider";
 import { Button } from "@/components/ui/button";
 import { useNavigate } from "react-router-dom";
+import { useAuth } from "@/contexts/AuthContext";
+
+// How long the editor waits after the last change before saving a draft
+const DRAFT_SAVE_DELAY_MS = 2000;
 
 export function LessonPage() {
   const { id, slug } = useParams<{ id: string; slug: string }>();
   const navigate = useNavigate();
   const { theme } = useTheme();
   const queryClient = useQueryClient();
-  const { output, isRunning, exitCode, pointsEarned, runTests, reset: resetTests } = useTestRunner();
+  const { user } = useAuth();
+  const { output, summary, isRunning, queuePosition, clusterState, exitCode, pointsEarned, runTests, cancelRun, reset: resetTests } =
+    useTestRunner();
   const [files, setFiles] = useState<Record<string, string>>({});
   const [activeFile, setActiveFile] = useState("");
   const [showSolution, setShowSolution] = useState(false);
   const [viewedSolution, setViewedSolution] = useState(false);
+  const [solutionCode, setSolutionCode] = useState<Record<string, string> | null>(null);
   const [showTerminal, setShowTerminal] = useState(false);
   const [newFileName, setNewFileName] = useState<string | null>(null);
   const [showSuccess, setShowSuccess] = useState(false);
   const [wasRunning, setWasRunning] = useState(false);
+  const [draftReady, setDraftReady] = useState(false);
+  const [draftRevisions, setDraftRevisions] = useState<DraftRevision[]>([]);
+  const lastSavedDraft = useRef("");
 
   const { data: course } = useQuery({
     queryKey: ["course", id],
@@ -40,12 +50,21 @@ export function LessonPage() {
     enabled: !!id && !!slug,
   });
 
+  // Whether the course's tests can run right now (e.g. its cluster is up)
+  const { data: status } = useQuery({
+    queryKey: ["status"],
+    queryFn: fetchStatus,
+    refetchInterval: 15000,
+  });
+  const courseStatus = status?.courses.find((c) => c.id === id);
+
   // Track when tests finish running
   useEffect(() => {
     if (isRunning) {
       setWasRunning(true);
     } else if (wasRunning) {
       setWasRunning(false);
+      queryClient.invalidateQueries({ queryKey: ["runs"] });
       if (exitCode === 0) {
         queryClient.invalidateQueries({ queryKey: ["course", id] });
         queryClient.invalidateQueries({ queryKey: ["courses"] });
@@ -56,55 +75,137 @@ export function LessonPage() {
     }
   }, [isRunning, wasRunning, exitCode, id, queryClient]);
 
-  // Set starter code when lesson loads — also clear test output
+  // Set starter code (or, in cumulative courses, the student's code from the
+  // previous lesson) when lesson loads — also clear test output
   useEffect(() => {
     if (lesson?.starter_code) {
-      setFiles({ ...lesson.starter_code });
-      const firstFile = Object.keys(lesson.starter_code)[0];
+      const code = lesson.previous_code ?? lesson.starter_code;
+      setFiles({ ...code });
+      const firstFile = Object.keys(code)[0];
       if (firstFile) setActiveFile(firstFile);
       setShowSolution(false);
       setViewedSolution(false);
+      setSolutionCode(null);
       setShowSuccess(false);
       resetTests();
     }
   }, [lesson, resetTests]);
 
+  // Restore the saved draft, if any, once the lesson has loaded
+  useEffect(() => {
+    if (!lesson || !user) return;
+    let cancelled = false;
+    setDraftReady(false);
+    setDraftRevisions([]);
+    lastSavedDraft.current = JSON.stringify(lesson.previous_code ?? lesson.starter_code);
+    fetchDraft(id!, slug!)
+      .then((draft) => {
+        if (cancelled) return;
+        setFiles({ ...draft.code });
+        const firstFile = Object.keys(draft.code)[0];
+        if (firstFile) setActiveFile(firstFile);
+        setDraftRevisions(draft.revisions);
+        lastSavedDraft.current = JSON.stringify(draft.code);
+      })
+      .catch(() => {})
+      .finally(() => {
+        if (!cancelled) setDraftReady(true);
+      });
+    return () => {
+      cancelled = true;
+    };
+  }, [lesson, user, id, slug]);
+
+  // Autosave the student's files (never the solution)
+  useEffect(() => {
+    if (!user || !draftReady || showSolution) return;
+    const json = JSON.stringify(files);
+    if (json === lastSavedDraft.current) return;
+    const timer = setTimeout(() => {
+      saveDraft(id!, slug!, files)
+        .then((saved) => {
+          lastSavedDraft.current = json;
+          setDraftRevisions((prev) =>
+            prev[0]?.revision === saved.revision ? prev : [saved, ...prev]
+          );
+        })
+        .catch(() => {});
+    }, DRAFT_SAVE_DELAY_MS);
+    return () => clearTimeout(timer);
+  }, [files, user, draftReady, showSolution, id, slug]);
+
   if (isLoading) return <div className="p-8 text-muted-foreground">Loading lesson...</div>;
   if (!lesson) return <div className="p-8 text-destructive">Lesson not found.</div>;
 
   const isKubernetes = course?.language === "kubernetes";
   const fileNames = Object.keys(files);
   const starterFileNames = new Set(Object.keys(lesson.starter_code));
-  const hasSolution = Object.keys(lesson.solution_code).length > 0;
+  const hasSolution = lesson.has_solution;
+  const startCode = lesson.previous_code ?? lesson.starter_code;
 
   const handleFileChange = (value: string) => {
     setFiles((prev) => ({ ...prev, [activeFile]: value }));
   };
 
+  // A file changed in the terminal's copy of the workspace
+  const handleTerminalFileChange = (path: string, content: string) => {
+    setFiles((prev) => ({ ...prev, [path]: content }));
+  };
+
   const handleRun = () => {
     setShowTerminal(false);
-    runTests(id!, slug!, files, viewedSolution);
+    runTests(id!, slug!, files);
   };
 
   const handleReset = () => {
+    setFiles({ ...startCode });
+    const firstFile = Object.keys(startCode)[0];
+    if (firstFile) setActiveFile(firstFile);
+    setShowSolution(false);
+  };
+
+  const handleUseStarter = () => {
     setFiles({ ...lesson.starter_code });
     const firstFile = Object.keys(lesson.starter_code)[0];
     if (firstFile) setActiveFile(firstFile);
     setShowSolution(false);
   };
 
-  const handleShowSolution = () => {
+  const handleShowSolution = async () => {
     const newShowSolution = !showSolution;
-    setShowSolution(newShowSolution);
     if (newShowSolution) {
-      setFiles({ ...lesson.solution_code });
-      const firstFile = Object.keys(lesson.solution_code)[0];
+      // Fetching the solution is what the server records as a view
+      let code = solutionCode;
+      if (!code) {
+        try {
+          code = (await fetchSolution(id!, slug!)).solution_code;
+        } catch {
+          return;
+        }
+        setSolutionCode(code);
+      }
+      setShowSolution(true);
+      setFiles({ ...code });
+      const firstFile = Object.keys(code)[0];
       if (firstFile) setActiveFile(firstFile);
       setViewedSolution(true);
     } else {
-      setFiles({ ...lesson.starter_code });
-      const firstFile = Object.keys(lesson.starter_code)[0];
+      setShowSolution(false);
+      setFiles({ ...startCode });
+      const firstFile = Object.keys(startCode)[0];
+      if (firstFile) setActiveFile(firstFile);
+    }
+  };
+
+  const handleRestoreDraft = async (revision: number) => {
+    try {
+      const draft = await fetchDraft(id!, slug!, revision);
+      setShowSolution(false);
+      setFiles({ ...draft.code });
+      const firstFile = Object.keys(draft.code)[0];
       if (firstFile) setActiveFile(firstFile);
+    } catch {
+      // leave the editor as it is
     }
   };
 
@@ -226,14 +327,43 @@ export function LessonPage() {
                     )}
                   </div>
                   <div className="flex gap-2 ml-2 flex-shrink-0">
-                    <Button size="sm" variant="outline" onClick={handleReset}>
+                    <Button
+                      size="sm"
+                      variant="outline"
+                      onClick={handleReset}
+                      title={lesson.previous_lesson ? `Reset to your code from ${lesson.previous_lesson}` : undefined}
+                    >
                       Reset
                     </Button>
+                    {draftRevisions.length > 0 && (
+                      <select
+                        className="h-8 rounded-md border bg-background px-2 text-sm"
+                        value=""
+                        onChange={(e) => handleRestoreDraft(Number(e.target.value))}
+                        title="Restore a saved version of your code"
+                      >
+                        <option value="" disabled>
+                          History
+                        </option>
+                        {draftRevisions.map((r) => (
+                          <option key={r.revision} value={r.revision}>
+                            #{r.revision} · {r.saved_at}
+                          </option>
+                        ))}
+                      </select>
+                    )}
+                    {lesson.previous_code && (
+                      <Button size="sm" variant="outline" onClick={handleUseStarter}>
+                        Starter
+                      </Button>
+                    )}
                     {hasSolution && (
                       <Button
                         size="sm"
                         variant={showSolution ? "secondary" : "outline"}
                         onClick={handleShowSolution}
+                        disabled={!user}
+                        title={user ? undefined : "Log in to view the solution"}
                       >
                         {showSolution ? "Hide Solution" : "Solution"}
                       </Button>
@@ -247,9 +377,15 @@ export function LessonPage() {
                         Terminal
                       </Button>
                     )}
-                    <Button size="sm" onClick={handleRun} disabled={isRunning}>
-                      {isRunning ? "Running..." : "Run Tests"}
-                    </Button>
+                    {isRunning ? (
+                      <Button size="sm" variant="outline" onClick={cancelRun}>
+                        {queuePosition ? "Leave Queue" : "Stop"}
+                      </Button>
+                    ) : (
+                      <Button size="sm" onClick={handleRun}>
+                        Run Tests
+                      </Button>
+                    )}
                   </div>
                 </div>
                 <div className="flex-1">
@@ -291,6 +427,11 @@ export function LessonPage() {
                   {exitCode !== null && !showTerminal && (
                     <span className={`ml-2 text-xs font-mono ${exitCode === 0 ? "text-green-500" : "text-red-500"}`}>
                       exit {exitCode}
+                      {summary && (
+                        <span className="ml-2 text-muted-foreground">
+                          {summary.passed}/{summary.total} tests passed
+                        </span>
+                      )}
                       {exitCode === 0 && pointsEarned !== null && pointsEarned > 0 && (
                         <span className="ml-2 text-green-500 font-semibold">+{pointsEarned} pts</span>
                       )}
@@ -299,14 +440,23 @@ export function LessonPage() {
                       )}
                     </span>
                   )}
+                  {courseStatus && !courseStatus.runnable && courseStatus.message && (
+                    <span className="ml-auto text-xs text-yellow-500">{courseStatus.message}</span>
+                  )}
                 </div>
                 <div className="flex-1 overflow-hidden relative">
                   <div className={`absolute inset-0 ${showTerminal ? "hidden" : ""}`}>
-                    <TestOutput lines={output} isRunning={isRunning} />
+                    <TestOutput lines={output} isRunning={isRunning} queuePosition={queuePosition} clusterState={clusterState} />
                   </div>
-                  {isKubernetes && id && (
+                  {isKubernetes && id && slug && (
                     <div className={`absolute inset-0 ${showTerminal ? "" : "hidden"}`}>
-                      <TerminalPanel courseId={id} visible={showTerminal} />
+                      <TerminalPanel
+                        courseId={id}
+                        lessonSlug={slug}
+                        files={files}
+                        onFileChange={handleTerminalFileChange}
+                        visible={showTerminal}
+                      />
                     </div>
                   )}
                 </div>
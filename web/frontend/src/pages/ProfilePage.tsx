@@ -2,6 +2,8 @@ import { useQuery } from "@tanstack/react-query";
 import { fetchProgress, fetchCourses } from "@/lib/api";
 import { useAuth } from "@/contexts/AuthContext";
 import { Badge } from "@/components/ui/badge";
+import { AccountSettings } from "@/components/AccountSettings";
+import { RunHistory } from "@/components/RunHistory";
 
 const difficultyColors: Record<string, string> = {
   beginner: "text-green-500 border-green-500",
@@ -97,6 +99,10 @@ export function ProfilePage() {
           </div>
         )}
       </div>
+
+      <RunHistory />
+
+      <AccountSettings />
     </div>
   );
 }
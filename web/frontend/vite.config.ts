@@ -14,7 +14,9 @@ export default defineConfig({
     proxy: {
       '/api': {
         target: 'http://localhost:8081',
-        changeOrigin: true,
+        // Keep the browser's Host: the runner only accepts sockets whose
+        // Origin matches it
+        changeOrigin: false,
         ws: true,
       },
     },
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// LessonAnalytics summarizes how students fare on one lesson. Metrics
+// without enough data are nil.
+type LessonAnalytics struct {
+	Slug           string   `json:"slug"`
+	Title          string   `json:"title"`
+	Started        int      `json:"started"`   // students with any activity
+	Completed      int      `json:"completed"` // students who passed
+	CompletionRate *float64 `json:"completion_rate"`
+	Runs           int      `json:"runs"`
+	RunPassRate    *float64 `json:"run_pass_rate"`
+	// Test runs up to and including the first passing one
+	AttemptsToPassMedian *float64 `json:"attempts_to_pass_median"`
+	AttemptsToPassMean   *float64 `json:"attempts_to_pass_mean"`
+	// From a student's first run or draft save to their first pass
+	MedianTimeToComplete *float64 `json:"median_time_to_complete_seconds"`
+	SolutionViewRate     *float64 `json:"solution_view_rate"` // of students who started
+	// Share of students who completed the previous lesson but not this one
+	DropOff *float64 `json:"drop_off"`
+}
+
+// CourseAnalytics is the analytics report for a course.
+type CourseAnalytics struct {
+	CourseID    string            `json:"course_id"`
+	Title       string            `json:"title"`
+	GeneratedAt string            `json:"generated_at"`
+	Lessons     []LessonAnalytics `json:"lessons"`
+}
+
+// AnalyzeCourse computes per-lesson analytics for a course, in lesson order.
+func AnalyzeCourse(course *Course, activity map[string]*LessonActivity, now time.Time) *CourseAnalytics {
+	report := &CourseAnalytics{
+		CourseID:    course.ID,
+		Title:       course.Title,
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Lessons:     make([]LessonAnalytics, len(course.Lessons)),
+	}
+
+	var prev *LessonActivity
+	for i, l := range course.Lessons {
+		a := activity[l.Slug]
+		if a == nil {
+			a = &LessonActivity{Completers: map[string]bool{}}
+		}
+		la := LessonAnalytics{
+			Slug:                 l.Slug,
+			Title:                l.Title,
+			Started:              a.Started,
+			Completed:            len(a.Completers),
+			CompletionRate:       ratio(len(a.Completers), a.Started),
+			Runs:                 a.Runs,
+			RunPassRate:          ratio(a.PassingRuns, a.Runs),
+			AttemptsToPassMedian: median(a.AttemptsToPass),
+			AttemptsToPassMean:   mean(a.AttemptsToPass),
+			SolutionViewRate:     ratio(a.SolutionViews, a.Started),
+		}
+		if secs := durationsInSeconds(a.TimeToComplete); len(secs) > 0 {
+			la.MedianTimeToComplete = median(secs)
+		}
+		if prev != nil {
+			stayed := 0
+			for userID := range prev.Completers {
+				if a.Completers[userID] {
+					stayed++
+				}
+			}
+			if r := ratio(stayed, len(prev.Completers)); r != nil {
+				la.DropOff = metric(1 - *r)
+			}
+		}
+		report.Lessons[i] = la
+		prev = a
+	}
+	return report
+}
+
+// WriteCSV writes the report as CSV, one row per lesson. Missing metrics are
+// empty cells.
+func (a *CourseAnalytics) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"lesson", "title", "started", "completed", "completion_rate", "runs", "run_pass_rate",
+		"attempts_to_pass_median", "attempts_to_pass_mean", "median_time_to_complete_seconds",
+		"solution_view_rate", "drop_off",
+	})
+	for _, l := range a.Lessons {
+		cw.Write([]string{
+			l.Slug, l.Title, strconv.Itoa(l.Started), strconv.Itoa(l.Completed), formatMetric(l.CompletionRate),
+			strconv.Itoa(l.Runs), formatMetric(l.RunPassRate),
+			formatMetric(l.AttemptsToPassMedian), formatMetric(l.AttemptsToPassMean), formatMetric(l.MedianTimeToComplete),
+			formatMetric(l.SolutionViewRate), formatMetric(l.DropOff),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatMetric(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// metric rounds v to three decimals, so reports don't show float noise like
+// 0.30000000000000004.
+func metric(v float64) *float64 {
+	v = math.Round(v*1000) / 1000
+	return &v
+}
+
+func ratio(n, d int) *float64 {
+	if d == 0 {
+		return nil
+	}
+	return metric(float64(n) / float64(d))
+}
+
+func median[T int | float64](values []T) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return metric(float64(sorted[mid]))
+	}
+	return metric(float64(sorted[mid-1]+sorted[mid]) / 2)
+}
+
+func mean(values []int) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	return metric(float64(sum) / float64(len(values)))
+}
+
+func durationsInSeconds(ds []time.Duration) []float64 {
+	secs := make([]float64, len(ds))
+	for i, d := range ds {
+		secs[i] = d.Seconds()
+	}
+	return secs
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthConfig controls login sessions.
+type AuthConfig struct {
+	SessionTTL time.Duration // how long a session lasts after login
+	// LegacyCookies lets a browser that still has the old bare vt_user_id
+	// cookie trade it for a session once. Only enable it while migrating:
+	// the old cookie is exactly what could be forged.
+	LegacyCookies bool
+	// OIDC enables single sign-on through an OpenID Connect provider.
+	OIDC *OIDCProvider
+	// MockIdP, if set, is served under /api/auth/mock-idp.
+	MockIdP *MockIdP
+}
+
+const defaultSessionTTL = 30 * 24 * time.Hour
+
+// Passwords are hashed with PBKDF2-HMAC-SHA256 and stored as
+// "pbkdf2-sha256$<iterations>$<salt>$<hash>" with base64 salt and hash.
+const (
+	passwordIterations = 600_000
+	passwordSaltLen    = 16
+	passwordKeyLen     = 32
+	passwordMinLen     = 8
+	passwordMaxLen     = 128
+)
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches a hash from hashPassword.
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyPasswordHash is checked against when a login names an unknown user,
+// so the response takes as long as for a wrong password. It is computed on
+// first use: hashing at init would slow down every start of the binary,
+// including each sandbox re-exec.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("not a real password")
+	return hash
+})
+
+// newSessionToken returns a random session token for the cookie and the
+// hash that is stored in its place.
+func newSessionToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashSessionToken(token), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
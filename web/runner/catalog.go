@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Catalog holds the loaded courses. Reload scans the courses root again and
+// swaps the valid courses in atomically. Callers that look up a course keep
+// using that *Course even if a reload happens meanwhile.
+type Catalog struct {
+	root string
+
+	mu          sync.RWMutex
+	courses     []*Course
+	index       map[string]*Course
+	fingerprint string
+	onReload    []func(courses []*Course)
+}
+
+// NewCatalog loads the courses under root and lints them. Courses with lint
+// errors, and course files that fail to parse, are skipped with a warning so
+// one bad course doesn't take the site down.
+func NewCatalog(root string) (*Catalog, error) {
+	c := &Catalog{root: root}
+	courses, _, err := loadCourses(root)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexCourses(courses)
+	if err != nil {
+		return nil, err
+	}
+	c.courses, c.index = courses, index
+	c.fingerprint, _ = fingerprintTree(root)
+	return c, nil
+}
+
+// loadCourses scans and lints the courses under root, logging every issue,
+// and returns the courses that passed. skipped lists the paths of the ones
+// that didn't.
+func loadCourses(root string) (courses []*Course, skipped []string, err error) {
+	scanned, parseErrs, err := ScanCourses(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := lintCourses(scanned, parseErrs)
+	for _, issue := range report.Issues {
+		log.Printf("%s: %s", issue.Severity, formatLintIssue(issue))
+	}
+	failed := report.failed()
+	for _, course := range scanned {
+		// Lint issues are keyed by course ID, so a course without one has
+		// to be caught here.
+		if course.ID == "" || failed[course.ID] {
+			log.Printf("warning: skipping course %s (%s)", course.ID, course.Path)
+			skipped = append(skipped, course.Path)
+			continue
+		}
+		courses = append(courses, course)
+	}
+	return courses, skipped, nil
+}
+
+// Courses returns the current course list, sorted by ID.
+func (c *Catalog) Courses() []*Course {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.courses
+}
+
+// Get looks up a course by ID.
+func (c *Catalog) Get(id string) (*Course, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	course, ok := c.index[id]
+	return course, ok
+}
+
+// Reload rescans the courses root and swaps in the courses that load and
+// lint cleanly, skipping the rest like NewCatalog does, so one broken course
+// doesn't hold back changes to the others. It returns the paths of the
+// skipped courses.
+func (c *Catalog) Reload() (skipped []string, err error) {
+	fingerprint, err := fingerprintTree(c.root)
+	if err != nil {
+		return nil, err
+	}
+	courses, skipped, err := loadCourses(c.root)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexCourses(courses)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.courses, c.index, c.fingerprint = courses, index, fingerprint
+	hooks := c.onReload
+	c.mu.Unlock()
+	log.Printf("reloaded %d course(s) from %s, skipped %d", len(courses), c.root, len(skipped))
+	for _, fn := range hooks {
+		fn(courses)
+	}
+	return skipped, nil
+}
+
+// OnReload registers fn to be called with the new courses after every
+// successful reload.
+func (c *Catalog) OnReload(fn func(courses []*Course)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReload = append(c.onReload, fn)
+}
+
+// Watch polls the courses root every interval and reloads when any file
+// changes. It returns when ctx is done.
+func (c *Catalog) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fingerprint, err := fingerprintTree(c.root)
+		if err != nil {
+			log.Printf("watching courses: %v", err)
+			continue
+		}
+		c.mu.RLock()
+		changed := fingerprint != c.fingerprint
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		// A failed reload leaves the old fingerprint, so the next tick
+		// tries again.
+		if _, err := c.Reload(); err != nil {
+			log.Printf("course reload failed, keeping previous courses: %v", err)
+		}
+	}
+}
+
+// indexCourses builds the ID index, rejecting empty and duplicate IDs.
+func indexCourses(courses []*Course) (map[string]*Course, error) {
+	index := make(map[string]*Course, len(courses))
+	for _, course := range courses {
+		if course.ID == "" {
+			return nil, fmt.Errorf("%s: course has no id", course.Path)
+		}
+		if prev, ok := index[course.ID]; ok {
+			return nil, fmt.Errorf("duplicate course id %q in %s and %s", course.ID, prev.Path, course.Path)
+		}
+		index[course.ID] = course
+	}
+	return index, nil
+}
+
+// fingerprintTree hashes the path, size and modification time of every file
+// under root, skipping node_modules.
+func fingerprintTree(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
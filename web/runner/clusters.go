@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClusterState is where a course's cluster is in its life cycle.
+type ClusterState string
+
+const (
+	ClusterProvisioning ClusterState = "provisioning" // being created, or recreated after a failure
+	ClusterReady        ClusterState = "ready"        // passing health checks; runs may use it
+	ClusterFailed       ClusterState = "failed"       // waiting to be recreated
+	ClusterDraining     ClusterState = "draining"     // course removed; deleted once its runs end
+)
+
+const (
+	clusterCheckInterval    = 30 * time.Second
+	clusterCheckTimeout     = 15 * time.Second
+	clusterCheckFailures    = 3 // failed checks in a row before a cluster is recreated
+	clusterProvisionTimeout = 10 * time.Minute
+	clusterRetryMin         = 10 * time.Second
+	clusterRetryMax         = 5 * time.Minute
+)
+
+// ClusterProvisioner creates, checks and deletes the cluster a kubernetes
+// course runs against.
+type ClusterProvisioner interface {
+	// Provision creates the course's cluster, or reuses one that already
+	// exists, and returns once it is ready.
+	Provision(ctx context.Context, course *Course) error
+	// Check returns an error if the cluster isn't healthy.
+	Check(ctx context.Context, course *Course) error
+	// Teardown deletes the cluster.
+	Teardown(ctx context.Context, course *Course) error
+}
+
+// ClusterStatus describes one course's cluster.
+type ClusterStatus struct {
+	CourseID string       `json:"course_id"`
+	State    ClusterState `json:"state"`
+	Error    string       `json:"error,omitempty"` // why the cluster last failed
+	Since    time.Time    `json:"since"`           // when it entered State
+	InUse    int          `json:"in_use"`          // runs and terminals using it
+}
+
+// ClusterManager keeps a cluster running for every kubernetes course. It
+// provisions each cluster once, checks its health every check interval and
+// recreates it, with backoff, after repeated failed checks. Runs wait for
+// their course's cluster to be ready through Acquire instead of setting it up
+// themselves.
+type ClusterManager struct {
+	prov          ClusterProvisioner
+	checkInterval time.Duration
+	retryMin      time.Duration // wait before recreating a failed cluster, doubling up to retryMax
+	retryMax      time.Duration
+
+	mu       sync.Mutex
+	clusters map[string]*managedCluster // by course ID
+}
+
+type managedCluster struct {
+	course  *Course
+	state   ClusterState
+	err     error
+	since   time.Time
+	inUse   int
+	changed chan struct{} // closed and replaced on every change
+	stop    context.CancelFunc
+	drained chan struct{} // closed once a drain has deleted the cluster or been called off
+}
+
+// NewClusterManager returns a manager that provisions clusters with prov and
+// checks them every checkInterval. Call Sync to tell it which courses exist.
+func NewClusterManager(prov ClusterProvisioner, checkInterval time.Duration) *ClusterManager {
+	return &ClusterManager{
+		prov:          prov,
+		checkInterval: checkInterval,
+		retryMin:      clusterRetryMin,
+		retryMax:      clusterRetryMax,
+		clusters:      make(map[string]*managedCluster),
+	}
+}
+
+// Sync starts managing the clusters of kubernetes courses that are new in
+// courses, and drains those of courses that are gone.
+func (m *ClusterManager) Sync(courses []*Course) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	want := make(map[string]*Course)
+	for _, c := range courses {
+		if c.Language == "kubernetes" {
+			want[c.ID] = c
+		}
+	}
+	for id, mc := range m.clusters {
+		if _, ok := want[id]; !ok && mc.state != ClusterDraining {
+			m.drain(mc)
+		}
+	}
+	for id, c := range want {
+		var drained chan struct{}
+		if mc, ok := m.clusters[id]; ok && mc.state != ClusterDraining {
+			mc.course = c
+			continue
+		} else if ok {
+			// The course came back while its cluster was draining. A drain
+			// still waiting for runs is called off; one already deleting
+			// the cluster finishes before the new cluster is provisioned.
+			mc.stop()
+			drained = mc.drained
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		mc := &managedCluster{course: c, state: ClusterProvisioning, since: time.Now(), changed: make(chan struct{}), stop: cancel}
+		m.clusters[id] = mc
+		go m.manage(ctx, mc, drained)
+	}
+}
+
+// Acquire waits until course's cluster is ready and returns the function
+// that ends the caller's use of it. While it waits, waiting (if not nil) is
+// called with the cluster's state. It fails right away if the cluster has
+// failed or is draining, and returns at once for courses without a cluster.
+func (m *ClusterManager) Acquire(ctx context.Context, course *Course, waiting func(ClusterState)) (release func(), err error) {
+	if m == nil || course.Language != "kubernetes" {
+		return func() {}, nil
+	}
+	var reported ClusterState
+	for {
+		m.mu.Lock()
+		mc := m.clusters[course.ID]
+		if mc == nil {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("no cluster for course %s", course.ID)
+		}
+		switch mc.state {
+		case ClusterReady:
+			mc.inUse++
+			m.mu.Unlock()
+			return m.releaser(mc), nil
+		case ClusterFailed:
+			err := fmt.Errorf("the course's kubernetes cluster is down and will be recreated shortly: %v", mc.err)
+			m.mu.Unlock()
+			return nil, err
+		case ClusterDraining:
+			m.mu.Unlock()
+			return nil, errors.New("the course's kubernetes cluster is shutting down")
+		}
+		state, changed := mc.state, mc.changed
+		m.mu.Unlock()
+
+		if waiting != nil && state != reported {
+			waiting(state)
+			reported = state
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Status returns the state of every managed cluster, sorted by course ID.
+func (m *ClusterManager) Status() []ClusterStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]ClusterStatus, 0, len(m.clusters))
+	for id, mc := range m.clusters {
+		s := ClusterStatus{CourseID: id, State: mc.state, Since: mc.since, InUse: mc.inUse}
+		if mc.err != nil {
+			s.Error = mc.err.Error()
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].CourseID < statuses[j].CourseID })
+	return statuses
+}
+
+func (m *ClusterManager) releaser(mc *managedCluster) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			mc.inUse--
+			m.notify(mc)
+		})
+	}
+}
+
+// notify wakes everyone waiting on a change to mc. Callers hold m.mu.
+func (m *ClusterManager) notify(mc *managedCluster) {
+	close(mc.changed)
+	mc.changed = make(chan struct{})
+}
+
+// setState moves mc to state unless it is draining, which only ends with
+// the cluster's removal.
+func (m *ClusterManager) setState(mc *managedCluster, state ClusterState, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mc.state == ClusterDraining {
+		return
+	}
+	mc.state, mc.err, mc.since = state, err, time.Now()
+	m.notify(mc)
+}
+
+// manage provisions mc's cluster and keeps it healthy until ctx ends. If
+// drained is not nil, it first waits for the drain of the course's previous
+// cluster to end.
+func (m *ClusterManager) manage(ctx context.Context, mc *managedCluster, drained <-chan struct{}) {
+	id := mc.course.ID
+	if drained != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-drained:
+		}
+	}
+	retry := m.retryMin
+	for {
+		log.Printf("provisioning cluster for %s...", id)
+		m.setState(mc, ClusterProvisioning, nil)
+		pctx, cancel := context.WithTimeout(ctx, clusterProvisionTimeout)
+		err := m.prov.Provision(pctx, mc.course)
+		cancel()
+		if err == nil {
+			log.Printf("cluster for %s is ready", id)
+			m.setState(mc, ClusterReady, nil)
+			retry = m.retryMin
+			err = m.watch(ctx, mc)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("warning: cluster for %s failed, recreating in %s: %v", id, retry, err)
+		m.setState(mc, ClusterFailed, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, m.retryMax)
+
+		tctx, cancel := context.WithTimeout(ctx, clusterProvisionTimeout)
+		if err := m.prov.Teardown(tctx, mc.course); err != nil {
+			log.Printf("deleting failed cluster for %s: %v", id, err)
+		}
+		cancel()
+	}
+}
+
+// watch checks mc's cluster every check interval and returns once it has
+// failed clusterCheckFailures checks in a row, or when ctx ends.
+func (m *ClusterManager) watch(ctx context.Context, mc *managedCluster) error {
+	ticker := time.NewTicker(m.checkInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		cctx, cancel := context.WithTimeout(ctx, clusterCheckTimeout)
+		err := m.prov.Check(cctx, mc.course)
+		cancel()
+		if err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		log.Printf("cluster for %s failed a health check (%d/%d): %v", mc.course.ID, failures, clusterCheckFailures, err)
+		if failures >= clusterCheckFailures {
+			return fmt.Errorf("health check: %w", err)
+		}
+	}
+}
+
+// drain stops managing mc, and deletes its cluster once the runs using it
+// have ended. Canceling mc.stop calls the drain off while it waits for runs,
+// but not once it is deleting the cluster. Callers hold m.mu.
+func (m *ClusterManager) drain(mc *managedCluster) {
+	mc.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	mc.stop = cancel
+	drained := make(chan struct{})
+	mc.drained = drained
+	mc.state, mc.err, mc.since = ClusterDraining, nil, time.Now()
+	m.notify(mc)
+
+	go func() {
+		defer close(drained)
+		id := mc.course.ID
+		for {
+			m.mu.Lock()
+			inUse, changed := mc.inUse, mc.changed
+			m.mu.Unlock()
+			if inUse == 0 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+		}
+
+		log.Printf("deleting cluster for removed course %s", id)
+		tctx, cancel := context.WithTimeout(context.Background(), clusterProvisionTimeout)
+		defer cancel()
+		if err := m.prov.Teardown(tctx, mc.course); err != nil {
+			log.Printf("deleting cluster for %s: %v", id, err)
+		}
+		m.mu.Lock()
+		if m.clusters[id] == mc {
+			delete(m.clusters, id)
+		}
+		m.mu.Unlock()
+	}()
+}
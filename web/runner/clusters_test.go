@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClusterManager returns a manager that checks clusters and retries
+// failed ones quickly. Its clusters are drained when the test ends.
+func newTestClusterManager(t *testing.T, prov *FakeProvisioner) *ClusterManager {
+	t.Helper()
+	m := NewClusterManager(prov, 5*time.Millisecond)
+	m.retryMin, m.retryMax = 50*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { m.Sync(nil) })
+	return m
+}
+
+func testClusterCourse() *Course {
+	return &Course{ID: "k8s", Language: "kubernetes"}
+}
+
+// clusterStatus returns the status of course's cluster, and whether it is
+// managed at all.
+func clusterStatus(m *ClusterManager, course *Course) (ClusterStatus, bool) {
+	for _, s := range m.Status() {
+		if s.CourseID == course.ID {
+			return s, true
+		}
+	}
+	return ClusterStatus{}, false
+}
+
+// waitForCluster waits for course's cluster to satisfy cond.
+func waitForCluster(t *testing.T, m *ClusterManager, course *Course, what string, cond func(s ClusterStatus, ok bool) bool) ClusterStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, ok := clusterStatus(m, course)
+		if cond(s, ok) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cluster never became %s; last status %+v", what, s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitForClusterState(t *testing.T, m *ClusterManager, course *Course, state ClusterState) ClusterStatus {
+	t.Helper()
+	return waitForCluster(t, m, course, string(state), func(s ClusterStatus, ok bool) bool {
+		return ok && s.State == state
+	})
+}
+
+func TestClusterManagerProvisions(t *testing.T) {
+	m := newTestClusterManager(t, &FakeProvisioner{Delay: 20 * time.Millisecond})
+	course := testClusterCourse()
+	m.Sync([]*Course{course})
+
+	var states []ClusterState
+	release, err := m.Acquire(context.Background(), course, func(s ClusterState) { states = append(states, s) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ClusterState{ClusterProvisioning}; !slices.Equal(states, want) {
+		t.Errorf("Acquire waited through %v, want %v", states, want)
+	}
+	if s, _ := clusterStatus(m, course); s.State != ClusterReady || s.InUse != 1 {
+		t.Errorf("status while in use = %+v, want ready and in use once", s)
+	}
+
+	release()
+	release() // a second call does nothing
+	if s, _ := clusterStatus(m, course); s.InUse != 0 {
+		t.Errorf("in use = %d after release, want 0", s.InUse)
+	}
+}
+
+func TestClusterManagerRecreatesFailedCluster(t *testing.T) {
+	prov := &FakeProvisioner{}
+	m := newTestClusterManager(t, prov)
+	course := testClusterCourse()
+	m.Sync([]*Course{course})
+	waitForClusterState(t, m, course, ClusterReady)
+
+	prov.Fail(course.ID, errors.New("API server down"))
+	s := waitForClusterState(t, m, course, ClusterFailed)
+	if !strings.Contains(s.Error, "API server down") {
+		t.Errorf("failed cluster's error = %q, want the check's", s.Error)
+	}
+	if _, err := m.Acquire(context.Background(), course, nil); err == nil {
+		t.Error("Acquire of a failed cluster succeeded")
+	}
+
+	prov.Fail(course.ID, nil)
+	waitForClusterState(t, m, course, ClusterReady)
+	if n := prov.Provisions(course.ID); n != 2 {
+		t.Errorf("provisioned %d times, want 2", n)
+	}
+	if n := prov.Teardowns(course.ID); n != 1 {
+		t.Errorf("deleted %d times, want 1", n)
+	}
+}
+
+func TestClusterManagerAcquireCanceled(t *testing.T) {
+	m := newTestClusterManager(t, &FakeProvisioner{Delay: time.Hour})
+	course := testClusterCourse()
+	m.Sync([]*Course{course})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := m.Acquire(ctx, course, func(s ClusterState) {
+		if s == ClusterProvisioning {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire = %v, want %v", err, context.Canceled)
+	}
+	if s, _ := clusterStatus(m, course); s.State != ClusterProvisioning || s.InUse != 0 {
+		t.Errorf("status = %+v, want provisioning and not in use", s)
+	}
+}
+
+func TestClusterManagerDrainsRemovedCourse(t *testing.T) {
+	prov := &FakeProvisioner{}
+	m := newTestClusterManager(t, prov)
+	course := testClusterCourse()
+	m.Sync([]*Course{course})
+	release, err := m.Acquire(context.Background(), course, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Sync(nil)
+	if s, _ := clusterStatus(m, course); s.State != ClusterDraining || s.InUse != 1 {
+		t.Errorf("status after removal = %+v, want draining and in use once", s)
+	}
+	if _, err := m.Acquire(context.Background(), course, nil); err == nil {
+		t.Error("Acquire of a draining cluster succeeded")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := prov.Teardowns(course.ID); n != 0 {
+		t.Fatalf("cluster deleted %d times while in use", n)
+	}
+
+	release()
+	waitForCluster(t, m, course, "removed", func(_ ClusterStatus, ok bool) bool { return !ok })
+	if n := prov.Teardowns(course.ID); n != 1 {
+		t.Errorf("deleted %d times, want 1", n)
+	}
+}
+
+func TestClusterManagerCourseReturnsWhileDraining(t *testing.T) {
+	prov := &FakeProvisioner{}
+	m := newTestClusterManager(t, prov)
+	course := testClusterCourse()
+	m.Sync([]*Course{course})
+	release, err := m.Acquire(context.Background(), course, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Sync(nil)
+	m.Sync([]*Course{course})
+	waitForClusterState(t, m, course, ClusterReady)
+	release()
+	time.Sleep(20 * time.Millisecond)
+	if s, ok := clusterStatus(m, course); !ok || s.State != ClusterReady {
+		t.Errorf("status = %+v, want the course's cluster ready", s)
+	}
+	if n := prov.Teardowns(course.ID); n != 0 {
+		t.Errorf("deleted %d times, want the drain called off", n)
+	}
+}
@@ -16,34 +16,50 @@ type CourseDeps struct {
 }
 
 type Course struct {
-	ID             string      `yaml:"id" json:"id"`
-	Title          string      `yaml:"title" json:"title"`
-	Description    string      `yaml:"description" json:"description"`
-	Language       string      `yaml:"language" json:"language"`
-	Difficulty     string      `yaml:"difficulty" json:"difficulty"`
-	EstimatedHours int         `yaml:"estimated_hours" json:"estimated_hours"`
-	Prerequisites  []string    `yaml:"prerequisites" json:"prerequisites"`
-	Tags           []string    `yaml:"tags" json:"tags"`
-	TestRunner     string      `yaml:"test_runner" json:"test_runner,omitempty"`
-	Dependencies   *CourseDeps `yaml:"dependencies" json:"dependencies,omitempty"`
-	LessonMode     string      `yaml:"lesson_mode" json:"lesson_mode,omitempty"`
-	Lessons        []Lesson    `yaml:"lessons" json:"lessons"`
-	Path           string      `yaml:"-" json:"-"` // filesystem path to course dir
+	ID             string        `yaml:"id" json:"id"`
+	Title          string        `yaml:"title" json:"title"`
+	Description    string        `yaml:"description" json:"description"`
+	Language       string        `yaml:"language" json:"language"`
+	Difficulty     string        `yaml:"difficulty" json:"difficulty"`
+	EstimatedHours int           `yaml:"estimated_hours" json:"estimated_hours"`
+	Prerequisites  []string      `yaml:"prerequisites" json:"prerequisites"`
+	Tags           []string      `yaml:"tags" json:"tags"`
+	TestRunner     *RunnerConfig `yaml:"test_runner" json:"test_runner,omitempty"`
+	Dependencies   *CourseDeps   `yaml:"dependencies" json:"dependencies,omitempty"`
+	LessonMode     string        `yaml:"lesson_mode" json:"lesson_mode,omitempty"`
+	Sandbox        SandboxConfig `yaml:"sandbox" json:"-"`
+	Lessons        []Lesson      `yaml:"lessons" json:"lessons"`
+	Path           string        `yaml:"-" json:"-"` // filesystem path to course dir
 }
 
 type Lesson struct {
-	Slug  string `yaml:"slug" json:"slug"`
-	Title string `yaml:"title" json:"title"`
+	Slug       string        `yaml:"slug" json:"slug"`
+	Title      string        `yaml:"title" json:"title"`
+	TestRunner *RunnerConfig `yaml:"test_runner" json:"-"` // per-lesson override
 }
 
+// LessonDetail is what students see when they open a lesson. The solution
+// is served separately (see LoadLessonSolution) so that viewing it can be
+// recorded.
 type LessonDetail struct {
-	Slug         string `json:"slug"`
-	Title        string `json:"title"`
-	Readme       string `json:"readme"`
-	StarterCode  map[string]string `json:"starter_code"`
-	SolutionCode map[string]string `json:"solution_code"`
+	Slug        string            `json:"slug"`
+	Title       string            `json:"title"`
+	Readme      string            `json:"readme"`
+	StarterCode map[string]string `json:"starter_code"`
+	HasSolution bool              `json:"has_solution"`
+	// In cumulative courses, the code the student passed an earlier lesson
+	// with, plus any starter files it doesn't have yet.
+	PreviousCode   map[string]string `json:"previous_code,omitempty"`
+	PreviousLesson string            `json:"previous_lesson,omitempty"`
 }
 
+// Lesson modes. In cumulative courses each lesson builds on the code from
+// the one before.
+const (
+	lessonModeCumulative = "cumulative"
+	lessonModeIsolated   = "isolated"
+)
+
 // LoadCourse reads a course.yaml file and returns the parsed Course.
 func LoadCourse(yamlPath string) (*Course, error) {
 	data, err := os.ReadFile(yamlPath)
@@ -54,21 +70,31 @@ func LoadCourse(yamlPath string) (*Course, error) {
 	if err := yaml.Unmarshal(data, &c); err != nil {
 		return nil, fmt.Errorf("parsing %s: %w", yamlPath, err)
 	}
-	c.Path = filepath.Dir(yamlPath)
+	// Runner commands execute from other working directories, so keep an
+	// absolute path to the course.
+	if c.Path, err = filepath.Abs(filepath.Dir(yamlPath)); err != nil {
+		return nil, err
+	}
 	return &c, nil
 }
 
 // ScanCourses walks the coursesRoot directory looking for course.yaml files.
-func ScanCourses(coursesRoot string) ([]*Course, error) {
+// Files that fail to load are skipped and returned as parse errors; the
+// final error is for failures walking the tree itself.
+func ScanCourses(coursesRoot string) ([]*Course, []error, error) {
 	var courses []*Course
+	var parseErrs []error
 	err := filepath.Walk(coursesRoot, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if info.IsDir() && info.Name() == "node_modules" {
+			return filepath.SkipDir
+		}
 		if info.Name() == "course.yaml" {
 			c, err := LoadCourse(path)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "warning: skipping %s: %v\n", path, err)
+				parseErrs = append(parseErrs, err)
 				return nil
 			}
 			courses = append(courses, c)
@@ -76,34 +102,46 @@ func ScanCourses(coursesRoot string) ([]*Course, error) {
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	sort.Slice(courses, func(i, j int) bool {
 		return courses[i].ID < courses[j].ID
 	})
-	return courses, nil
+	return courses, parseErrs, nil
 }
 
-// LoadLessonDetail reads readme, starter, and solution files for a lesson.
-func LoadLessonDetail(course *Course, slug string) (*LessonDetail, error) {
-	// Validate slug exists in course
-	var lesson *Lesson
-	for i := range course.Lessons {
-		if course.Lessons[i].Slug == slug {
-			lesson = &course.Lessons[i]
-			break
+// findLesson returns the lesson with the given slug, or nil.
+func (c *Course) findLesson(slug string) *Lesson {
+	for i := range c.Lessons {
+		if c.Lessons[i].Slug == slug {
+			return &c.Lessons[i]
 		}
 	}
+	return nil
+}
+
+// lessonDir validates slug against the course and returns the lesson's
+// directory.
+func (c *Course) lessonDir(slug string) (*Lesson, string, error) {
+	lesson := c.findLesson(slug)
 	if lesson == nil {
-		return nil, fmt.Errorf("lesson %q not found in course %q", slug, course.ID)
+		return nil, "", fmt.Errorf("lesson %q not found in course %q", slug, c.ID)
 	}
 
 	// Path traversal protection
 	if strings.Contains(slug, "..") || strings.Contains(slug, "/") {
-		return nil, fmt.Errorf("invalid lesson slug")
+		return nil, "", fmt.Errorf("invalid lesson slug")
 	}
 
-	lessonDir := filepath.Join(course.Path, "lessons", slug)
+	return lesson, filepath.Join(c.Path, "lessons", slug), nil
+}
+
+// LoadLessonDetail reads the readme and starter files for a lesson.
+func LoadLessonDetail(course *Course, slug string) (*LessonDetail, error) {
+	lesson, lessonDir, err := course.lessonDir(slug)
+	if err != nil {
+		return nil, err
+	}
 
 	detail := &LessonDetail{
 		Slug:  lesson.Slug,
@@ -119,12 +157,89 @@ func LoadLessonDetail(course *Course, slug string) (*LessonDetail, error) {
 	// Read starter code files
 	detail.StarterCode = readCodeDir(filepath.Join(lessonDir, "starter"))
 
-	// Read solution code files
-	detail.SolutionCode = readCodeDir(filepath.Join(lessonDir, "solution"))
+	detail.HasSolution = hasFiles(filepath.Join(lessonDir, "solution"))
 
 	return detail, nil
 }
 
+// previousLessons returns the slugs of the lessons before slug, nearest
+// first.
+func (c *Course) previousLessons(slug string) []string {
+	var prev []string
+	for _, l := range c.Lessons {
+		if l.Slug == slug {
+			return prev
+		}
+		prev = append([]string{l.Slug}, prev...)
+	}
+	return nil
+}
+
+// carryForward seeds a lesson's code from the student's previous code. The
+// student's files win; starter files they don't have yet are added.
+func carryForward(previous, starter map[string]string) map[string]string {
+	code := make(map[string]string, len(previous)+len(starter))
+	for name, content := range starter {
+		code[name] = content
+	}
+	for name, content := range previous {
+		code[name] = content
+	}
+	return code
+}
+
+// LoadLessonSolution reads the solution files for a lesson.
+func LoadLessonSolution(course *Course, slug string) (map[string]string, error) {
+	_, lessonDir, err := course.lessonDir(slug)
+	if err != nil {
+		return nil, err
+	}
+	return readCodeDir(filepath.Join(lessonDir, "solution")), nil
+}
+
+// LessonHasSolution reports whether a lesson ships a reference solution.
+func LessonHasSolution(course *Course, slug string) bool {
+	_, lessonDir, err := course.lessonDir(slug)
+	return err == nil && hasFiles(filepath.Join(lessonDir, "solution"))
+}
+
+// SolutionDirs returns the solution directory of every lesson under
+// coursesRoot. Runs and terminals must not read them: a solution is only
+// handed out through the solution endpoint, which records the view.
+func SolutionDirs(coursesRoot string) []string {
+	var dirs []string
+	filepath.WalkDir(coursesRoot, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		switch {
+		case d.Name() == "node_modules":
+			return filepath.SkipDir
+		case d.Name() == "solution" && filepath.Base(filepath.Dir(filepath.Dir(path))) == "lessons":
+			dirs = append(dirs, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return dirs
+}
+
+// hasFiles reports whether dir contains at least one regular file.
+func hasFiles(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
 // readCodeDir recursively reads all files in a directory and returns relative-path -> content map.
 func readCodeDir(dir string) map[string]string {
 	files := make(map[string]string)
@@ -2,23 +2,32 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
-	_ "modernc.org/sqlite"
 )
 
-type Store struct {
-	db *sql.DB
-}
-
 type User struct {
 	ID        string `json:"id"`
 	Username  string `json:"username"`
 	CreatedAt string `json:"created_at"`
 }
 
+// Session is a login session. The token itself is only ever in the user's
+// cookie; the store keeps its hash.
+type Session struct {
+	ID        string `json:"id"`
+	UserID    string `json:"-"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// timeFormat is how timestamps are stored. It sorts lexically, so SQL can
+// compare stored times as strings.
+const timeFormat = "2006-01-02 15:04:05"
+
 type Completion struct {
 	ID             int    `json:"id"`
 	UserID         string `json:"user_id"`
@@ -29,7 +38,18 @@ type Completion struct {
 	CompletedAt    string `json:"completed_at"`
 }
 
+// Draft is a saved revision of a student's files for a lesson.
+type Draft struct {
+	Revision int               `json:"revision"`
+	Code     map[string]string `json:"code,omitempty"`
+	SavedAt  string            `json:"saved_at"`
+}
+
+// draftHistory is how many revisions are kept per lesson.
+const draftHistory = 20
+
 type LeaderboardEntry struct {
+	Rank           int    `json:"rank"`
 	UserID         string `json:"user_id"`
 	Username       string `json:"username"`
 	TotalPoints    int    `json:"total_points"`
@@ -41,72 +61,186 @@ type CourseProgress struct {
 	Total     int `json:"total"`
 }
 
-func OpenStore(path string) (*Store, error) {
-	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(wal)&_pragma=busy_timeout(5000)")
+func (s *sqlStore) CreateUser(username string) (*User, error) {
+	id := uuid.New().String()
+	now := time.Now().UTC().Format(timeFormat)
+
+	_, err := s.db.Exec(
+		"INSERT INTO users (id, username, created_at) VALUES (?, ?, ?)",
+		id, username, now,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("opening database: %w", err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("pinging database: %w", err)
-	}
+	return &User{ID: id, Username: username, CreatedAt: now}, nil
+}
 
-	s := &Store{db: db}
-	if err := s.migrate(); err != nil {
-		return nil, fmt.Errorf("running migrations: %w", err)
+func (s *sqlStore) GetUser(id string) (*User, error) {
+	var u User
+	err := s.db.QueryRow(
+		"SELECT id, username, created_at FROM users WHERE id = ?", id,
+	).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err != nil {
+		return nil, err
 	}
-
-	return s, nil
+	return &u, nil
 }
 
-func (s *Store) migrate() error {
-	_, err := s.db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id TEXT PRIMARY KEY,
-			username TEXT NOT NULL UNIQUE,
-			created_at TEXT NOT NULL DEFAULT (datetime('now'))
-		);
-		CREATE TABLE IF NOT EXISTS completions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id TEXT NOT NULL REFERENCES users(id),
-			course_id TEXT NOT NULL,
-			lesson_slug TEXT NOT NULL,
-			points INTEGER NOT NULL,
-			viewed_solution INTEGER NOT NULL DEFAULT 0,
-			completed_at TEXT NOT NULL DEFAULT (datetime('now')),
-			UNIQUE(user_id, course_id, lesson_slug)
-		);
-	`)
-	return err
+func (s *sqlStore) GetUserByUsername(username string) (*User, error) {
+	var u User
+	err := s.db.QueryRow(
+		"SELECT id, username, created_at FROM users WHERE username = ?", username,
+	).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
 
-func (s *Store) CreateUser(username string) (*User, error) {
-	id := uuid.New().String()
-	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+// CreateSession starts a session for a user and returns its token. Expired
+// and revoked sessions are cleaned up on the way.
+func (s *sqlStore) CreateSession(userID, userAgent string, ttl time.Duration) (string, *Session, error) {
+	token, hash, err := newSessionToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now().UTC()
+	sess := &Session{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		UserAgent: userAgent,
+		CreatedAt: now.Format(timeFormat),
+		ExpiresAt: now.Add(ttl).Format(timeFormat),
+	}
 
-	_, err := s.db.Exec(
-		"INSERT INTO users (id, username, created_at) VALUES (?, ?, ?)",
-		id, username, now,
+	if _, err := s.db.Exec(
+		"DELETE FROM sessions WHERE expires_at <= ? OR revoked_at IS NOT NULL", sess.CreatedAt,
+	); err != nil {
+		return "", nil, err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO sessions (id, token_hash, user_id, user_agent, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, sess.ID, hash, userID, userAgent, sess.CreatedAt, sess.ExpiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, sess, nil
+}
+
+// GetSession looks up a live session by its token. It returns sql.ErrNoRows
+// if the session doesn't exist, has expired or was revoked.
+func (s *sqlStore) GetSession(token string) (*Session, *User, error) {
+	var sess Session
+	var u User
+	err := s.db.QueryRow(`
+		SELECT s.id, s.user_id, s.user_agent, s.created_at, s.expires_at, u.id, u.username, u.created_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.revoked_at IS NULL AND s.expires_at > ?
+	`, hashSessionToken(token), time.Now().UTC().Format(timeFormat)).Scan(
+		&sess.ID, &sess.UserID, &sess.UserAgent, &sess.CreatedAt, &sess.ExpiresAt,
+		&u.ID, &u.Username, &u.CreatedAt,
 	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &sess, &u, nil
+}
+
+// ListSessions returns a user's live sessions, newest first.
+func (s *sqlStore) ListSessions(userID string) ([]Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, user_agent, created_at, expires_at FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY created_at DESC
+	`, userID, time.Now().UTC().Format(timeFormat))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &User{ID: id, Username: username, CreatedAt: now}, nil
+	var sessions []Session
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.CreatedAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends one of a user's sessions. It reports whether a live
+// session was revoked.
+func (s *sqlStore) RevokeSession(userID, sessionID string) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`, time.Now().UTC().Format(timeFormat), sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
-func (s *Store) GetUser(id string) (*User, error) {
+// RevokeOtherSessions ends all of a user's sessions except keepID.
+func (s *sqlStore) RevokeOtherSessions(userID, keepID string) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = ?
+		WHERE user_id = ? AND id != ? AND revoked_at IS NULL
+	`, time.Now().UTC().Format(timeFormat), userID, keepID)
+	return err
+}
+
+// GetIdentityUser returns the user linked to an identity provider subject.
+// It returns sql.ErrNoRows if the identity isn't linked yet.
+func (s *sqlStore) GetIdentityUser(issuer, subject string) (*User, error) {
 	var u User
-	err := s.db.QueryRow(
-		"SELECT id, username, created_at FROM users WHERE id = ?", id,
-	).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	err := s.db.QueryRow(`
+		SELECT u.id, u.username, u.created_at
+		FROM identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = ? AND i.subject = ?
+	`, issuer, subject).Scan(&u.ID, &u.Username, &u.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &u, nil
 }
 
-func (s *Store) GetUserTotalPoints(userID string) (int, error) {
+// LinkIdentity links an identity provider subject to a user.
+func (s *sqlStore) LinkIdentity(issuer, subject, userID, email string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO identities (issuer, subject, user_id, email, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, issuer, subject, userID, email, time.Now().UTC().Format(timeFormat))
+	return err
+}
+
+// SetPassword sets or replaces a user's password hash.
+func (s *sqlStore) SetPassword(userID, passwordHash string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO credentials (user_id, password_hash, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET password_hash = excluded.password_hash, updated_at = excluded.updated_at
+	`, userID, passwordHash, time.Now().UTC().Format(timeFormat))
+	return err
+}
+
+// GetPasswordHash returns a user's password hash, or "" if they have none.
+func (s *sqlStore) GetPasswordHash(userID string) (string, error) {
+	var hash string
+	err := s.db.QueryRow(
+		"SELECT password_hash FROM credentials WHERE user_id = ?", userID,
+	).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+func (s *sqlStore) GetUserTotalPoints(userID string) (int, error) {
 	var total int
 	err := s.db.QueryRow(
 		"SELECT COALESCE(SUM(points), 0) FROM completions WHERE user_id = ?", userID,
@@ -114,29 +248,212 @@ func (s *Store) GetUserTotalPoints(userID string) (int, error) {
 	return total, err
 }
 
-func (s *Store) RecordCompletion(userID, courseID, lessonSlug string, points int, viewedSolution bool) error {
+// RecordCompletion records a passed lesson and saves the code that passed
+// it. Points are only awarded for the first pass; the snapshot is replaced
+// every time so it holds the student's latest passing code.
+func (s *sqlStore) RecordCompletion(userID, courseID, lessonSlug string, points int, viewedSolution bool, code map[string]string) error {
 	viewed := 0
 	if viewedSolution {
 		viewed = 1
 	}
-	_, err := s.db.Exec(`
-		INSERT INTO completions (user_id, course_id, lesson_slug, points, viewed_solution)
+	snapshot, err := json.Marshal(code)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(timeFormat)
+	if _, err := tx.Exec(`
+		INSERT INTO completions (user_id, course_id, lesson_slug, points, viewed_solution, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, course_id, lesson_slug) DO NOTHING
+	`, userID, courseID, lessonSlug, points, viewed, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO snapshots (user_id, course_id, lesson_slug, code, saved_at)
 		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, course_id, lesson_slug) DO UPDATE SET code = excluded.code, saved_at = excluded.saved_at
+	`, userID, courseID, lessonSlug, string(snapshot), now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetSnapshot returns the code a user last passed a lesson with, or nil if
+// there is none.
+func (s *sqlStore) GetSnapshot(userID, courseID, lessonSlug string) (map[string]string, error) {
+	var data string
+	err := s.db.QueryRow(`
+		SELECT code FROM snapshots
+		WHERE user_id = ? AND course_id = ? AND lesson_slug = ?
+	`, userID, courseID, lessonSlug).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var code map[string]string
+	if err := json.Unmarshal([]byte(data), &code); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// SaveDraft stores code as a new revision of the user's draft for a lesson
+// and prunes revisions beyond draftHistory. Saving code identical to the
+// latest revision doesn't create a new one. Saves of the same draft from
+// two tabs at once each get their own revision.
+func (s *sqlStore) SaveDraft(userID, courseID, lessonSlug string, code map[string]string) (*Draft, error) {
+	data, err := json.Marshal(code)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		draft, err := s.saveDraft(userID, courseID, lessonSlug, string(data))
+		// Two saves can still pick the same revision on PostgreSQL; the
+		// loser tries again with the next one.
+		if err != nil && isUniqueViolation(err) && attempt < 5 {
+			continue
+		}
+		return draft, err
+	}
+}
+
+func (s *sqlStore) saveDraft(userID, courseID, lessonSlug, data string) (*Draft, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The revision is allocated by the INSERT itself, which also makes it
+	// the transaction's first statement: SQLite then takes the write lock
+	// up front and a concurrent save waits for it instead of failing. No
+	// row is inserted if the code matches the latest revision.
+	draft := &Draft{SavedAt: time.Now().UTC().Format(timeFormat)}
+	err = tx.QueryRow(`
+		INSERT INTO drafts (user_id, course_id, lesson_slug, revision, code, saved_at)
+		SELECT ?, ?, ?, COALESCE(MAX(revision), 0) + 1, ?, ?
+		FROM drafts
+		WHERE user_id = ? AND course_id = ? AND lesson_slug = ?
+		HAVING COALESCE((
+			SELECT code FROM drafts
+			WHERE user_id = ? AND course_id = ? AND lesson_slug = ?
+			ORDER BY revision DESC LIMIT 1
+		), '') <> ?
+		RETURNING revision
+	`, userID, courseID, lessonSlug, data, draft.SavedAt,
+		userID, courseID, lessonSlug,
+		userID, courseID, lessonSlug, data).Scan(&draft.Revision)
+	if err == sql.ErrNoRows {
+		// Unchanged: the latest revision stands
+		var latest Draft
+		err := tx.QueryRow(`
+			SELECT revision, saved_at FROM drafts
+			WHERE user_id = ? AND course_id = ? AND lesson_slug = ?
+			ORDER BY revision DESC LIMIT 1
+		`, userID, courseID, lessonSlug).Scan(&latest.Revision, &latest.SavedAt)
+		if err != nil {
+			return nil, err
+		}
+		return &latest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		DELETE FROM drafts
+		WHERE user_id = ? AND course_id = ? AND lesson_slug = ? AND revision <= ?
+	`, userID, courseID, lessonSlug, draft.Revision-draftHistory); err != nil {
+		return nil, err
+	}
+	return draft, tx.Commit()
+}
+
+// GetDraft returns one revision of the user's draft for a lesson, or the
+// latest if revision is 0. It returns nil if there is no such draft.
+func (s *sqlStore) GetDraft(userID, courseID, lessonSlug string, revision int) (*Draft, error) {
+	var d Draft
+	var data string
+	err := s.db.QueryRow(`
+		SELECT revision, code, saved_at FROM drafts
+		WHERE user_id = ? AND course_id = ? AND lesson_slug = ? AND (? = 0 OR revision = ?)
+		ORDER BY revision DESC LIMIT 1
+	`, userID, courseID, lessonSlug, revision, revision).Scan(&d.Revision, &data, &d.SavedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), &d.Code); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ListDraftRevisions returns the saved revisions of a lesson draft, newest
+// first, without their code.
+func (s *sqlStore) ListDraftRevisions(userID, courseID, lessonSlug string) ([]Draft, error) {
+	rows, err := s.db.Query(`
+		SELECT revision, saved_at FROM drafts
+		WHERE user_id = ? AND course_id = ? AND lesson_slug = ?
+		ORDER BY revision DESC
+	`, userID, courseID, lessonSlug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drafts []Draft
+	for rows.Next() {
+		var d Draft
+		if err := rows.Scan(&d.Revision, &d.SavedAt); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+// RecordSolutionView notes that a user fetched a lesson's solution. Only the
+// first view is kept.
+func (s *sqlStore) RecordSolutionView(userID, courseID, lessonSlug string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO solution_views (user_id, course_id, lesson_slug, viewed_at)
+		VALUES (?, ?, ?, ?)
 		ON CONFLICT(user_id, course_id, lesson_slug) DO NOTHING
-	`, userID, courseID, lessonSlug, points, viewed)
+	`, userID, courseID, lessonSlug, time.Now().UTC().Format(timeFormat))
 	return err
 }
 
-func (s *Store) RecordCourseBonus(userID, courseID string, bonusPoints int) error {
+func (s *sqlStore) HasViewedSolution(userID, courseID, lessonSlug string) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM solution_views
+		WHERE user_id = ? AND course_id = ? AND lesson_slug = ?
+	`, userID, courseID, lessonSlug).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqlStore) RecordCourseBonus(userID, courseID string, bonusPoints int) error {
 	_, err := s.db.Exec(`
-		INSERT INTO completions (user_id, course_id, lesson_slug, points, viewed_solution)
-		VALUES (?, ?, '__course_bonus__', ?, 0)
+		INSERT INTO completions (user_id, course_id, lesson_slug, points, viewed_solution, completed_at)
+		VALUES (?, ?, '__course_bonus__', ?, 0, ?)
 		ON CONFLICT(user_id, course_id, lesson_slug) DO NOTHING
-	`, userID, courseID, bonusPoints)
+	`, userID, courseID, bonusPoints, time.Now().UTC().Format(timeFormat))
 	return err
 }
 
-func (s *Store) GetUserCompletions(userID string) ([]Completion, error) {
+func (s *sqlStore) GetUserCompletions(userID string) ([]Completion, error) {
 	rows, err := s.db.Query(`
 		SELECT id, user_id, course_id, lesson_slug, points, viewed_solution, completed_at
 		FROM completions
@@ -161,7 +478,7 @@ func (s *Store) GetUserCompletions(userID string) ([]Completion, error) {
 	return completions, rows.Err()
 }
 
-func (s *Store) GetCourseCompletions(userID, courseID string) ([]Completion, error) {
+func (s *sqlStore) GetCourseCompletions(userID, courseID string) ([]Completion, error) {
 	rows, err := s.db.Query(`
 		SELECT id, user_id, course_id, lesson_slug, points, viewed_solution, completed_at
 		FROM completions
@@ -186,34 +503,90 @@ func (s *Store) GetCourseCompletions(userID, courseID string) ([]Completion, err
 	return completions, rows.Err()
 }
 
-func (s *Store) GetLeaderboard(limit int) ([]LeaderboardEntry, error) {
-	rows, err := s.db.Query(`
-		SELECT u.id, u.username, COALESCE(SUM(c.points), 0) AS total_points,
+// LeaderboardQuery selects which completions count towards a leaderboard.
+type LeaderboardQuery struct {
+	CourseID string    // only this course; "" for all courses
+	GroupID  string    // only this group's students; "" for everyone
+	Since    time.Time // only completions from this time on; zero for all time
+	Limit    int
+	Offset   int
+}
+
+// leaderboardSQL ranks users by points for a LeaderboardQuery. Its
+// parameters are the course ID (twice), the since timestamp and the group ID
+// (twice); callers select from "ranked".
+const leaderboardSQL = `
+	WITH scores AS (
+		SELECT u.id, u.username, SUM(c.points) AS total_points,
 			COUNT(CASE WHEN c.lesson_slug != '__course_bonus__' THEN 1 END) AS completed_count
 		FROM users u
-		LEFT JOIN completions c ON u.id = c.user_id
-		GROUP BY u.id
-		HAVING total_points > 0
-		ORDER BY total_points DESC
-		LIMIT ?
-	`, limit)
+		JOIN completions c ON u.id = c.user_id
+		WHERE (? = '' OR c.course_id = ?) AND c.completed_at >= ?
+			AND (? = '' OR u.id IN (
+				SELECT user_id FROM group_members WHERE group_id = ? AND role = 'student'))
+		GROUP BY u.id, u.username
+		HAVING SUM(c.points) > 0
+	), ranked AS (
+		SELECT id, username, total_points, completed_count,
+			RANK() OVER (ORDER BY total_points DESC) AS user_rank
+		FROM scores
+	)`
+
+func (q LeaderboardQuery) args() []any {
+	since := ""
+	if !q.Since.IsZero() {
+		since = q.Since.UTC().Format(timeFormat)
+	}
+	return []any{q.CourseID, q.CourseID, since, q.GroupID, q.GroupID}
+}
+
+// GetLeaderboard returns one page of the leaderboard and the number of
+// ranked users.
+func (s *sqlStore) GetLeaderboard(q LeaderboardQuery) ([]LeaderboardEntry, int, error) {
+	var total int
+	if err := s.db.QueryRow(leaderboardSQL+` SELECT COUNT(*) FROM ranked`, q.args()...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(leaderboardSQL+`
+		SELECT id, username, total_points, completed_count, user_rank FROM ranked
+		ORDER BY user_rank, username
+		LIMIT ? OFFSET ?
+	`, append(q.args(), q.Limit, q.Offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	var entries []LeaderboardEntry
 	for rows.Next() {
 		var e LeaderboardEntry
-		if err := rows.Scan(&e.UserID, &e.Username, &e.TotalPoints, &e.CompletedCount); err != nil {
-			return nil, err
+		if err := rows.Scan(&e.UserID, &e.Username, &e.TotalPoints, &e.CompletedCount, &e.Rank); err != nil {
+			return nil, 0, err
 		}
 		entries = append(entries, e)
 	}
-	return entries, rows.Err()
+	return entries, total, rows.Err()
+}
+
+// GetLeaderboardEntry returns a user's place on the leaderboard, or nil if
+// they have no points in it.
+func (s *sqlStore) GetLeaderboardEntry(q LeaderboardQuery, userID string) (*LeaderboardEntry, error) {
+	var e LeaderboardEntry
+	err := s.db.QueryRow(leaderboardSQL+`
+		SELECT id, username, total_points, completed_count, user_rank FROM ranked
+		WHERE id = ?
+	`, append(q.args(), userID)...).Scan(&e.UserID, &e.Username, &e.TotalPoints, &e.CompletedCount, &e.Rank)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
 }
 
-func (s *Store) GetCompletedLessonsMap(userID, courseID string) (map[string]bool, error) {
+func (s *sqlStore) GetCompletedLessonsMap(userID, courseID string) (map[string]bool, error) {
 	rows, err := s.db.Query(`
 		SELECT lesson_slug FROM completions
 		WHERE user_id = ? AND course_id = ? AND lesson_slug != '__course_bonus__'
@@ -234,7 +607,7 @@ func (s *Store) GetCompletedLessonsMap(userID, courseID string) (map[string]bool
 	return m, rows.Err()
 }
 
-func (s *Store) GetCompletedLessonCounts(userID string) (map[string]int, error) {
+func (s *sqlStore) GetCompletedLessonCounts(userID string) (map[string]int, error) {
 	rows, err := s.db.Query(`
 		SELECT course_id, COUNT(*) FROM completions
 		WHERE user_id = ? AND lesson_slug != '__course_bonus__'
@@ -257,7 +630,7 @@ func (s *Store) GetCompletedLessonCounts(userID string) (map[string]int, error)
 	return m, rows.Err()
 }
 
-func (s *Store) HasCourseBonus(userID, courseID string) (bool, error) {
+func (s *sqlStore) HasCourseBonus(userID, courseID string) (bool, error) {
 	var count int
 	err := s.db.QueryRow(`
 		SELECT COUNT(*) FROM completions
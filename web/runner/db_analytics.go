@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LessonActivity is the raw per-lesson data that course analytics are
+// computed from.
+type LessonActivity struct {
+	Started        int             // users with any activity on the lesson
+	Completers     map[string]bool // users who passed the lesson
+	SolutionViews  int             // users who viewed the solution
+	Runs           int             // test runs by logged-in users
+	PassingRuns    int
+	AttemptsToPass []int           // per completer with recorded runs
+	TimeToComplete []time.Duration // per completer, from their first recorded activity
+}
+
+// GetLessonActivity collects activity on every lesson of a course, keyed by
+// lesson slug. Anonymous runs are left out since they can't be tied to a
+// student's progress.
+func (s *sqlStore) GetLessonActivity(courseID string) (map[string]*LessonActivity, error) {
+	activity := make(map[string]*LessonActivity)
+	lesson := func(slug string) *LessonActivity {
+		if activity[slug] == nil {
+			activity[slug] = &LessonActivity{Completers: map[string]bool{}}
+		}
+		return activity[slug]
+	}
+	query := func(q string, args []any, scan func(rows *sql.Rows) error) error {
+		rows, err := s.db.Query(q, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	err := query(`
+		SELECT lesson_slug, COUNT(DISTINCT user_id) FROM (
+			SELECT user_id, lesson_slug FROM runs WHERE course_id = ? AND user_id IS NOT NULL
+			UNION SELECT user_id, lesson_slug FROM drafts WHERE course_id = ?
+			UNION SELECT user_id, lesson_slug FROM solution_views WHERE course_id = ?
+			UNION SELECT user_id, lesson_slug FROM completions WHERE course_id = ?
+		) active
+		GROUP BY lesson_slug
+	`, []any{courseID, courseID, courseID, courseID}, func(rows *sql.Rows) error {
+		var slug string
+		var n int
+		if err := rows.Scan(&slug, &n); err != nil {
+			return err
+		}
+		lesson(slug).Started = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = query(`
+		SELECT lesson_slug, COUNT(*) FROM solution_views WHERE course_id = ? GROUP BY lesson_slug
+	`, []any{courseID}, func(rows *sql.Rows) error {
+		var slug string
+		var n int
+		if err := rows.Scan(&slug, &n); err != nil {
+			return err
+		}
+		lesson(slug).SolutionViews = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = query(`
+		SELECT lesson_slug, COUNT(*), COUNT(CASE WHEN exit_code = 0 THEN 1 END) FROM runs
+		WHERE course_id = ? AND user_id IS NOT NULL
+		GROUP BY lesson_slug
+	`, []any{courseID}, func(rows *sql.Rows) error {
+		var slug string
+		var runs, passing int
+		if err := rows.Scan(&slug, &runs, &passing); err != nil {
+			return err
+		}
+		l := lesson(slug)
+		l.Runs, l.PassingRuns = runs, passing
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Runs up to and including each student's first passing run
+	err = query(`
+		WITH first_pass AS (
+			SELECT user_id, lesson_slug, MIN(id) AS run_id FROM runs
+			WHERE course_id = ? AND user_id IS NOT NULL AND exit_code = 0
+			GROUP BY user_id, lesson_slug
+		)
+		SELECT r.lesson_slug, COUNT(*) FROM runs r
+		JOIN first_pass f ON f.user_id = r.user_id AND f.lesson_slug = r.lesson_slug AND r.id <= f.run_id
+		WHERE r.course_id = ?
+		GROUP BY r.user_id, r.lesson_slug
+	`, []any{courseID, courseID}, func(rows *sql.Rows) error {
+		var slug string
+		var n int
+		if err := rows.Scan(&slug, &n); err != nil {
+			return err
+		}
+		l := lesson(slug)
+		l.AttemptsToPass = append(l.AttemptsToPass, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Completion times, measured from the first run or draft save. Drafts
+	// are pruned, so this can undercount long stretches of editing.
+	err = query(`
+		SELECT c.lesson_slug, c.user_id, c.completed_at,
+			COALESCE((SELECT MIN(started_at) FROM runs r
+				WHERE r.user_id = c.user_id AND r.course_id = c.course_id AND r.lesson_slug = c.lesson_slug), ''),
+			COALESCE((SELECT MIN(saved_at) FROM drafts d
+				WHERE d.user_id = c.user_id AND d.course_id = c.course_id AND d.lesson_slug = c.lesson_slug), '')
+		FROM completions c
+		WHERE c.course_id = ? AND c.lesson_slug != '__course_bonus__'
+	`, []any{courseID}, func(rows *sql.Rows) error {
+		var slug, userID, completedAt, firstRun, firstDraft string
+		if err := rows.Scan(&slug, &userID, &completedAt, &firstRun, &firstDraft); err != nil {
+			return err
+		}
+		l := lesson(slug)
+		l.Completers[userID] = true
+
+		start := firstRun
+		if firstDraft != "" && (start == "" || firstDraft < start) {
+			start = firstDraft
+		}
+		if start == "" {
+			return nil
+		}
+		from, err1 := time.Parse(timeFormat, start)
+		to, err2 := time.Parse(timeFormat, completedAt)
+		if err1 == nil && err2 == nil && !to.Before(from) {
+			l.TimeToComplete = append(l.TimeToComplete, to.Sub(from))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
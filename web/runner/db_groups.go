@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Group roles. Instructors manage the group and see its dashboard; students
+// are ranked on its leaderboard.
+const (
+	groupInstructor = "instructor"
+	groupStudent    = "student"
+)
+
+// Group is a class or cohort that students join with a code.
+type Group struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CourseID  string `json:"course_id,omitempty"` // the course the group works on, if any
+	JoinCode  string `json:"join_code,omitempty"` // only shown to instructors
+	CreatedAt string `json:"created_at"`
+	Members   int    `json:"members"`
+	Role      string `json:"role,omitempty"` // the caller's role in the group
+}
+
+type GroupMember struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joined_at"`
+}
+
+// LessonStatus is one student's progress on one lesson.
+type LessonStatus struct {
+	Completed      bool   `json:"completed"`
+	CompletedAt    string `json:"completed_at,omitempty"`
+	Points         int    `json:"points"`
+	Attempts       int    `json:"attempts"`
+	ViewedSolution bool   `json:"viewed_solution"`
+}
+
+// joinCodeAlphabet leaves out characters that are easy to misread.
+const (
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	joinCodeLen      = 8
+)
+
+func newJoinCode() string {
+	b := make([]byte, joinCodeLen)
+	rand.Read(b)
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// CreateGroup creates a group with a fresh join code and makes the creator
+// its instructor.
+func (s *sqlStore) CreateGroup(name, courseID, instructorID string) (*Group, error) {
+	now := time.Now().UTC().Format(timeFormat)
+	g := &Group{ID: uuid.New().String(), Name: name, CourseID: courseID, CreatedAt: now, Members: 1, Role: groupInstructor}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Join codes are random; retry the rare collision. A failed statement
+	// would abort a PostgreSQL transaction, so collisions are skipped rather
+	// than raised.
+	inserted := false
+	for i := 0; i < 5 && !inserted; i++ {
+		g.JoinCode = newJoinCode()
+		res, err := tx.Exec(`
+			INSERT INTO groups (id, name, course_id, join_code, created_by, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(join_code) DO NOTHING
+		`, g.ID, name, courseID, g.JoinCode, instructorID, now)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		inserted = n > 0
+	}
+	if !inserted {
+		return nil, errors.New("no free join code")
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO group_members (group_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)
+	`, g.ID, instructorID, groupInstructor, now); err != nil {
+		return nil, err
+	}
+	return g, tx.Commit()
+}
+
+const groupColumns = `g.id, g.name, g.course_id, g.join_code, g.created_at,
+	(SELECT COUNT(*) FROM group_members m WHERE m.group_id = g.id)`
+
+func scanGroup(row interface{ Scan(...any) error }, extra ...any) (*Group, error) {
+	var g Group
+	dest := append([]any{&g.ID, &g.Name, &g.CourseID, &g.JoinCode, &g.CreatedAt, &g.Members}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (s *sqlStore) GetGroup(id string) (*Group, error) {
+	return scanGroup(s.db.QueryRow(`SELECT `+groupColumns+` FROM groups g WHERE g.id = ?`, id))
+}
+
+func (s *sqlStore) GetGroupByJoinCode(code string) (*Group, error) {
+	return scanGroup(s.db.QueryRow(`SELECT `+groupColumns+` FROM groups g WHERE g.join_code = ?`, code))
+}
+
+// ListUserGroups returns the groups a user belongs to, with their role in
+// each, newest first.
+func (s *sqlStore) ListUserGroups(userID string) ([]Group, error) {
+	rows, err := s.db.Query(`
+		SELECT `+groupColumns+`, gm.role FROM groups g
+		JOIN group_members gm ON gm.group_id = g.id
+		WHERE gm.user_id = ?
+		ORDER BY g.created_at DESC, g.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		var role string
+		g, err := scanGroup(rows, &role)
+		if err != nil {
+			return nil, err
+		}
+		g.Role = role
+		groups = append(groups, *g)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroupRole returns a user's role in a group, or "" if they aren't a
+// member.
+func (s *sqlStore) GetGroupRole(groupID, userID string) (string, error) {
+	var role string
+	err := s.db.QueryRow(`
+		SELECT role FROM group_members WHERE group_id = ? AND user_id = ?
+	`, groupID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// AddGroupMember adds a user to a group. A user who is already a member
+// keeps their role.
+func (s *sqlStore) AddGroupMember(groupID, userID, role string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO group_members (group_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(group_id, user_id) DO NOTHING
+	`, groupID, userID, role, time.Now().UTC().Format(timeFormat))
+	return err
+}
+
+// SetGroupRole changes a member's role. It reports whether the user is a
+// member.
+func (s *sqlStore) SetGroupRole(groupID, userID, role string) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE group_members SET role = ? WHERE group_id = ? AND user_id = ?
+	`, role, groupID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveGroupMember takes a user out of a group. It reports whether the user
+// was a member.
+func (s *sqlStore) RemoveGroupMember(groupID, userID string) (bool, error) {
+	res, err := s.db.Exec(`
+		DELETE FROM group_members WHERE group_id = ? AND user_id = ?
+	`, groupID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) ListGroupMembers(groupID string) ([]GroupMember, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, gm.role, gm.joined_at FROM group_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = ?
+		ORDER BY gm.role, u.username
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []GroupMember
+	for rows.Next() {
+		var m GroupMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// RotateJoinCode gives a group a new join code, so the old one stops
+// working.
+func (s *sqlStore) RotateJoinCode(groupID string) (string, error) {
+	var err error
+	for range 5 {
+		code := newJoinCode()
+		_, err = s.db.Exec(`UPDATE groups SET join_code = ? WHERE id = ?`, code, groupID)
+		if err == nil {
+			return code, nil
+		}
+		if !isUniqueViolation(err) {
+			break
+		}
+	}
+	return "", err
+}
+
+// GetGroupProgress returns, for each student in a group, their status on
+// each lesson of a course they have touched, keyed by user ID and lesson
+// slug.
+func (s *sqlStore) GetGroupProgress(groupID, courseID string) (map[string]map[string]*LessonStatus, error) {
+	progress := make(map[string]map[string]*LessonStatus)
+	status := func(userID, slug string) *LessonStatus {
+		if progress[userID] == nil {
+			progress[userID] = make(map[string]*LessonStatus)
+		}
+		if progress[userID][slug] == nil {
+			progress[userID][slug] = &LessonStatus{}
+		}
+		return progress[userID][slug]
+	}
+	query := func(q string, scan func(rows *sql.Rows) error) error {
+		rows, err := s.db.Query(q, groupID, courseID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+	const students = `user_id IN (SELECT user_id FROM group_members WHERE group_id = ? AND role = 'student') AND course_id = ?`
+
+	err := query(`
+		SELECT user_id, lesson_slug, points, completed_at FROM completions
+		WHERE `+students+` AND lesson_slug != '__course_bonus__'
+	`, func(rows *sql.Rows) error {
+		var userID, slug, at string
+		var points int
+		if err := rows.Scan(&userID, &slug, &points, &at); err != nil {
+			return err
+		}
+		st := status(userID, slug)
+		st.Completed, st.Points, st.CompletedAt = true, points, at
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = query(`SELECT user_id, lesson_slug, COUNT(*) FROM runs WHERE `+students+` GROUP BY user_id, lesson_slug`,
+		func(rows *sql.Rows) error {
+			var userID, slug string
+			var count int
+			if err := rows.Scan(&userID, &slug, &count); err != nil {
+				return err
+			}
+			status(userID, slug).Attempts = count
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = query(`SELECT user_id, lesson_slug FROM solution_views WHERE `+students,
+		func(rows *sql.Rows) error {
+			var userID, slug string
+			if err := rows.Scan(&userID, &slug); err != nil {
+				return err
+			}
+			status(userID, slug).ViewedSolution = true
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// Run is one execution of a lesson's tests, passing or not.
+type Run struct {
+	ID         int64  `json:"id"`
+	UserID     string `json:"-"` // "" for anonymous runs
+	CourseID   string `json:"course_id"`
+	LessonSlug string `json:"lesson_slug"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Passed     int    `json:"passed"`
+	Failed     int    `json:"failed"`
+	Skipped    int    `json:"skipped"`
+	Error      string `json:"error,omitempty"` // timeout or executor failure
+	StartedAt  string `json:"started_at"`
+	OutputSize int    `json:"output_size"`      // bytes, uncompressed
+	Output     string `json:"output,omitempty"` // only filled in by GetRun
+}
+
+// RunQuery selects a page of a user's runs.
+type RunQuery struct {
+	CourseID   string // "" for all courses
+	LessonSlug string // "" for all lessons
+	Limit      int
+	Offset     int
+}
+
+// RecordRun stores a run. Its output is stored gzip-compressed.
+func (s *sqlStore) RecordRun(r *Run) error {
+	output, err := gzipString(r.Output)
+	if err != nil {
+		return err
+	}
+	var userID any
+	if r.UserID != "" {
+		userID = r.UserID
+	}
+	return s.db.QueryRow(`
+		INSERT INTO runs (user_id, course_id, lesson_slug, exit_code, duration_ms,
+			passed, failed, skipped, error, output, output_size, started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, userID, r.CourseID, r.LessonSlug, r.ExitCode, r.DurationMS,
+		r.Passed, r.Failed, r.Skipped, r.Error, output, len(r.Output), r.StartedAt).Scan(&r.ID)
+}
+
+const runColumns = `id, course_id, lesson_slug, exit_code, duration_ms,
+	passed, failed, skipped, error, output_size, started_at`
+
+// ListRuns returns a page of a user's runs, newest first, without their
+// output, and how many runs match in total.
+func (s *sqlStore) ListRuns(userID string, q RunQuery) ([]Run, int, error) {
+	const where = `WHERE user_id = ? AND (? = '' OR course_id = ?) AND (? = '' OR lesson_slug = ?)`
+	args := []any{userID, q.CourseID, q.CourseID, q.LessonSlug, q.LessonSlug}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM runs `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+runColumns+` FROM runs `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		r := Run{UserID: userID}
+		if err := rows.Scan(&r.ID, &r.CourseID, &r.LessonSlug, &r.ExitCode, &r.DurationMS,
+			&r.Passed, &r.Failed, &r.Skipped, &r.Error, &r.OutputSize, &r.StartedAt); err != nil {
+			return nil, 0, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, total, rows.Err()
+}
+
+// GetRun returns one of a user's runs with its output.
+func (s *sqlStore) GetRun(userID string, id int64) (*Run, error) {
+	r := Run{UserID: userID}
+	var output []byte
+	err := s.db.QueryRow(`
+		SELECT `+runColumns+`, output FROM runs WHERE id = ? AND user_id = ?
+	`, id, userID).Scan(&r.ID, &r.CourseID, &r.LessonSlug, &r.ExitCode, &r.DurationMS,
+		&r.Passed, &r.Failed, &r.Skipped, &r.Error, &r.OutputSize, &r.StartedAt, &output)
+	if err != nil {
+		return nil, err
+	}
+	if r.Output, err = gunzipString(output); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func gzipString(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, s); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipString(b []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	return string(out), err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Executor runs a resolved test command. Implementations decide how much of
+// the host the command can see.
+type Executor interface {
+	// Run executes spec, streaming its output to stdout and stderr, and
+	// returns the exit code. A non-nil error means the command didn't run
+	// to completion (it failed to start, timed out, ...). Canceling ctx
+	// kills the command and everything it started.
+	Run(ctx context.Context, spec *RunSpec, stdout, stderr io.Writer) (int, error)
+	// Start starts spec's command with tty, the terminal end of a pty, as
+	// its stdio and controlling terminal, for an interactive shell. The
+	// command sees the host as a run would, but has no timeout and gets
+	// only the host variables in sandboxEnvPrefixes. stop hangs it up,
+	// waits for it to exit and cleans up after it.
+	Start(spec *RunSpec, tty *os.File) (stop func(), err error)
+}
+
+// sandboxEnvPrefixes lists the host environment variables passed into the
+// sandbox and to terminal shells. Everything else, including any
+// credentials the runner was started with, is dropped.
+var sandboxEnvPrefixes = []string{
+	"PATH=", "HOME=", "USER=", "LANG=", "LC_", "TERM=", "TZ=",
+	"GO", "NODE", "NPM_", "PYTHON", "CARGO_", "RUSTUP_", "K3D_", "KUBECONFIG=",
+}
+
+// sandboxEnv keeps the host variables listed in sandboxEnvPrefixes.
+func sandboxEnv(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		for _, prefix := range sandboxEnvPrefixes {
+			if strings.HasPrefix(kv, prefix) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return env
+}
+
+// SandboxConfig sets isolation and resource limits for a course's runs.
+// It is read from the `sandbox` block of course.yaml; zero values fall back
+// to the defaults below.
+type SandboxConfig struct {
+	Network   bool     `yaml:"network" json:"network,omitempty"`       // allow network access
+	CPUs      float64  `yaml:"cpus" json:"cpus,omitempty"`             // CPU cores
+	MemoryMB  int      `yaml:"memory_mb" json:"memory_mb,omitempty"`   // memory cap
+	PIDs      int      `yaml:"pids" json:"pids,omitempty"`             // max processes
+	ReadWrite []string `yaml:"read_write" json:"read_write,omitempty"` // extra host paths the run may write; "~" is $HOME. Paths the runner hides stay hidden.
+}
+
+const (
+	defaultSandboxCPUs     = 1
+	defaultSandboxMemoryMB = 512
+	defaultSandboxPIDs     = 256
+)
+
+func (sc SandboxConfig) withDefaults() SandboxConfig {
+	if sc.CPUs <= 0 {
+		sc.CPUs = defaultSandboxCPUs
+	}
+	if sc.MemoryMB <= 0 {
+		sc.MemoryMB = defaultSandboxMemoryMB
+	}
+	if sc.PIDs <= 0 {
+		sc.PIDs = defaultSandboxPIDs
+	}
+	return sc
+}
+
+// SandboxOptions configures the sandbox executor for the whole runner.
+type SandboxOptions struct {
+	CacheDir  string   // per-course writable caches, mounted at ~/.cache
+	CgroupDir string   // cgroup v2 directory for per-run cgroups; empty uses rlimits only
+	Hide      []string // host paths runs must not see (database, docker socket, admin kubeconfig); courses can't override this
+	Courses   string   // the courses root; every lesson's solution/ under it is hidden too
+}
+
+var (
+	errRunTimeout  = errors.New("run timed out")
+	errRunCanceled = errors.New("run canceled")
+)
+
+// runWaitDelay is how long a killed command's output pipes may stay open.
+// A descendant that escaped the kill (say, one that started its own
+// session) can hold them open forever; after this the run ends regardless.
+const runWaitDelay = 5 * time.Second
+
+// NewExecutor returns the executor with the given name: "sandbox" or "local".
+func NewExecutor(name string, opts SandboxOptions) (Executor, error) {
+	switch name {
+	case "local":
+		return LocalExecutor{}, nil
+	case "sandbox":
+		return NewSandboxExecutor(opts)
+	default:
+		return nil, fmt.Errorf("unknown executor %q", name)
+	}
+}
+
+// LocalExecutor runs commands directly as the runner process, with the
+// runner's environment, filesystem and network. Only use it for trusted
+// code or local development.
+type LocalExecutor struct{}
+
+func (LocalExecutor) Run(ctx context.Context, spec *RunSpec, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, "bash", "-c", spec.Command)
+	cmd.Dir = spec.Dir
+	cmd.Env = append(os.Environ(), spec.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = runWaitDelay
+	killProcessGroupOnCancel(cmd)
+	err := cmd.Run()
+	// Don't leave background processes the tests started behind.
+	killProcessGroup(cmd)
+	return exitStatus(ctx, err)
+}
+
+func (LocalExecutor) Start(spec *RunSpec, tty *os.File) (func(), error) {
+	cmd := exec.Command("bash", "-c", spec.Command)
+	cmd.Dir = spec.Dir
+	cmd.Env = append(sandboxEnv(os.Environ()), spec.Env...)
+	attachTerminal(cmd, tty)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return func() { hangUpShell(cmd) }, nil
+}
+
+// exitStatus converts the result of cmd.Run into an exit code.
+func exitStatus(ctx context.Context, err error) (int, error) {
+	if err == nil || errors.Is(err, exec.ErrWaitDelay) {
+		// The command exited; something it started held on to its output.
+		return 0, nil
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return -1, errRunTimeout
+	case context.Canceled:
+		return -1, errRunCanceled
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return -1, err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requireAdmin only lets requests through that carry the admin token as a
+// bearer token. With no token configured the admin API is disabled.
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "admin API disabled"})
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "admin token required"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func handleReloadCourses(catalog *Catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		skipped, err := catalog.Reload()
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		if skipped == nil {
+			skipped = []string{}
+		}
+		courses := catalog.Courses()
+		ids := make([]string, len(courses))
+		for i, c := range courses {
+			ids[i] = c.ID
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"courses": ids, "skipped": skipped})
+	}
+}
+
+// handleCourseAnalytics reports per-lesson metrics for a course, as JSON or,
+// with ?format=csv, as a CSV download.
+func handleCourseAnalytics(catalog *Catalog, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		course, ok := catalog.Get(r.PathValue("id"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "course not found"})
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `format must be "json" or "csv"`})
+			return
+		}
+
+		activity, err := store.GetLessonActivity(course.ID)
+		if err != nil {
+			log.Printf("course analytics: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get analytics"})
+			return
+		}
+		report := AnalyzeCourse(course, activity, time.Now())
+
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-analytics.csv"`, course.ID))
+			report.WriteCSV(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
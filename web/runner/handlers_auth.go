@@ -1,28 +1,81 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"regexp"
-	"strings"
 )
 
-const cookieName = "vt_user_id"
+const (
+	sessionCookie = "vt_session"
+	legacyCookie  = "vt_user_id" // bare user ID, from before sessions
+)
 
 var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]{2,24}$`)
 
-func getUserFromCookie(r *http.Request, store *Store) (*User, error) {
-	cookie, err := r.Cookie(cookieName)
+// getUserFromCookie returns the user of the request's session.
+func getUserFromCookie(r *http.Request, store Store) (*User, error) {
+	_, user, err := getSession(r, store)
+	return user, err
+}
+
+func getSession(r *http.Request, store Store) (*Session, *User, error) {
+	cookie, err := r.Cookie(sessionCookie)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return store.GetUser(cookie.Value)
+	return store.GetSession(cookie.Value)
+}
+
+// startSession creates a session for user and sets its cookie.
+func startSession(w http.ResponseWriter, r *http.Request, store Store, auth AuthConfig, userID string) error {
+	token, _, err := store.CreateSession(userID, r.UserAgent(), auth.SessionTTL)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(auth.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// requestHost is the host the browser sent the request to, which the proxy
+// in front of the runner passes on in X-Forwarded-Host.
+func requestHost(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		return fwd
+	}
+	return r.Host
+}
+
+// validPassword checks a new password. Passwords are optional, so callers
+// only check one that was given.
+func validPassword(password string) bool {
+	return len(password) >= passwordMinLen && len(password) <= passwordMaxLen
 }
 
-func handleCreateUser(store *Store) http.HandlerFunc {
+func handleCreateUser(store Store, auth AuthConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
 			Username string `json:"username"`
+			Password string `json:"password"` // optional
 		}
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
@@ -35,6 +88,12 @@ func handleCreateUser(store *Store) http.HandlerFunc {
 			})
 			return
 		}
+		if body.Password != "" && !validPassword(body.Password) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("password must be %d-%d characters", passwordMinLen, passwordMaxLen),
+			})
+			return
+		}
 
 		user, err := store.CreateUser(body.Username)
 		if err != nil {
@@ -46,39 +105,223 @@ func handleCreateUser(store *Store) http.HandlerFunc {
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     cookieName,
-			Value:    user.ID,
-			Path:     "/",
-			MaxAge:   365 * 24 * 60 * 60,
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-		})
+		if body.Password != "" {
+			hash, err := hashPassword(body.Password)
+			if err == nil {
+				err = store.SetPassword(user.ID, hash)
+			}
+			if err != nil {
+				log.Printf("setting password: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set password"})
+				return
+			}
+		}
+
+		if err := startSession(w, r, store, auth, user.ID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start session"})
+			return
+		}
 
 		writeJSON(w, http.StatusCreated, user)
 	}
 }
 
-func handleGetMe(store *Store) http.HandlerFunc {
+// handleLogin starts a session for a user with a password.
+func handleLogin(store Store, auth AuthConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+
+		hash := ""
+		user, err := store.GetUserByUsername(body.Username)
+		if err == nil {
+			hash, err = store.GetPasswordHash(user.ID)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to log in"})
+			return
+		}
+		if hash == "" {
+			// Unknown user or no password: spend the same time as a real check
+			checkPassword(dummyPasswordHash(), body.Password)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid username or password"})
+			return
+		}
+		if !checkPassword(hash, body.Password) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid username or password"})
+			return
+		}
+
+		if err := startSession(w, r, store, auth, user.ID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start session"})
+			return
+		}
+		writeJSON(w, http.StatusOK, user)
+	}
+}
+
+// handleLogout ends the current session.
+func handleLogout(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sess, _, err := getSession(r, store); err == nil {
+			if _, err := store.RevokeSession(sess.UserID, sess.ID); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to log out"})
+				return
+			}
+		}
+		clearCookie(w, sessionCookie)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListSessions lists the user's live sessions, marking the one making
+// the request.
+func handleListSessions(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, user, err := getSession(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+		sessions, err := store.ListSessions(user.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list sessions"})
+			return
+		}
+
+		type sessionItem struct {
+			Session
+			Current bool `json:"current"`
+		}
+		items := make([]sessionItem, len(sessions))
+		for i, sess := range sessions {
+			items[i] = sessionItem{Session: sess, Current: sess.ID == current.ID}
+		}
+		writeJSON(w, http.StatusOK, items)
+	}
+}
+
+// handleRevokeSession ends one of the user's sessions, e.g. on a lost
+// device.
+func handleRevokeSession(store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := getUserFromCookie(r, store)
 		if err != nil {
 			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
 			return
 		}
+		ok, err := store.RevokeSession(user.ID, r.PathValue("sessionID"))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+			return
+		}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleSetPassword sets or changes the user's password. Changing one needs
+// the current password, and signs out every other session.
+func handleSetPassword(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, user, err := getSession(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+
+		var body struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		if !validPassword(body.NewPassword) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("password must be %d-%d characters", passwordMinLen, passwordMaxLen),
+			})
+			return
+		}
+
+		current, err := store.GetPasswordHash(user.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set password"})
+			return
+		}
+		if current != "" && !checkPassword(current, body.CurrentPassword) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "current password is wrong"})
+			return
+		}
+
+		hash, err := hashPassword(body.NewPassword)
+		if err == nil {
+			err = store.SetPassword(user.ID, hash)
+		}
+		if err == nil {
+			err = store.RevokeOtherSessions(user.ID, sess.ID)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set password"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleGetMe(store Store, auth AuthConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil && auth.LegacyCookies {
+			user, err = upgradeLegacyCookie(w, r, store, auth)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
 
 		totalPoints, _ := store.GetUserTotalPoints(user.ID)
+		passwordHash, _ := store.GetPasswordHash(user.ID)
 
 		writeJSON(w, http.StatusOK, map[string]any{
 			"id":           user.ID,
 			"username":     user.Username,
 			"created_at":   user.CreatedAt,
 			"total_points": totalPoints,
+			"has_password": passwordHash != "",
 		})
 	}
 }
 
-func handleGetMyProgress(store *Store) http.HandlerFunc {
+// upgradeLegacyCookie trades an old vt_user_id cookie for a session and
+// removes it.
+func upgradeLegacyCookie(w http.ResponseWriter, r *http.Request, store Store, auth AuthConfig) (*User, error) {
+	cookie, err := r.Cookie(legacyCookie)
+	if err != nil {
+		return nil, err
+	}
+	user, err := store.GetUser(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err := startSession(w, r, store, auth, user.ID); err != nil {
+		return nil, err
+	}
+	clearCookie(w, legacyCookie)
+	return user, nil
+}
+
+func handleGetMyProgress(store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := getUserFromCookie(r, store)
 		if err != nil {
@@ -110,7 +353,3 @@ func handleGetMyProgress(store *Store) http.HandlerFunc {
 		})
 	}
 }
-
-func isUniqueViolation(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
-}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 )
 
@@ -17,8 +18,9 @@ type CourseListItem struct {
 	CompletedLessons int      `json:"completed_lessons,omitempty"`
 }
 
-func handleListCourses(courses []*Course, store *Store) http.HandlerFunc {
+func handleListCourses(catalog *Catalog, store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		courses := catalog.Courses()
 		var counts map[string]int
 		if user, err := getUserFromCookie(r, store); err == nil {
 			counts, _ = store.GetCompletedLessonCounts(user.ID)
@@ -49,10 +51,10 @@ type CourseDetail struct {
 	UserProgress map[string]bool `json:"user_progress,omitempty"`
 }
 
-func handleGetCourse(index map[string]*Course, store *Store) http.HandlerFunc {
+func handleGetCourse(catalog *Catalog, store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
-		course, ok := index[id]
+		course, ok := catalog.Get(id)
 		if !ok {
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": "course not found"})
 			return
@@ -67,12 +69,14 @@ func handleGetCourse(index map[string]*Course, store *Store) http.HandlerFunc {
 	}
 }
 
-func handleGetLesson(index map[string]*Course) http.HandlerFunc {
+// handleGetLesson returns a lesson. In cumulative courses, logged-in
+// students also get the code they passed the nearest earlier lesson with.
+func handleGetLesson(catalog *Catalog, store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		slug := r.PathValue("slug")
 
-		course, ok := index[id]
+		course, ok := catalog.Get(id)
 		if !ok {
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": "course not found"})
 			return
@@ -84,10 +88,70 @@ func handleGetLesson(index map[string]*Course) http.HandlerFunc {
 			return
 		}
 
+		if course.LessonMode == lessonModeCumulative {
+			if user, err := getUserFromCookie(r, store); err == nil {
+				for _, prev := range course.previousLessons(slug) {
+					code, err := store.GetSnapshot(user.ID, id, prev)
+					if err != nil {
+						log.Printf("loading snapshot: %v", err)
+						break
+					}
+					if code != nil {
+						detail.PreviousCode = carryForward(code, detail.StarterCode)
+						detail.PreviousLesson = prev
+						break
+					}
+				}
+			}
+		}
+
 		writeJSON(w, http.StatusOK, detail)
 	}
 }
 
+// handleGetSolution returns a lesson's solution to logged-in users, after
+// recording the view so the lesson no longer earns points. Without a
+// recorded view there is no solution: fetching it anonymously and then
+// running the tests logged in would dodge the penalty.
+func handleGetSolution(catalog *Catalog, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "log in to view the solution"})
+			return
+		}
+
+		id := r.PathValue("id")
+		slug := r.PathValue("slug")
+
+		course, ok := catalog.Get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "course not found"})
+			return
+		}
+
+		code, err := LoadLessonSolution(course, slug)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		if len(code) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "lesson has no solution"})
+			return
+		}
+
+		if err := store.RecordSolutionView(user.ID, id, slug); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record solution view"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"slug":          slug,
+			"solution_code": code,
+		})
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// maxDraftBytes caps the size of a saved draft.
+const maxDraftBytes = 1 << 20
+
+// handleGetDraft returns the user's latest draft for a lesson, or the
+// revision given by ?revision=N, along with the list of saved revisions.
+func handleGetDraft(catalog *Catalog, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+		id, slug := r.PathValue("id"), r.PathValue("slug")
+		if !lessonExists(catalog, id, slug) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "lesson not found"})
+			return
+		}
+
+		revision := 0
+		if v := r.URL.Query().Get("revision"); v != "" {
+			if revision, err = strconv.Atoi(v); err != nil || revision < 1 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid revision"})
+				return
+			}
+		}
+
+		draft, err := store.GetDraft(user.ID, id, slug, revision)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load draft"})
+			return
+		}
+		if draft == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no draft"})
+			return
+		}
+		revisions, err := store.ListDraftRevisions(user.ID, id, slug)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to load draft"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"revision":  draft.Revision,
+			"code":      draft.Code,
+			"saved_at":  draft.SavedAt,
+			"revisions": revisions,
+		})
+	}
+}
+
+// handleSaveDraft stores the user's files for a lesson as a new draft
+// revision.
+func handleSaveDraft(catalog *Catalog, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+		id, slug := r.PathValue("id"), r.PathValue("slug")
+		if !lessonExists(catalog, id, slug) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "lesson not found"})
+			return
+		}
+
+		var body struct {
+			Code map[string]string `json:"code"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxDraftBytes)
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Code == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+
+		draft, err := store.SaveDraft(user.ID, id, slug, body.Code)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save draft"})
+			return
+		}
+		writeJSON(w, http.StatusOK, draft)
+	}
+}
+
+func lessonExists(catalog *Catalog, courseID, slug string) bool {
+	course, ok := catalog.Get(courseID)
+	return ok && course.findLesson(slug) != nil
+}
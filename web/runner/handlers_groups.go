@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxGroupNameLen = 80
+
+// groupAccess loads the group in the request path and checks the caller is a
+// member, and an instructor if instructorOnly is set. Non-members get a 404
+// so group IDs don't leak. On failure it writes the response and returns
+// false.
+func groupAccess(w http.ResponseWriter, r *http.Request, store Store, instructorOnly bool) (*User, *Group, bool) {
+	user, err := getUserFromCookie(r, store)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+		return nil, nil, false
+	}
+	group, err := store.GetGroup(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "group not found"})
+		return nil, nil, false
+	}
+	role, err := store.GetGroupRole(group.ID, user.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get group"})
+		return nil, nil, false
+	}
+	if role == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "group not found"})
+		return nil, nil, false
+	}
+	if instructorOnly && role != groupInstructor {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "only instructors can do that"})
+		return nil, nil, false
+	}
+	group.Role = role
+	if role != groupInstructor {
+		group.JoinCode = ""
+	}
+	return user, group, true
+}
+
+// handleCreateGroup creates a group. Any logged-in user can create one and
+// becomes its instructor.
+func handleCreateGroup(catalog *Catalog, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+		var body struct {
+			Name     string `json:"name"`
+			CourseID string `json:"course_id"` // optional
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if body.Name == "" || utf8.RuneCountInString(body.Name) > maxGroupNameLen {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name must be 1-80 characters"})
+			return
+		}
+		if body.CourseID != "" {
+			if _, ok := catalog.Get(body.CourseID); !ok {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "course not found"})
+				return
+			}
+		}
+
+		group, err := store.CreateGroup(body.Name, body.CourseID, user.ID)
+		if err != nil {
+			log.Printf("creating group: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create group"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, group)
+	}
+}
+
+func handleListGroups(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+		groups, err := store.ListUserGroups(user.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list groups"})
+			return
+		}
+		if groups == nil {
+			groups = []Group{}
+		}
+		for i := range groups {
+			if groups[i].Role != groupInstructor {
+				groups[i].JoinCode = ""
+			}
+		}
+		writeJSON(w, http.StatusOK, groups)
+	}
+}
+
+// handleJoinGroup adds the caller to the group with the given join code as
+// a student. Joining a group you're already in is a no-op.
+func handleJoinGroup(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+
+		code := strings.ToUpper(strings.TrimSpace(body.Code))
+		group, err := store.GetGroupByJoinCode(code)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "invalid join code"})
+			return
+		}
+		if err := store.AddGroupMember(group.ID, user.ID, groupStudent); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to join group"})
+			return
+		}
+
+		if group, err = store.GetGroup(group.ID); err == nil {
+			group.Role, err = store.GetGroupRole(group.ID, user.ID)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to join group"})
+			return
+		}
+		if group.Role != groupInstructor {
+			group.JoinCode = ""
+		}
+		writeJSON(w, http.StatusOK, group)
+	}
+}
+
+// handleGetGroup returns a group and its members.
+func handleGetGroup(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, group, ok := groupAccess(w, r, store, false)
+		if !ok {
+			return
+		}
+		members, err := store.ListGroupMembers(group.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list members"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"group":   group,
+			"members": members,
+		})
+	}
+}
+
+// handleRotateJoinCode replaces a group's join code.
+func handleRotateJoinCode(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, group, ok := groupAccess(w, r, store, true)
+		if !ok {
+			return
+		}
+		code, err := store.RotateJoinCode(group.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to change join code"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"join_code": code})
+	}
+}
+
+// handleUpdateGroupMember changes a member's role.
+func handleUpdateGroupMember(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, group, ok := groupAccess(w, r, store, true)
+		if !ok {
+			return
+		}
+		var body struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		if body.Role != groupInstructor && body.Role != groupStudent {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `role must be "instructor" or "student"`})
+			return
+		}
+
+		memberID := r.PathValue("userID")
+		if body.Role == groupStudent && !keepsAnInstructor(w, store, group.ID, memberID) {
+			return
+		}
+		found, err := store.SetGroupRole(group.ID, memberID, body.Role)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update member"})
+			return
+		}
+		if !found {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "member not found"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRemoveGroupMember removes a member. Instructors can remove anyone;
+// students can only remove themselves, i.e. leave.
+func handleRemoveGroupMember(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, group, ok := groupAccess(w, r, store, false)
+		if !ok {
+			return
+		}
+		memberID := r.PathValue("userID")
+		if memberID != user.ID && group.Role != groupInstructor {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "only instructors can do that"})
+			return
+		}
+		if !keepsAnInstructor(w, store, group.ID, memberID) {
+			return
+		}
+		found, err := store.RemoveGroupMember(group.ID, memberID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to remove member"})
+			return
+		}
+		if !found {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "member not found"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// keepsAnInstructor checks that a group still has an instructor without
+// userID. If it wouldn't, it writes a 409 and returns false.
+func keepsAnInstructor(w http.ResponseWriter, store Store, groupID, userID string) bool {
+	members, err := store.ListGroupMembers(groupID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list members"})
+		return false
+	}
+	for _, m := range members {
+		if m.Role == groupInstructor && m.UserID != userID {
+			return true
+		}
+	}
+	for _, m := range members {
+		if m.UserID == userID && m.Role == groupInstructor {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "a group needs at least one instructor"})
+			return false
+		}
+	}
+	return true
+}
+
+// handleGroupLeaderboard ranks a group's students. It takes the same query
+// parameters as the global leaderboard.
+func handleGroupLeaderboard(catalog *Catalog, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, group, ok := groupAccess(w, r, store, false)
+		if !ok {
+			return
+		}
+		q, ok := leaderboardQuery(w, r, catalog)
+		if !ok {
+			return
+		}
+		q.GroupID = group.ID
+		writeLeaderboard(w, r, store, q)
+	}
+}
+
+// StudentProgress is one row of the instructor dashboard.
+type StudentProgress struct {
+	UserID    string                   `json:"user_id"`
+	Username  string                   `json:"username"`
+	Completed int                      `json:"completed"`
+	Lessons   map[string]*LessonStatus `json:"lessons"` // by slug; lessons not started are absent
+}
+
+// handleGroupDashboard shows instructors each student's status on each
+// lesson of a course: the group's course, or the one in ?course_id=.
+func handleGroupDashboard(catalog *Catalog, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, group, ok := groupAccess(w, r, store, true)
+		if !ok {
+			return
+		}
+		courseID := r.URL.Query().Get("course_id")
+		if courseID == "" {
+			courseID = group.CourseID
+		}
+		if courseID == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "course_id is required for groups without a course"})
+			return
+		}
+		course, ok := catalog.Get(courseID)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "course not found"})
+			return
+		}
+
+		members, err := store.ListGroupMembers(group.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list members"})
+			return
+		}
+		progress, err := store.GetGroupProgress(group.ID, course.ID)
+		if err != nil {
+			log.Printf("group dashboard: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get progress"})
+			return
+		}
+
+		students := []StudentProgress{}
+		for _, m := range members {
+			if m.Role != groupStudent {
+				continue
+			}
+			sp := StudentProgress{UserID: m.UserID, Username: m.Username, Lessons: map[string]*LessonStatus{}}
+			for _, l := range course.Lessons {
+				if st := progress[m.UserID][l.Slug]; st != nil {
+					sp.Lessons[l.Slug] = st
+					if st.Completed {
+						sp.Completed++
+					}
+				}
+			}
+			students = append(students, sp)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"group":    group,
+			"course":   map[string]string{"id": course.ID, "title": course.Title},
+			"lessons":  course.Lessons,
+			"students": students,
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout bounds how long /readyz waits for the database.
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck is the outcome of one readiness check. Required checks decide
+// whether the runner is ready; the others describe a single course's
+// infrastructure, which can be down without taking the rest of the site
+// with it.
+type HealthCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Required bool   `json:"required"`
+	Message  string `json:"message,omitempty"`
+}
+
+// CourseStatus tells students whether they can run a course's tests right
+// now, and why not.
+type CourseStatus struct {
+	ID       string         `json:"id"`
+	Title    string         `json:"title"`
+	Runnable bool           `json:"runnable"`
+	Message  string         `json:"message,omitempty"`
+	Cluster  *ClusterStatus `json:"cluster,omitempty"`   // kubernetes courses
+	NpmCache string         `json:"npm_cache,omitempty"` // JS/TS courses: "ready", "pending" or "failed"
+}
+
+// handleHealthz is the liveness probe: it answers as long as the server is
+// serving requests.
+func handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// handleReadyz is the readiness probe. It fails with 503 unless the
+// database answers and at least one course is loaded. The state of each
+// course's cluster or npm cache is reported alongside but doesn't fail it.
+func handleReadyz(catalog *Catalog, store Store, clusters *ClusterManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		db := HealthCheck{Name: "database", Required: true, OK: true}
+		if err := store.Ping(ctx); err != nil {
+			db.OK, db.Message = false, err.Error()
+		}
+		courses := catalog.Courses()
+		loaded := HealthCheck{Name: "courses", Required: true, OK: len(courses) > 0, Message: fmt.Sprintf("%d loaded", len(courses))}
+		checks := []HealthCheck{db, loaded}
+
+		for _, s := range courseStatuses(courses, clusters) {
+			switch {
+			case s.Cluster != nil:
+				c := HealthCheck{Name: "cluster:" + s.ID, OK: s.Runnable, Message: string(s.Cluster.State)}
+				if s.Cluster.Error != "" {
+					c.Message += ": " + s.Cluster.Error
+				}
+				checks = append(checks, c)
+			case s.NpmCache != "":
+				checks = append(checks, HealthCheck{Name: "npm-cache:" + s.ID, OK: s.Runnable, Message: s.NpmCache})
+			}
+		}
+
+		ready := true
+		for _, c := range checks {
+			if c.Required && !c.OK {
+				ready = false
+			}
+		}
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, map[string]any{"ready": ready, "checks": checks})
+	}
+}
+
+// handleStatus reports, for every course, whether its tests can run right
+// now, along with how busy the run queue is.
+func handleStatus(catalog *Catalog, clusters *ClusterManager, scheduler *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		running, queued := scheduler.Stats()
+		statuses := courseStatuses(catalog.Courses(), clusters)
+		for _, s := range statuses {
+			if s.Cluster != nil {
+				s.Cluster.Error = "" // why it failed is for /readyz
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"courses": statuses,
+			"runs":    map[string]int{"running": running, "queued": queued},
+		})
+	}
+}
+
+// courseStatuses checks the infrastructure behind each course, including
+// why clusters failed. /api/status drops those details: students get a
+// plain explanation, and the details stay in /readyz.
+func courseStatuses(courses []*Course, clusters *ClusterManager) []CourseStatus {
+	byCourse := make(map[string]ClusterStatus)
+	if clusters != nil {
+		for _, s := range clusters.Status() {
+			byCourse[s.CourseID] = s
+		}
+	}
+
+	statuses := make([]CourseStatus, len(courses))
+	for i, c := range courses {
+		s := CourseStatus{ID: c.ID, Title: c.Title, Runnable: true}
+		switch c.Language {
+		case "kubernetes":
+			if clusters == nil {
+				break
+			}
+			cs, ok := byCourse[c.ID]
+			if !ok {
+				cs = ClusterStatus{CourseID: c.ID, State: ClusterProvisioning}
+			}
+			s.Cluster = &cs
+			s.Runnable = cs.State == ClusterReady
+			switch cs.State {
+			case ClusterProvisioning:
+				s.Message = "The course's Kubernetes cluster is starting. Runs wait until it is ready."
+			case ClusterFailed:
+				s.Message = "The course's Kubernetes cluster is down and is being recreated."
+			case ClusterDraining:
+				s.Message = "The course's Kubernetes cluster is shutting down."
+			}
+		case "javascript", "typescript":
+			state, _ := NpmCacheStatus(c)
+			s.NpmCache = state
+			if state == npmCacheFailed {
+				s.Runnable = false
+				s.Message = "Installing the course's npm packages failed. The next run tries again."
+			}
+		}
+		statuses[i] = s
+	}
+	return statuses
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultRunsLimit = 20
+	maxRunsLimit     = 100
+)
+
+// handleListMyRuns returns the caller's test runs, newest first. Query
+// parameters: course_id, lesson, limit (default 20) and offset. Output is
+// left out; fetch a single run to get it.
+func handleListMyRuns(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+
+		params := r.URL.Query()
+		q := RunQuery{
+			CourseID:   params.Get("course_id"),
+			LessonSlug: params.Get("lesson"),
+			Limit:      defaultRunsLimit,
+		}
+		if v := params.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxRunsLimit {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxRunsLimit)})
+				return
+			}
+			q.Limit = n
+		}
+		if v := params.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "offset must be a non-negative number"})
+				return
+			}
+			q.Offset = n
+		}
+
+		runs, total, err := store.ListRuns(user.ID, q)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list runs"})
+			return
+		}
+		if runs == nil {
+			runs = []Run{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"runs":   runs,
+			"total":  total,
+			"limit":  q.Limit,
+			"offset": q.Offset,
+		})
+	}
+}
+
+// handleGetMyRun returns one of the caller's runs with its output.
+func handleGetMyRun(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := getUserFromCookie(r, store)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "not logged in"})
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("runID"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "run not found"})
+			return
+		}
+
+		run, err := store.GetRun(user.ID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "run not found"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get run"})
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	}
+}
@@ -1,17 +1,129 @@
 package main
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
 
-func handleGetLeaderboard(store *Store) http.HandlerFunc {
+const (
+	defaultLeaderboardLimit = 50
+	maxLeaderboardLimit     = 200
+)
+
+// handleGetLeaderboard ranks users by points. Query parameters:
+//
+//	course_id  only count this course
+//	since      "week" or "month" (the current calendar week or month, UTC),
+//	           a date (2006-01-02), or "all" (default)
+//	limit      page size, default 50
+//	offset     page start
+//
+// The response includes the caller's own entry ("me") even when it falls
+// outside the page.
+func handleGetLeaderboard(catalog *Catalog, store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		entries, err := store.GetLeaderboard(50)
-		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get leaderboard"})
+		q, ok := leaderboardQuery(w, r, catalog)
+		if !ok {
 			return
 		}
-		if entries == nil {
-			entries = []LeaderboardEntry{}
+		writeLeaderboard(w, r, store, q)
+	}
+}
+
+// leaderboardQuery parses the leaderboard query parameters. On bad input it
+// writes the error response and returns false.
+func leaderboardQuery(w http.ResponseWriter, r *http.Request, catalog *Catalog) (LeaderboardQuery, bool) {
+	params := r.URL.Query()
+	q := LeaderboardQuery{
+		CourseID: params.Get("course_id"),
+		Limit:    defaultLeaderboardLimit,
+	}
+
+	if q.CourseID != "" {
+		if _, ok := catalog.Get(q.CourseID); !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "course not found"})
+			return q, false
+		}
+	}
+
+	since, err := leaderboardSince(params.Get("since"), time.Now().UTC())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return q, false
+	}
+	q.Since = since
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLeaderboardLimit {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxLeaderboardLimit)})
+			return q, false
 		}
-		writeJSON(w, http.StatusOK, entries)
+		q.Limit = n
 	}
+	if v := params.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "offset must be a non-negative number"})
+			return q, false
+		}
+		q.Offset = n
+	}
+	return q, true
 }
+
+// writeLeaderboard responds with one page of the leaderboard for q and the
+// caller's own entry.
+func writeLeaderboard(w http.ResponseWriter, r *http.Request, store Store, q LeaderboardQuery) {
+	entries, total, err := store.GetLeaderboard(q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get leaderboard"})
+		return
+	}
+	if entries == nil {
+		entries = []LeaderboardEntry{}
+	}
+
+	var me *LeaderboardEntry
+	if user, err := getUserFromCookie(r, store); err == nil {
+		if me, err = store.GetLeaderboardEntry(q, user.ID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get leaderboard"})
+			return
+		}
+	}
+
+	resp := map[string]any{
+		"entries": entries,
+		"total":   total,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+		"me":      me,
+	}
+	if !q.Since.IsZero() {
+		resp["since"] = q.Since.Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// leaderboardSince turns the since parameter into the start of the window.
+// Weeks start on Monday.
+func leaderboardSince(v string, now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch v {
+	case "", "all":
+		return time.Time{}, nil
+	case "week":
+		return today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7)), nil
+	case "month":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, errInvalidSince
+	}
+	return t, nil
+}
+
+var errInvalidSince = errors.New(`since must be "week", "month", "all" or a date (YYYY-MM-DD)`)
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const oidcCookie = "vt_oidc"
+
+// oidcLoginState is kept in a short-lived cookie between the redirect to
+// the provider and the callback.
+type oidcLoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+}
+
+// handleAuthProviders tells the frontend which login methods are enabled.
+func handleAuthProviders(auth AuthConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := map[string]any{}
+		if auth.OIDC != nil {
+			resp["oidc"] = map[string]string{"name": auth.OIDC.Name()}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// handleOIDCLogin redirects the browser to the identity provider.
+func handleOIDCLogin(auth AuthConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth.OIDC == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "single sign-on is not configured"})
+			return
+		}
+
+		st := oidcLoginState{
+			State:    randomToken(24),
+			Nonce:    randomToken(24),
+			Verifier: randomToken(48),
+			ReturnTo: safeReturnTo(r.URL.Query().Get("return_to")),
+		}
+		target, err := auth.OIDC.AuthCodeURL(oidcRedirectURL(r, auth.OIDC), st.State, st.Nonce, st.Verifier)
+		if err != nil {
+			log.Printf("oidc login: %v", err)
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": "identity provider unavailable"})
+			return
+		}
+
+		data, _ := json.Marshal(st)
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcCookie,
+			Value:    base64.RawURLEncoding.EncodeToString(data),
+			Path:     "/api/auth",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// handleOIDCCallback finishes the login: it exchanges the code, finds or
+// creates the user for the provider's subject and starts a session. A user
+// who is already logged in gets the identity linked to their account.
+func handleOIDCCallback(store Store, auth AuthConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth.OIDC == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "single sign-on is not configured"})
+			return
+		}
+
+		st, err := readOIDCState(r)
+		clearOIDCCookie(w)
+		if err != nil || r.URL.Query().Get("state") != st.State {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "login expired or invalid, please try again"})
+			return
+		}
+		if e := r.URL.Query().Get("error"); e != "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "identity provider: " + e})
+			return
+		}
+
+		claims, err := auth.OIDC.Exchange(r.URL.Query().Get("code"), oidcRedirectURL(r, auth.OIDC), st.Verifier, st.Nonce)
+		if err != nil {
+			log.Printf("oidc callback: %v", err)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "sign-in failed"})
+			return
+		}
+
+		user, err := store.GetIdentityUser(claims.Issuer, claims.Subject)
+		if err == sql.ErrNoRows {
+			user, err = linkOIDCUser(r, store, claims)
+		}
+		if err != nil {
+			log.Printf("oidc user: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to sign in"})
+			return
+		}
+
+		if err := startSession(w, r, store, auth, user.ID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start session"})
+			return
+		}
+		http.Redirect(w, r, st.ReturnTo, http.StatusFound)
+	}
+}
+
+// linkOIDCUser links a new identity to the logged-in user or, if there is
+// none, to a new user named after the identity's claims.
+func linkOIDCUser(r *http.Request, store Store, claims *IDTokenClaims) (*User, error) {
+	user, err := getUserFromCookie(r, store)
+	if err != nil {
+		if user, err = createUserForClaims(store, claims); err != nil {
+			return nil, err
+		}
+	}
+	if err := store.LinkIdentity(claims.Issuer, claims.Subject, user.ID, claims.Email); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// createUserForClaims creates a user named after the first usable claim,
+// adding a number if the name is taken.
+func createUserForClaims(store Store, claims *IDTokenClaims) (*User, error) {
+	base := "user"
+	email, _, _ := strings.Cut(claims.Email, "@")
+	for _, candidate := range []string{claims.PreferredUsername, email, claims.Name} {
+		name := strings.Trim(usernameInvalidChars.ReplaceAllString(candidate, "_"), "_")
+		if len(name) > 20 {
+			name = name[:20]
+		}
+		if usernameRegex.MatchString(name) {
+			base = name
+			break
+		}
+	}
+
+	for i := 1; i <= 100; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		user, err := store.CreateUser(name)
+		if err == nil || !isUniqueViolation(err) {
+			return user, err
+		}
+	}
+	return nil, fmt.Errorf("no free username for %q", base)
+}
+
+func readOIDCState(r *http.Request) (*oidcLoginState, error) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	var st oidcLoginState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	if st.State == "" {
+		return nil, fmt.Errorf("empty state")
+	}
+	return &st, nil
+}
+
+func clearOIDCCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Value: "", Path: "/api/auth", MaxAge: -1, HttpOnly: true})
+}
+
+// oidcRedirectURL is the configured callback URL or, by default, the
+// callback on the origin the request came in on.
+func oidcRedirectURL(r *http.Request, p *OIDCProvider) string {
+	if p.cfg.RedirectURL != "" {
+		return p.cfg.RedirectURL
+	}
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + requestHost(r) + "/api/auth/callback"
+}
+
+// safeReturnTo only allows paths on this site, so the login can't be used
+// as an open redirect.
+func safeReturnTo(s string) string {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.Contains(s, `\`) {
+		return "/"
+	}
+	return s
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// oidcTestServer runs the runner's routes with the mock IdP as its OIDC
+// provider, on a real listener so the provider can reach the mock.
+func oidcTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var handler http.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "runner.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	catalog, err := NewCatalog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := AuthConfig{SessionTTL: defaultSessionTTL}
+	if auth.MockIdP, err = NewMockIdP(srv.URL + mockIdPPath); err != nil {
+		t.Fatal(err)
+	}
+	auth.OIDC = auth.MockIdP.Provider()
+	handler = newServer(catalog, store, nil, NewScheduler(1, 1), nil, nil, nil, auth, "", nil, nil)
+	return srv
+}
+
+// oidcTestClient returns a client that keeps cookies and doesn't follow
+// redirects, so each step of the login can be checked.
+func oidcTestClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// redirectTo checks that resp is a redirect and returns where to.
+func redirectTo(t *testing.T, resp *http.Response) *url.URL {
+	t.Helper()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("%s: status %d, want %d", resp.Request.URL.Path, resp.StatusCode, http.StatusFound)
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// startOIDCLogin starts a login and returns the authorize request the
+// runner sends the browser to.
+func startOIDCLogin(t *testing.T, srv *httptest.Server, client *http.Client) url.Values {
+	t.Helper()
+	resp, err := client.Get(srv.URL + "/api/auth/login?return_to=/courses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := redirectTo(t, resp)
+	if loc.Path != mockIdPPath+"/authorize" {
+		t.Fatalf("login redirects to %s, want the mock's authorize page", loc)
+	}
+	return loc.Query()
+}
+
+// authorize signs in at the mock as alice and returns the callback the
+// mock sends the browser to.
+func authorize(t *testing.T, srv *httptest.Server, client *http.Client, params url.Values) *url.URL {
+	t.Helper()
+	form := url.Values{"sub": {"alice"}, "preferred_username": {"alice"}}
+	for _, k := range []string{"redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		form.Set(k, params.Get(k))
+	}
+	resp, err := client.PostForm(srv.URL+mockIdPPath+"/authorize", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redirectTo(t, resp)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	srv := oidcTestServer(t)
+	client := oidcTestClient(t)
+
+	callback := authorize(t, srv, client, startOIDCLogin(t, srv, client))
+	resp, err := client.Get(callback.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := redirectTo(t, resp); loc.Path != "/courses" {
+		t.Errorf("callback redirects to %s, want /courses", loc)
+	}
+	hasSession := false
+	for _, c := range resp.Cookies() {
+		if c.Name == sessionCookie && c.Value != "" {
+			hasSession = true
+		}
+	}
+	if !hasSession {
+		t.Fatal("callback didn't set a session cookie")
+	}
+
+	resp, err = client.Get(srv.URL + "/api/users/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || me.Username != "alice" {
+		t.Errorf("GET /api/users/me: status %d, username %q; want 200, alice", resp.StatusCode, me.Username)
+	}
+}
+
+func TestOIDCCallbackRejectsMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(params, callback url.Values)
+		want   int
+	}{
+		{"state", func(_, callback url.Values) { callback.Set("state", "forged") }, http.StatusBadRequest},
+		{"pkce", func(params, _ url.Values) { params.Set("code_challenge", "forged") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := oidcTestServer(t)
+			client := oidcTestClient(t)
+
+			params := startOIDCLogin(t, srv, client)
+			tt.tamper(params, url.Values{})
+			callback := authorize(t, srv, client, params)
+			q := callback.Query()
+			tt.tamper(url.Values{}, q)
+			callback.RawQuery = q.Encode()
+
+			resp, err := client.Get(callback.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("callback: status %d, want %d", resp.StatusCode, tt.want)
+			}
+			for _, c := range resp.Cookies() {
+				if c.Name == sessionCookie && c.Value != "" {
+					t.Error("callback set a session cookie")
+				}
+			}
+		})
+	}
+}
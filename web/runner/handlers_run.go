@@ -5,38 +5,66 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// upgrader accepts the run and terminal sockets from pages on this site
+// only. Browsers send the session cookie along with a socket opened by any
+// site, so without the check another site could open a terminal as the
+// student. Requests without an Origin don't come from a browser.
+var upgrader = websocket.Upgrader{CheckOrigin: sameOrigin}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, requestHost(r))
 }
 
 type RunRequest struct {
-	CourseID       string            `json:"course_id"`
-	LessonSlug    string            `json:"lesson_slug"`
-	Code          map[string]string `json:"code"`
-	ViewedSolution bool             `json:"viewed_solution"`
+	CourseID   string            `json:"course_id"`
+	LessonSlug string            `json:"lesson_slug"`
+	Code       map[string]string `json:"code"`
 }
 
+// RunMessage is sent to the client over the run socket. A run of a
+// kubernetes course whose cluster is still coming up first gets a "cluster"
+// message with the cluster's state. A run that has to wait for a free slot
+// gets "queued" messages with its place in the queue. Besides raw output
+// ("stdout", "stderr"), runners with a known output format report each test
+// ("test_start", "test_pass", "test_fail", "test_skip") and a "summary"
+// before the final "exit".
 type RunMessage struct {
-	Type   string `json:"type"` // "stdout", "stderr", "exit", "error"
-	Data   string `json:"data"`
-	Points int    `json:"points,omitempty"`
+	Type     string `json:"type"` // "cluster", "queued", "stdout", "stderr", "test_*", "summary", "exit", "error"
+	Data     string `json:"data"`
+	Points   int    `json:"points,omitempty"`
+	Position int    `json:"position,omitempty"` // queued: 1 is next in line
+	// Test events
+	Test     string  `json:"test,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Message  string  `json:"message,omitempty"`  // failure output
+	// Summary and exit
+	*TestSummary
 }
 
+// defaultRunTimeout applies when a runner config doesn't set a timeout.
 const defaultRunTimeout = 30 * time.Second
-const kubernetesRunTimeout = 3 * time.Minute
 
-func handleRun(index map[string]*Course, store *Store) http.HandlerFunc {
+func handleRun(catalog *Catalog, store Store, executor Executor, scheduler *Scheduler, clusters *ClusterManager, tenants *KubeTenants) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract user before WebSocket upgrade (cookies available on HTTP request)
 		user, _ := getUserFromCookie(r, store)
@@ -62,139 +90,102 @@ func handleRun(index map[string]*Course, store *Store) http.HandlerFunc {
 		}
 
 		// Look up course
-		course, ok := index[req.CourseID]
+		course, ok := catalog.Get(req.CourseID)
 		if !ok {
 			sendMsg(conn, "error", "course not found: "+req.CourseID, 0)
 			return
 		}
 
-		// Build workspace
-		workDir, err := BuildWorkspace(course, req.LessonSlug, req.Code)
+		// From here on the client may cancel the run, and a client that goes
+		// away cancels it too
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go watchRunSocket(ctx, conn, cancel)
+
+		// Kubernetes lessons need their course's cluster to be up
+		releaseCluster, err := clusters.Acquire(ctx, course, func(state ClusterState) {
+			sendMsg(conn, "cluster", string(state), 0)
+		})
 		if err != nil {
-			sendMsg(conn, "error", "workspace error: "+err.Error(), 0)
-			return
-		}
-		defer os.RemoveAll(workDir)
-
-		// Determine test command and timeout based on language
-		var cmdName string
-		var cmdArgs []string
-		var cmdDir string
-		var cmdEnv []string
-		timeout := defaultRunTimeout
-
-		switch course.Language {
-		case "go":
-			cmdName = "go"
-			cmdArgs = []string{"test", "-v", "-count=1", "./..."}
-			cmdDir = workDir
-		case "python":
-			cmdName = "python"
-			cmdArgs = []string{"-m", "pytest", "-v"}
-			cmdDir = workDir
-		case "javascript", "typescript":
-			cmdName = "npm"
-			cmdArgs = []string{"test"}
-			cmdDir = workDir
-		case "kubernetes":
-			timeout = kubernetesRunTimeout
-			// Validate lesson slug for path safety
-			if strings.Contains(req.LessonSlug, "..") || strings.Contains(req.LessonSlug, "/") {
-				sendMsg(conn, "error", "invalid lesson slug", 0)
-				return
+			if ctx.Err() != nil {
+				err = errRunCanceled
 			}
-			courseDir := course.Path
-			lessonDir := filepath.Join(courseDir, "lessons", req.LessonSlug)
-
-			// Run setup.sh first (from shared dir), then validate.sh
-			setupScript := filepath.Join(courseDir, "shared", "setup.sh")
-			validateScript := filepath.Join(lessonDir, "tests", "validate.sh")
-
-			cmdName = "bash"
-			cmdArgs = []string{"-c", fmt.Sprintf("bash %s && bash %s", setupScript, validateScript)}
-			// Run from the writable workspace so scripts can write files (e.g. response.txt)
-			cmdDir = workDir
-			cmdEnv = append(os.Environ(),
-				"WORK_DIR="+workDir,
-				"COURSE_DIR="+courseDir,
-			)
-		default:
-			sendMsg(conn, "error", "unsupported language: "+course.Language, 0)
+			sendMsg(conn, "error", err.Error(), 0)
 			return
 		}
+		defer releaseCluster()
 
-		// Run with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-
-		cmd := exec.CommandContext(ctx, cmdName, cmdArgs...)
-		cmd.Dir = cmdDir
-		if cmdEnv != nil {
-			cmd.Env = cmdEnv
-		}
-
-		// Capture stdout and stderr
-		stdout, err := cmd.StdoutPipe()
+		// Wait for a free slot
+		userKey := clientKey(r, user)
+		release, err := scheduler.Acquire(ctx, userKey, func(position int) {
+			writeRunMsg(conn, RunMessage{Type: "queued", Data: "waiting for a free slot", Position: position})
+		})
 		if err != nil {
-			sendMsg(conn, "error", "pipe error: "+err.Error(), 0)
+			sendMsg(conn, "error", errRunCanceled.Error(), 0)
 			return
 		}
-		stderr, err := cmd.StderrPipe()
+
+		// Kubernetes lessons run in the user's own namespace
+		env, releaseTenant, err := tenants.ForCourse(ctx, course, userKey)
 		if err != nil {
-			sendMsg(conn, "error", "pipe error: "+err.Error(), 0)
+			release()
+			log.Printf("kubernetes tenant: %v", err)
+			sendMsg(conn, "error", "could not prepare your kubernetes namespace: "+err.Error(), 0)
 			return
 		}
 
-		if err := cmd.Start(); err != nil {
-			sendMsg(conn, "error", "start error: "+err.Error(), 0)
+		// Run the tests, streaming each message to the client as it arrives
+		// and keeping the output for the run history
+		var output runOutput
+		startedAt := time.Now().UTC()
+		result, err := runLesson(ctx, executor, course, req.LessonSlug, req.Code, env, func(m RunMessage) {
+			output.add(m)
+			writeRunMsg(conn, m)
+		})
+		releaseTenant()
+		release()
+		if err != nil {
+			sendMsg(conn, "error", err.Error(), 0)
 			return
 		}
+		exitCode, summary := result.ExitCode, result.Summary
 
-		// Stream stdout
-		done := make(chan struct{})
-		go func() {
-			scanner := bufio.NewScanner(stdout)
-			for scanner.Scan() {
-				sendMsg(conn, "stdout", scanner.Text(), 0)
-			}
-			done <- struct{}{}
-		}()
-
-		// Stream stderr
-		go func() {
-			scanner := bufio.NewScanner(stderr)
-			for scanner.Scan() {
-				sendMsg(conn, "stderr", scanner.Text(), 0)
-			}
-			done <- struct{}{}
-		}()
-
-		// Wait for both streams
-		<-done
-		<-done
-
-		err = cmd.Wait()
-		exitCode := 0
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
-			} else if ctx.Err() == context.DeadlineExceeded {
-				sendMsg(conn, "error", fmt.Sprintf("test timed out after %s", timeout), 0)
-				exitCode = -1
-			} else {
-				exitCode = -1
-			}
+		run := &Run{
+			CourseID:   req.CourseID,
+			LessonSlug: req.LessonSlug,
+			ExitCode:   exitCode,
+			DurationMS: result.Duration.Milliseconds(),
+			Passed:     summary.Passed,
+			Failed:     summary.Failed,
+			Skipped:    summary.Skipped,
+			StartedAt:  startedAt.Format(timeFormat),
+		}
+		// Only the student who ran it can read a run's output back, so
+		// anonymous runs are kept without it.
+		if user != nil {
+			run.UserID = user.ID
+			run.Output = output.String()
+		}
+		if result.Err != nil {
+			run.Error = result.Err.Error()
+		}
+		if err := store.RecordRun(run); err != nil {
+			log.Printf("recording run: %v", err)
 		}
 
 		// On success, record completion and calculate points
 		var points int
 		if exitCode == 0 && user != nil {
-			// Check if lesson has a solution
-			lesson, _ := LoadLessonDetail(course, req.LessonSlug)
-			hasSolution := lesson != nil && len(lesson.SolutionCode) > 0
+			hasSolution := LessonHasSolution(course, req.LessonSlug)
+			viewedSolution, err := store.HasViewedSolution(user.ID, req.CourseID, req.LessonSlug)
+			if err != nil {
+				// Don't hand out points we can't justify
+				log.Printf("checking solution view: %v", err)
+				viewedSolution = true
+			}
 
-			points = CalcLessonPoints(course.Difficulty, req.ViewedSolution, hasSolution)
-			if err := store.RecordCompletion(user.ID, req.CourseID, req.LessonSlug, points, req.ViewedSolution); err != nil {
+			points = CalcLessonPoints(course.Difficulty, viewedSolution, hasSolution)
+			if err := store.RecordCompletion(user.ID, req.CourseID, req.LessonSlug, points, viewedSolution, req.Code); err != nil {
 				log.Printf("recording completion: %v", err)
 			}
 
@@ -222,7 +213,11 @@ func handleRun(index map[string]*Course, store *Store) http.HandlerFunc {
 			}
 		}
 
-		sendMsg(conn, "exit", fmt.Sprintf("%d", exitCode), points)
+		exitMsg := RunMessage{Type: "exit", Data: fmt.Sprintf("%d", exitCode), Points: points}
+		if summary.Total > 0 {
+			exitMsg.TestSummary = &summary
+		}
+		writeRunMsg(conn, exitMsg)
 
 		// Send a proper close frame so the client doesn't see a connection error
 		conn.WriteMessage(websocket.CloseMessage,
@@ -230,8 +225,181 @@ func handleRun(index map[string]*Course, store *Store) http.HandlerFunc {
 	}
 }
 
+// watchRunSocket reads the run socket for as long as it stays open and
+// calls cancel when the client sends {"type": "cancel"} or disconnects.
+// Pings catch clients that vanish without closing the connection.
+func watchRunSocket(ctx context.Context, conn *websocket.Conn, cancel context.CancelFunc) {
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		return nil
+	})
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Control frames may be written concurrently with the
+				// run's output.
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval)); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			cancel()
+			return
+		}
+		var m struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(msg, &m) == nil && m.Type == "cancel" {
+			cancel()
+		}
+	}
+}
+
+// clientKey identifies whose run or terminal this is, for the scheduler's
+// per-user limit and kubernetes namespaces: the user, or for anonymous
+// visitors their address (see realIPMiddleware for requests through a proxy).
+func clientKey(r *http.Request, user *User) string {
+	if user != nil {
+		return "user:" + user.ID
+	}
+	return "ip:" + remoteHost(r.RemoteAddr)
+}
+
+// RunResult is the outcome of running a lesson's tests.
+type RunResult struct {
+	ExitCode int
+	Summary  TestSummary
+	Duration time.Duration // how long the test command ran
+	Err      error         // the run didn't complete: timeout or executor failure
+}
+
+// runLesson builds a workspace with code, runs the lesson's tests with
+// executor and passes every message to emit, one at a time. env is added to
+// the test command's environment. Canceling ctx stops the run. It returns an
+// error only if the run couldn't be set up.
+func runLesson(ctx context.Context, executor Executor, course *Course, slug string, code map[string]string, env []string, emit func(RunMessage)) (*RunResult, error) {
+	// Build workspace
+	workDir, err := BuildWorkspace(course, slug, code)
+	if err != nil {
+		return nil, fmt.Errorf("workspace error: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// Resolve the test command from the course/lesson runner config
+	spec, err := ResolveRunSpec(course, slug, workDir)
+	if err != nil {
+		return nil, fmt.Errorf("runner error: %w", err)
+	}
+	spec.Env = append(spec.Env, env...)
+	spec.ReadOnly = append(spec.ReadOnly, tenantFiles(env)...)
+	timeout := spec.Timeout
+
+	// Run with timeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Stream stdout and stderr line by line through the result parser; the
+	// mutex serializes the parser and emit calls from the two scanners.
+	parser := newResultParser(spec.Format, spec.Report)
+	var mu sync.Mutex
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	done := make(chan struct{})
+	stream := func(r io.Reader, stream string) {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			mu.Lock()
+			for _, m := range parser.Line(stream, scanner.Text()) {
+				emit(m)
+			}
+			mu.Unlock()
+		}
+		io.Copy(io.Discard, r)
+		done <- struct{}{}
+	}
+	go stream(stdoutR, "stdout")
+	go stream(stderrR, "stderr")
+
+	start := time.Now()
+	exitCode, err := executor.Run(ctx, spec, stdoutW, stderrW)
+	duration := time.Since(start)
+	stdoutW.Close()
+	stderrW.Close()
+
+	// Wait for both streams
+	<-done
+	<-done
+
+	if err == errRunTimeout {
+		emit(RunMessage{Type: "error", Data: fmt.Sprintf("test timed out after %s", timeout)})
+	} else if err == errRunCanceled {
+		emit(RunMessage{Type: "error", Data: err.Error()})
+	} else if err != nil {
+		emit(RunMessage{Type: "error", Data: "run error: " + err.Error()})
+	}
+
+	for _, m := range parser.Finish(workDir) {
+		emit(m)
+	}
+	summary := parser.Summary()
+	if summary.Total > 0 {
+		emit(RunMessage{Type: "summary", TestSummary: &summary})
+	}
+	return &RunResult{ExitCode: exitCode, Summary: summary, Duration: duration, Err: err}, nil
+}
+
+// maxRunOutput caps how much output is kept for the run history.
+const maxRunOutput = 1 << 20
+
+// runOutput collects a run's output lines and errors, up to maxRunOutput
+// bytes.
+type runOutput struct {
+	buf       strings.Builder
+	truncated bool
+}
+
+func (o *runOutput) add(m RunMessage) {
+	var line string
+	switch m.Type {
+	case "stdout", "stderr":
+		line = m.Data
+	case "error":
+		line = "error: " + m.Data
+	default:
+		return
+	}
+	if o.truncated {
+		return
+	}
+	if o.buf.Len()+len(line)+1 > maxRunOutput {
+		o.truncated = true
+		o.buf.WriteString("[output truncated]\n")
+		return
+	}
+	o.buf.WriteString(line)
+	o.buf.WriteByte('\n')
+}
+
+func (o *runOutput) String() string { return o.buf.String() }
+
 func sendMsg(conn *websocket.Conn, msgType, data string, points int) {
-	msg := RunMessage{Type: msgType, Data: data, Points: points}
+	writeRunMsg(conn, RunMessage{Type: msgType, Data: data, Points: points})
+}
+
+func writeRunMsg(conn *websocket.Conn, msg RunMessage) {
 	b, _ := json.Marshal(msg)
 	conn.WriteMessage(websocket.TextMessage, b)
 }
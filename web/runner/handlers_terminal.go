@@ -3,15 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"os/exec"
-	"sync"
+	"path/filepath"
 	"time"
 
 	"github.com/creack/pty"
-	"github.com/gorilla/websocket"
 )
 
 const (
@@ -19,79 +17,81 @@ const (
 	pongTimeout  = 45 * time.Second
 )
 
+// TerminalMessage is sent both ways over the terminal socket. The client
+// starts with "init" for a lesson and the editor's code, which opens a new
+// session, or with "attach" and the ID of a session it had before. The
+// server answers either with "session" and the session's ID; an attach to a
+// session that has ended gets "expired" instead, and the client may go on
+// to send "init". "file" carries a code file's new content: from the client
+// when the student edits it, and to the client when the shell changes it.
+// "exit" means the session ended.
 type TerminalMessage struct {
-	Type string `json:"type"` // "init", "input", "resize", "output"
+	Type string `json:"type"` // "init", "attach", "session", "expired", "input", "resize", "output", "file", "exit", "error"
 	Data string `json:"data,omitempty"`
-	// Init fields
-	CourseID string `json:"course_id,omitempty"`
+	// Init and attach fields
+	CourseID   string            `json:"course_id,omitempty"`
+	LessonSlug string            `json:"lesson_slug,omitempty"`
+	Code       map[string]string `json:"code,omitempty"`
+	SessionID  string            `json:"session_id,omitempty"`
+	// File fields
+	Path string `json:"path,omitempty"`
 	// Resize fields
 	Cols uint16 `json:"cols,omitempty"`
 	Rows uint16 `json:"rows,omitempty"`
 }
 
-func handleTerminal(index map[string]*Course) http.HandlerFunc {
+func handleTerminal(catalog *Catalog, store Store, executor Executor, scheduler *Scheduler, clusters *ClusterManager, tenants *KubeTenants, sessions *TerminalSessions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		user, userErr := getUserFromCookie(r, store)
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Printf("terminal websocket upgrade: %v", err)
 			return
 		}
 		defer conn.Close()
+		client := &terminalClient{conn: conn}
 
-		// Wait for init message
-		_, msg, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("terminal read init: %v", err)
-			return
-		}
-
-		var initMsg TerminalMessage
-		if err := json.Unmarshal(msg, &initMsg); err != nil || initMsg.Type != "init" {
-			sendMsg(conn, "error", "expected init message", 0)
-			return
-		}
-
-		course, ok := index[initMsg.CourseID]
-		if !ok {
-			sendMsg(conn, "error", "course not found: "+initMsg.CourseID, 0)
-			return
-		}
-
-		// Create a writable workspace for the terminal session
-		termDir, err := os.MkdirTemp("", "vibe-term-*")
-		if err != nil {
-			sendMsg(conn, "error", "failed to create workspace: "+err.Error(), 0)
-			return
-		}
-		defer os.RemoveAll(termDir)
-
-		// Start bash with PTY — inherit env so kubectl picks up kubeconfig from setup.sh
-		cmd := exec.Command("bash", "--login")
-		cmd.Dir = termDir
-		cmd.Env = append(os.Environ(),
-			"TERM=xterm-256color",
-			"COURSE_DIR="+course.Path,
-			"HOME="+os.Getenv("HOME"),
-			"PS1=\\[\\e[32m\\]k8s\\[\\e[0m\\]:\\w$ ",
-		)
+		// Wait for a message that opens or reattaches a session
+		var sess *terminalSession
+		for sess == nil {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				log.Printf("terminal read init: %v", err)
+				return
+			}
+			var initMsg TerminalMessage
+			if err := json.Unmarshal(msg, &initMsg); err != nil || (initMsg.Type != "init" && initMsg.Type != "attach") {
+				sendMsg(conn, "error", "expected init message", 0)
+				return
+			}
+			if userErr != nil {
+				sendMsg(conn, "error", "log in to use the terminal", 0)
+				return
+			}
 
-		ptmx, err := pty.Start(cmd)
-		if err != nil {
-			sendMsg(conn, "error", "pty start error: "+err.Error(), 0)
-			return
+			if initMsg.Type == "attach" {
+				sess = sessions.Get(initMsg.SessionID, user.ID)
+				if sess == nil || !sessions.attach(sess, client, initMsg.Code) {
+					sess = nil
+					client.send(TerminalMessage{Type: "expired"})
+				}
+				continue
+			}
+			sess, err = openTerminal(r, client, catalog, executor, scheduler, clusters, tenants, sessions, user, initMsg)
+			if err != nil {
+				sendMsg(conn, "error", err.Error(), 0)
+				return
+			}
+			if !sessions.attach(sess, client, nil) {
+				return
+			}
 		}
-		defer func() {
-			ptmx.Close()
-			cmd.Process.Kill()
-			cmd.Wait()
-		}()
+		defer sessions.detach(sess, client)
 
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
-		// Mutex to protect concurrent WebSocket writes (pinger + pty reader)
-		var wsMu sync.Mutex
-
 		// Set up pong handler and initial read deadline
 		conn.SetReadDeadline(time.Now().Add(pongTimeout))
 		conn.SetPongHandler(func(string) error {
@@ -108,46 +108,17 @@ func handleTerminal(index map[string]*Course) http.HandlerFunc {
 				case <-ctx.Done():
 					return
 				case <-ticker.C:
-					wsMu.Lock()
-					err := conn.WriteMessage(websocket.PingMessage, nil)
-					wsMu.Unlock()
-					if err != nil {
-						cancel()
+					if err := client.ping(); err != nil {
+						conn.Close()
 						return
 					}
 				}
 			}
 		}()
 
-		// Read from PTY -> send to WebSocket
-		go func() {
-			buf := make([]byte, 4096)
-			for {
-				n, err := ptmx.Read(buf)
-				if err != nil {
-					cancel()
-					return
-				}
-				out := TerminalMessage{Type: "output", Data: string(buf[:n])}
-				b, _ := json.Marshal(out)
-				wsMu.Lock()
-				err = conn.WriteMessage(websocket.TextMessage, b)
-				wsMu.Unlock()
-				if err != nil {
-					cancel()
-					return
-				}
-			}
-		}()
-
-		// Read from WebSocket -> write to PTY
+		// Read from WebSocket -> write to PTY. The session closes the socket
+		// when the shell exits.
 		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
 				return
@@ -160,12 +131,16 @@ func handleTerminal(index map[string]*Course) http.HandlerFunc {
 
 			switch tmsg.Type {
 			case "input":
-				if _, err := ptmx.Write([]byte(tmsg.Data)); err != nil {
+				if err := sess.input(tmsg.Data); err != nil {
 					return
 				}
+			case "file":
+				if err := sess.ws.WriteFile(tmsg.Path, tmsg.Data); err != nil {
+					log.Printf("terminal file sync: %v", err)
+				}
 			case "resize":
 				if tmsg.Cols > 0 && tmsg.Rows > 0 {
-					pty.Setsize(ptmx, &pty.Winsize{
+					pty.Setsize(sess.ptmx, &pty.Winsize{
 						Cols: tmsg.Cols,
 						Rows: tmsg.Rows,
 					})
@@ -174,3 +149,86 @@ func handleTerminal(index map[string]*Course) http.HandlerFunc {
 		}
 	}
 }
+
+// openTerminal starts a shell in a new workspace for the lesson in initMsg
+// and registers its session. Progress while it waits for a cluster goes to
+// client.
+func openTerminal(r *http.Request, client *terminalClient, catalog *Catalog, executor Executor, scheduler *Scheduler, clusters *ClusterManager, tenants *KubeTenants, sessions *TerminalSessions, user *User, initMsg TerminalMessage) (*terminalSession, error) {
+	course, ok := catalog.Get(initMsg.CourseID)
+	if !ok {
+		return nil, fmt.Errorf("course not found: %s", initMsg.CourseID)
+	}
+	if course.findLesson(initMsg.LessonSlug) == nil {
+		return nil, fmt.Errorf("lesson not found: %s", initMsg.LessonSlug)
+	}
+
+	// Kubernetes courses need their cluster up, and get the user's own
+	// namespace in it for as long as the session lasts
+	waitCtx, cancelWait := context.WithTimeout(r.Context(), clusterProvisionTimeout)
+	defer cancelWait()
+	releaseCluster, err := clusters.Acquire(waitCtx, course, func(state ClusterState) {
+		client.send(TerminalMessage{Type: "output", Data: "Waiting for the Kubernetes cluster (" + string(state) + ")...\r\n"})
+	})
+	if err != nil {
+		return nil, err
+	}
+	userKey := clientKey(r, user)
+	tenantEnv, releaseTenant, err := tenants.ForCourse(r.Context(), course, userKey)
+	if err != nil {
+		releaseCluster()
+		log.Printf("kubernetes tenant: %v", err)
+		return nil, fmt.Errorf("could not prepare your kubernetes namespace: %w", err)
+	}
+
+	// Build the lesson's workspace with the code in the editor
+	ws, err := newTerminalWorkspace(course, initMsg.LessonSlug, initMsg.Code)
+	if err != nil {
+		releaseTenant()
+		releaseCluster()
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	// Start bash on a new pty through the executor, so the shell is
+	// sandboxed like a run of the lesson and sees only the allowlisted
+	// host variables, the lesson's and the namespace's
+	shell := &RunSpec{
+		Command: "exec bash --rcfile " + shellQuote(ws.rc) + " -i",
+		Dir:     ws.work,
+		Env: append([]string{
+			"TERM=xterm-256color",
+			"COURSE_DIR=" + course.Path,
+			"LESSON_DIR=" + filepath.Join(course.Path, "lessons", initMsg.LessonSlug),
+			"WORK_DIR=" + ws.work,
+		}, tenantEnv...),
+		WorkDir:  ws.work,
+		ReadOnly: append([]string{ws.bin, ws.rc}, tenantFiles(tenantEnv)...),
+		CacheKey: ws.spec.CacheKey,
+		Sandbox:  ws.spec.Sandbox,
+	}
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		ws.Close()
+		releaseTenant()
+		releaseCluster()
+		return nil, fmt.Errorf("pty open error: %w", err)
+	}
+	stop, err := executor.Start(shell, tty)
+	tty.Close()
+	if err != nil {
+		ptmx.Close()
+		ws.Close()
+		releaseTenant()
+		releaseCluster()
+		return nil, fmt.Errorf("shell start error: %w", err)
+	}
+	// run-tests asks the session to run the tests, in the shell's namespace
+	tests := &terminalTests{
+		executor:  executor,
+		scheduler: scheduler,
+		course:    course,
+		slug:      initMsg.LessonSlug,
+		key:       userKey,
+		env:       tenantEnv,
+	}
+	return sessions.Start(user.ID, stop, ptmx, ws, tests, releaseTenant, releaseCluster), nil
+}
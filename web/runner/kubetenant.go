@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Labels and annotations on tenant namespaces. The last-used annotation lets
+// a restarted runner collect namespaces its predecessor left behind. Pod
+// Security admission holds pods in them to the baseline profile: no
+// privileged containers, host namespaces or hostPath volumes, which would
+// let a student out onto the node.
+const (
+	tenantManagedByLabel     = "app.kubernetes.io/managed-by"
+	tenantManagedBy          = "vibe-train"
+	tenantLastUsedAnnotation = "vibe-train/last-used"
+	tenantPodSecurityLabel   = "pod-security.kubernetes.io/enforce"
+	tenantPodSecurity        = "baseline"
+)
+
+// kubectlTimeout bounds each call the runner makes to the cluster API.
+const kubectlTimeout = 30 * time.Second
+
+// Tenant credentials. Each namespace has a service account that may manage
+// the workloads, configuration and roles the lessons use inside it, and
+// runs and terminals get a token for it that lasts tenantTokenTTL. Outside its namespace it may only read nodes and the
+// namespace itself, and list the kube-system services `kubectl
+// cluster-info` shows.
+const (
+	tenantServiceAccount  = "student"
+	tenantClusterInfoRole = "vibe-train-cluster-info"
+	tenantTokenTTL        = 24 * time.Hour
+)
+
+// tenantDeletePoll is how often Acquire checks whether a namespace that is
+// being deleted is gone, so it can be created again.
+const tenantDeletePoll = 2 * time.Second
+
+// KubeTenants gives each user of a kubernetes course a namespace of their
+// own in the course cluster, so two students running the same lesson don't
+// delete each other's pods. A run or terminal gets a kubeconfig for the
+// namespace's service account, which can't reach other namespaces or change
+// anything cluster-wide. Namespaces nobody has used for the idle period are
+// deleted, and their cluster-wide role bindings with them.
+type KubeTenants struct {
+	dir  string // tenant kubeconfigs
+	idle time.Duration
+
+	mu       sync.Mutex
+	inUse    map[string]int           // runs and terminals holding a namespace
+	lastUsed map[string]time.Time     // by namespace, since this runner started
+	deleting map[string]chan struct{} // closed once collect has deleted the namespace
+}
+
+// NewKubeTenants returns a tenant manager that writes kubeconfigs to dir and
+// deletes namespaces after idle without use. The cluster is reached through
+// the runner's own kubeconfig ($KUBECONFIG or ~/.kube/config).
+func NewKubeTenants(dir string, idle time.Duration) (*KubeTenants, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating tenant kubeconfig dir: %w", err)
+	}
+	return &KubeTenants{
+		dir:      dir,
+		idle:     idle,
+		inUse:    make(map[string]int),
+		lastUsed: make(map[string]time.Time),
+		deleting: make(map[string]chan struct{}),
+	}, nil
+}
+
+// ForCourse acquires user's namespace if course is a kubernetes course, and
+// otherwise returns no environment. kt may be nil, in which case all users
+// share the cluster's default namespace.
+func (kt *KubeTenants) ForCourse(ctx context.Context, course *Course, user string) (env []string, release func(), err error) {
+	if kt == nil || course.Language != "kubernetes" {
+		return nil, func() {}, nil
+	}
+	return kt.Acquire(ctx, user)
+}
+
+// tenantFiles returns the files a tenant environment from Acquire points
+// at. Sandboxed runs must be able to read them even though the sandbox
+// hides the directory they are in.
+func tenantFiles(env []string) []string {
+	for _, kv := range env {
+		if path, ok := strings.CutPrefix(kv, "KUBECONFIG="); ok {
+			return []string{path}
+		}
+	}
+	return nil
+}
+
+// RunnerKubeconfigs returns the places the runner's own kubeconfigs live:
+// ~/.kube, the tenant kubeconfig dir and anything on $KUBECONFIG. With
+// tenants on, the sandbox hides them from runs.
+func RunnerKubeconfigs(tenantDir string) []string {
+	paths := []string{filepath.Join(os.Getenv("HOME"), ".kube"), tenantDir}
+	for _, p := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// tenantNamespace returns the namespace for a user key. Keys are hashed so
+// that any key makes a valid name and user IDs don't show up in the cluster.
+func tenantNamespace(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return "vt-" + hex.EncodeToString(sum[:6])
+}
+
+// Acquire makes sure user's namespace exists and returns the environment
+// that points kubectl at it: KUBECONFIG and KUBE_NAMESPACE. The namespace is
+// kept until release is called and then for the idle period after that. If
+// the namespace is being deleted, Acquire waits for it to go and creates it
+// again.
+func (kt *KubeTenants) Acquire(ctx context.Context, user string) (env []string, release func(), err error) {
+	ns := tenantNamespace(user)
+
+	for {
+		kt.mu.Lock()
+		deleted := kt.deleting[ns]
+		if deleted == nil {
+			kt.inUse[ns]++
+			kt.lastUsed[ns] = time.Now()
+			kt.mu.Unlock()
+			break
+		}
+		kt.mu.Unlock()
+		select {
+		case <-deleted:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			kt.mu.Lock()
+			defer kt.mu.Unlock()
+			if kt.inUse[ns]--; kt.inUse[ns] <= 0 {
+				delete(kt.inUse, ns)
+			}
+			kt.lastUsed[ns] = time.Now()
+		})
+	}
+
+	if err := kt.applyNamespace(ctx, ns); err != nil {
+		release()
+		return nil, nil, err
+	}
+	path, err := kt.writeKubeconfig(ctx, ns)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return []string{"KUBECONFIG=" + path, "KUBE_NAMESPACE=" + ns}, release, nil
+}
+
+// applyNamespace creates ns and its service account and roles, or stamps
+// its last-used time if they exist. A namespace that is being deleted, by
+// this runner or another, can't take new objects, so it waits for it to go.
+func (kt *KubeTenants) applyNamespace(ctx context.Context, ns string) error {
+	manifest, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata": map[string]any{
+			"name":        ns,
+			"labels":      map[string]string{tenantManagedByLabel: tenantManagedBy, tenantPodSecurityLabel: tenantPodSecurity},
+			"annotations": map[string]string{tenantLastUsedAnnotation: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	var uid string
+	for {
+		out, err := kubectl(ctx, bytes.NewReader(manifest), "apply", "-f", "-", "-o", `jsonpath={.metadata.uid}{" "}{.metadata.deletionTimestamp}`)
+		if err != nil {
+			return fmt.Errorf("creating namespace %s: %w", ns, err)
+		}
+		var deleting string
+		uid, deleting, _ = strings.Cut(string(out), " ")
+		if deleting == "" {
+			break
+		}
+		select {
+		case <-time.After(tenantDeletePoll):
+		case <-ctx.Done():
+			return fmt.Errorf("namespace %s is still being deleted: %w", ns, ctx.Err())
+		}
+	}
+	if _, err := kubectl(ctx, bytes.NewReader(tenantRBAC(ns, uid)), "apply", "-f", "-"); err != nil {
+		return fmt.Errorf("granting access to namespace %s: %w", ns, err)
+	}
+	return nil
+}
+
+// tenantRules is what the service account may do in its namespace: manage
+// the objects the lessons use, and impersonate its own service accounts for
+// `kubectl auth can-i --as`. It may write roles but not escalate or bind
+// them, so a role can't grant more than these rules.
+var tenantRules = []map[string]any{
+	{
+		"apiGroups": []string{""},
+		"resources": []string{"pods", "services", "endpoints", "configmaps", "secrets", "persistentvolumeclaims", "serviceaccounts"},
+		"verbs":     tenantVerbs,
+	},
+	{"apiGroups": []string{""}, "resources": []string{"pods/log", "events"}, "verbs": []string{"get", "list", "watch"}},
+	{"apiGroups": []string{""}, "resources": []string{"pods/exec", "pods/attach", "pods/portforward"}, "verbs": []string{"get", "create"}},
+	{"apiGroups": []string{""}, "resources": []string{"serviceaccounts"}, "verbs": []string{"impersonate"}},
+	{"apiGroups": []string{"apps"}, "resources": []string{"deployments", "deployments/scale", "replicasets", "statefulsets", "daemonsets"}, "verbs": tenantVerbs},
+	{"apiGroups": []string{"batch"}, "resources": []string{"jobs", "cronjobs"}, "verbs": tenantVerbs},
+	{"apiGroups": []string{"networking.k8s.io"}, "resources": []string{"ingresses"}, "verbs": tenantVerbs},
+	{"apiGroups": []string{"rbac.authorization.k8s.io"}, "resources": []string{"roles", "rolebindings"}, "verbs": tenantVerbs},
+	{"apiGroups": []string{"events.k8s.io"}, "resources": []string{"events"}, "verbs": []string{"get", "list", "watch"}},
+}
+
+var tenantVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection"}
+
+// tenantRBAC returns the service account of namespace ns and its roles. The
+// cluster-wide objects are owned by the namespace, whose UID is uid, so the
+// cluster deletes them along with it.
+func tenantRBAC(ns, uid string) []byte {
+	labels := map[string]string{tenantManagedByLabel: tenantManagedBy}
+	owners := []map[string]any{{"apiVersion": "v1", "kind": "Namespace", "name": ns, "uid": uid}}
+	subjects := []map[string]any{{"kind": "ServiceAccount", "name": tenantServiceAccount, "namespace": ns}}
+	roleRef := func(kind, name string) map[string]any {
+		return map[string]any{"apiGroup": "rbac.authorization.k8s.io", "kind": kind, "name": name}
+	}
+	object := func(kind, namespace, name string, owned bool) map[string]any {
+		meta := map[string]any{"name": name, "labels": labels}
+		if namespace != "" {
+			meta["namespace"] = namespace
+		}
+		if owned {
+			meta["ownerReferences"] = owners
+		}
+		apiVersion := "rbac.authorization.k8s.io/v1"
+		if kind == "ServiceAccount" {
+			apiVersion = "v1"
+		}
+		return map[string]any{"apiVersion": apiVersion, "kind": kind, "metadata": meta}
+	}
+
+	sa := object("ServiceAccount", ns, tenantServiceAccount, false)
+	role := object("Role", ns, tenantServiceAccount, false)
+	role["rules"] = tenantRules
+	binding := object("RoleBinding", ns, tenantServiceAccount, false)
+	binding["subjects"], binding["roleRef"] = subjects, roleRef("Role", tenantServiceAccount)
+
+	clusterRole := object("ClusterRole", "", ns, true)
+	clusterRole["rules"] = []map[string]any{
+		{"apiGroups": []string{""}, "resources": []string{"nodes"}, "verbs": []string{"get", "list", "watch"}},
+		{"apiGroups": []string{""}, "resources": []string{"namespaces"}, "resourceNames": []string{ns}, "verbs": []string{"get"}},
+	}
+	clusterBinding := object("ClusterRoleBinding", "", ns, true)
+	clusterBinding["subjects"], clusterBinding["roleRef"] = subjects, roleRef("ClusterRole", ns)
+
+	clusterInfo := object("ClusterRole", "", tenantClusterInfoRole, false)
+	clusterInfo["rules"] = []map[string]any{{"apiGroups": []string{""}, "resources": []string{"services"}, "verbs": []string{"list"}}}
+	clusterInfoBinding := object("RoleBinding", "kube-system", ns, true)
+	clusterInfoBinding["subjects"], clusterInfoBinding["roleRef"] = subjects, roleRef("ClusterRole", tenantClusterInfoRole)
+
+	b, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      []any{sa, role, binding, clusterRole, clusterBinding, clusterInfo, clusterInfoBinding},
+	})
+	return b
+}
+
+// writeKubeconfig writes a kubeconfig for the service account of ns, on the
+// cluster of the runner's current context, and returns its path.
+func (kt *KubeTenants) writeKubeconfig(ctx context.Context, ns string) (string, error) {
+	out, err := kubectl(ctx, nil, "config", "view", "--raw", "--minify", "--flatten")
+	if err != nil {
+		return "", fmt.Errorf("reading kubeconfig: %w", err)
+	}
+	var current struct {
+		Clusters []map[string]any `yaml:"clusters"`
+	}
+	if err := yaml.Unmarshal(out, &current); err != nil {
+		return "", fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+	if len(current.Clusters) != 1 {
+		return "", fmt.Errorf("kubeconfig has no current context")
+	}
+	cluster := current.Clusters[0]
+	cluster["name"] = ns
+
+	token, err := kubectl(ctx, nil, "create", "token", tenantServiceAccount, "-n", ns, "--duration", tenantTokenTTL.String())
+	if err != nil {
+		return "", fmt.Errorf("creating token for namespace %s: %w", ns, err)
+	}
+	b, err := yaml.Marshal(map[string]any{
+		"apiVersion":      "v1",
+		"kind":            "Config",
+		"clusters":        []any{cluster},
+		"users":           []any{map[string]any{"name": ns, "user": map[string]any{"token": strings.TrimSpace(string(token))}}},
+		"contexts":        []any{map[string]any{"name": ns, "context": map[string]any{"cluster": ns, "user": ns, "namespace": ns}}},
+		"current-context": ns,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Runs and terminals of the same user may write at the same time, so
+	// write to a temporary file and rename it into place.
+	path := filepath.Join(kt.dir, ns+".yaml")
+	f, err := os.CreateTemp(kt.dir, ns+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Collect runs until ctx ends, deleting tenant namespaces, including those
+// left by earlier runners, that have been idle for longer than the idle
+// period.
+func (kt *KubeTenants) Collect(ctx context.Context) {
+	interval := kt.idle / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := kt.collect(ctx); err != nil {
+				log.Printf("collecting idle kubernetes namespaces: %v", err)
+			} else if n > 0 {
+				log.Printf("deleted %d idle kubernetes namespace(s)", n)
+			}
+		}
+	}
+}
+
+// collect deletes the idle tenant namespaces once and returns how many.
+func (kt *KubeTenants) collect(ctx context.Context) (int, error) {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return 0, nil // no cluster to clean up
+	}
+	out, err := kubectl(ctx, nil, "get", "namespaces", "-l", tenantManagedByLabel+"="+tenantManagedBy, "-o", "json")
+	if err != nil {
+		return 0, err
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name              string            `json:"name"`
+				Annotations       map[string]string `json:"annotations"`
+				DeletionTimestamp string            `json:"deletionTimestamp"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return 0, fmt.Errorf("parsing namespaces: %w", err)
+	}
+
+	n := 0
+	for _, item := range list.Items {
+		ns := item.Metadata.Name
+		if item.Metadata.DeletionTimestamp != "" {
+			continue
+		}
+		lastUsed, _ := time.Parse(time.RFC3339, item.Metadata.Annotations[tenantLastUsedAnnotation])
+
+		// Decide and mark the namespace under one lock, so an Acquire
+		// either counts as use here or waits for the delete to finish.
+		kt.mu.Lock()
+		if t := kt.lastUsed[ns]; t.After(lastUsed) {
+			lastUsed = t
+		}
+		idle := kt.inUse[ns] == 0 && time.Since(lastUsed) >= kt.idle
+		deleted := make(chan struct{})
+		if idle {
+			kt.deleting[ns] = deleted
+		}
+		kt.mu.Unlock()
+		if !idle {
+			continue
+		}
+
+		_, err := kubectl(ctx, nil, "delete", "namespace", ns, "--ignore-not-found", "--wait=false")
+		if err == nil {
+			os.Remove(filepath.Join(kt.dir, ns+".yaml"))
+		}
+		kt.mu.Lock()
+		delete(kt.deleting, ns)
+		if err == nil {
+			delete(kt.lastUsed, ns)
+		}
+		kt.mu.Unlock()
+		close(deleted)
+		if err != nil {
+			log.Printf("deleting idle namespace %s: %v", ns, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// kubectl runs kubectl against the runner's own kubeconfig and returns its
+// standard output.
+func kubectl(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubectlTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("kubectl %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("kubectl %s: %w", args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// LintIssue is one problem found in a course.
+type LintIssue struct {
+	Severity string `json:"severity"` // "error" or "warning"
+	Course   string `json:"course,omitempty"`
+	Lesson   string `json:"lesson,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+// LintReport is the result of checking every course under a root.
+type LintReport struct {
+	Courses  int         `json:"courses"`
+	Errors   int         `json:"errors"`
+	Warnings int         `json:"warnings"`
+	Issues   []LintIssue `json:"issues"`
+}
+
+var (
+	courseIDPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	lessonSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+	courseDifficulties = []string{"beginner", "intermediate", "advanced"}
+	courseLessonModes  = []string{lessonModeCumulative, lessonModeIsolated}
+	courseDepStrategy  = []string{"shared", "per-lesson"}
+)
+
+// LintCourses loads and checks every course under root.
+func LintCourses(root string) (*LintReport, error) {
+	courses, parseErrs, err := ScanCourses(root)
+	if err != nil {
+		return nil, err
+	}
+	return lintCourses(courses, parseErrs), nil
+}
+
+// lintCourses checks already loaded courses. Parse errors from ScanCourses
+// are reported as errors without a course.
+func lintCourses(courses []*Course, parseErrs []error) *LintReport {
+	r := &LintReport{Courses: len(courses), Issues: []LintIssue{}}
+	for _, err := range parseErrs {
+		r.add(LintIssue{Severity: lintError, Message: err.Error()})
+	}
+
+	byID := make(map[string][]*Course)
+	for _, c := range courses {
+		if c.ID != "" {
+			byID[c.ID] = append(byID[c.ID], c)
+		}
+	}
+	for id, dups := range byID {
+		if len(dups) < 2 {
+			continue
+		}
+		for i, c := range dups {
+			other := dups[(i+1)%len(dups)]
+			r.add(LintIssue{Severity: lintError, Course: id, Path: c.Path,
+				Message: fmt.Sprintf("%s: course id is also used by %s", c.Path, other.Path)})
+		}
+	}
+
+	for _, c := range courses {
+		lintCourse(r, c, byID)
+	}
+
+	sort.SliceStable(r.Issues, func(i, j int) bool {
+		a, b := r.Issues[i], r.Issues[j]
+		if a.Course != b.Course {
+			return a.Course < b.Course
+		}
+		return a.Lesson < b.Lesson
+	})
+	return r
+}
+
+func (r *LintReport) add(issue LintIssue) {
+	if issue.Severity == lintError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// failed returns the IDs of courses with at least one error.
+func (r *LintReport) failed() map[string]bool {
+	ids := make(map[string]bool)
+	for _, issue := range r.Issues {
+		if issue.Severity == lintError && issue.Course != "" {
+			ids[issue.Course] = true
+		}
+	}
+	return ids
+}
+
+func lintCourse(r *LintReport, c *Course, byID map[string][]*Course) {
+	yamlPath := filepath.Join(c.Path, "course.yaml")
+	errorf := func(lesson, format string, args ...any) {
+		r.add(LintIssue{Severity: lintError, Course: c.ID, Lesson: lesson, Path: yamlPath, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(lesson, format string, args ...any) {
+		r.add(LintIssue{Severity: lintWarning, Course: c.ID, Lesson: lesson, Path: yamlPath, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case c.ID == "":
+		errorf("", "missing id")
+	case !courseIDPattern.MatchString(c.ID):
+		errorf("", "id %q must be lowercase letters, digits and dashes", c.ID)
+	}
+	if c.Title == "" {
+		errorf("", "missing title")
+	}
+	if _, ok := languageRunners[c.Language]; !ok {
+		errorf("", "unknown language %q (want one of %s)", c.Language, joinKeys(languageRunners))
+	}
+	if !slices.Contains(courseDifficulties, c.Difficulty) {
+		errorf("", "unknown difficulty %q (want one of %v)", c.Difficulty, courseDifficulties)
+	}
+	if c.LessonMode != "" && !slices.Contains(courseLessonModes, c.LessonMode) {
+		errorf("", "unknown lesson_mode %q (want one of %v)", c.LessonMode, courseLessonModes)
+	}
+	if c.Dependencies != nil && c.Dependencies.Strategy != "" && !slices.Contains(courseDepStrategy, c.Dependencies.Strategy) {
+		errorf("", "unknown dependencies strategy %q (want one of %v)", c.Dependencies.Strategy, courseDepStrategy)
+	}
+
+	// Prerequisites are free text ("Basic Go syntax"), except entries that
+	// look like a course ID, which must name another course.
+	for _, p := range c.Prerequisites {
+		if !courseIDPattern.MatchString(p) {
+			continue
+		}
+		if p == c.ID {
+			errorf("", "course lists itself as a prerequisite")
+		} else if _, ok := byID[p]; !ok {
+			errorf("", "prerequisite %q is not a known course", p)
+		}
+	}
+
+	if len(c.Lessons) == 0 {
+		errorf("", "course has no lessons")
+	}
+	seen := make(map[string]bool)
+	for _, l := range c.Lessons {
+		if l.Slug == "" {
+			errorf("", "lesson %q has no slug", l.Title)
+			continue
+		}
+		if !lessonSlugPattern.MatchString(l.Slug) {
+			errorf(l.Slug, "slug must be lowercase letters, digits and dashes")
+			continue
+		}
+		if seen[l.Slug] {
+			errorf(l.Slug, "duplicate lesson slug")
+			continue
+		}
+		seen[l.Slug] = true
+		if l.Title == "" {
+			errorf(l.Slug, "missing title")
+		}
+		lintLesson(r, c, l.Slug)
+		if _, err := ResolveRunSpec(c, l.Slug, filepath.Join(os.TempDir(), "lint")); err != nil {
+			errorf(l.Slug, "test_runner: %v", err)
+		}
+	}
+
+	// Lesson directories that course.yaml doesn't list are never served.
+	entries, _ := os.ReadDir(filepath.Join(c.Path, "lessons"))
+	for _, e := range entries {
+		if e.IsDir() && !seen[e.Name()] {
+			warnf(e.Name(), "lessons/%s is not listed in course.yaml", e.Name())
+		}
+	}
+}
+
+// lintLesson checks that a lesson's directory has the files the runner
+// serves and tests against.
+func lintLesson(r *LintReport, c *Course, slug string) {
+	dir := filepath.Join(c.Path, "lessons", slug)
+	issue := func(severity, path, msg string) {
+		r.add(LintIssue{Severity: severity, Course: c.ID, Lesson: slug, Path: path, Message: msg})
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		issue(lintError, dir, "lesson directory is missing")
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dir, "README.md")); err != nil {
+		issue(lintError, filepath.Join(dir, "README.md"), "missing README.md")
+	}
+	if !hasFiles(filepath.Join(dir, "starter")) {
+		issue(lintError, filepath.Join(dir, "starter"), "no starter files")
+	}
+	if !hasFiles(filepath.Join(dir, "tests")) {
+		issue(lintError, filepath.Join(dir, "tests"), "no tests")
+	}
+	if !hasFiles(filepath.Join(dir, "solution")) {
+		issue(lintWarning, filepath.Join(dir, "solution"), "no solution files")
+	}
+}
+
+// formatLintIssue renders an issue as "course/lesson: message".
+func formatLintIssue(issue LintIssue) string {
+	where := issue.Course
+	if issue.Lesson != "" {
+		where += "/" + issue.Lesson
+	}
+	if where == "" {
+		return issue.Message
+	}
+	return where + ": " + issue.Message
+}
+
+func joinKeys[V any](m map[string]V) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// runLint implements `runner lint`. It prints the report and returns the
+// process exit code: 1 if any course has errors.
+func runLint(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	coursesRoot := fs.String("courses-root", "/courses", "path to courses directory")
+	format := fs.String("format", "json", "report format: json or text")
+	strict := fs.Bool("strict", false, "treat warnings as errors")
+	fs.Parse(args)
+
+	report, err := LintCourses(*coursesRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lint: %v\n", err)
+		return 2
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	case "text":
+		for _, issue := range report.Issues {
+			fmt.Fprintf(stdout, "%s: %s\n", issue.Severity, formatLintIssue(issue))
+		}
+		fmt.Fprintf(stdout, "%d course(s), %d error(s), %d warning(s)\n", report.Courses, report.Errors, report.Warnings)
+	default:
+		fmt.Fprintf(os.Stderr, "lint: unknown format %q\n", *format)
+		return 2
+	}
+
+	if report.Errors > 0 || (*strict && report.Warnings > 0) {
+		return 1
+	}
+	return 0
+}
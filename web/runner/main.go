@@ -1,59 +1,171 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
 )
 
 func main() {
+	maybeSandboxInit()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(runLint(os.Args[2:], os.Stdout))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:], os.Stdout))
+		}
+	}
+
 	coursesRoot := flag.String("courses-root", "/courses", "path to courses directory")
 	port := flag.Int("port", 8081, "server port")
 	dbPath := flag.String("db-path", "/data/vibe-train.db", "path to SQLite database")
+	databaseURL := flag.String("database-url", os.Getenv("DATABASE_URL"), "PostgreSQL URL (postgres://...) to use instead of the SQLite database (default $DATABASE_URL)")
+	executorName := flag.String("executor", "sandbox", "how to run student code: sandbox or local (no isolation)")
+	sandboxCache := flag.String("sandbox-cache", "/tmp/vibe-cache", "directory for per-course build caches in the sandbox")
+	sandboxCgroup := flag.String("sandbox-cgroup", "/sys/fs/cgroup/vibe-train", "cgroup v2 directory for per-run resource limits (empty to use rlimits)")
+	maxRuns := flag.Int("max-runs", runtime.NumCPU(), "test runs allowed at once; more wait in a queue")
+	maxRunsPerUser := flag.Int("max-runs-per-user", 1, "test runs one user may have going at once")
+	clusterProvisioner := flag.String("clusters", "k3d", "how to provision kubernetes course clusters: k3d, or fake to pretend (development without Docker)")
+	kubeTenants := flag.Bool("kube-tenants", true, "give each user their own namespace in kubernetes course clusters")
+	kubeTenantIdle := flag.Duration("kube-tenant-idle", 30*time.Minute, "delete a user's kubernetes namespace after this long without runs or terminals")
+	kubeTenantDir := flag.String("kube-tenant-dir", filepath.Join(os.Getenv("HOME"), ".kube", "vibe-train"), "directory for per-user kubeconfigs; runs of kubernetes courses must be able to read it")
+	terminalGrace := flag.Duration("terminal-grace", 5*time.Minute, "how long a terminal keeps running after its browser disconnects, waiting to be reattached")
+	watchInterval := flag.Duration("watch-courses", 5*time.Second, "how often to check the courses root for changes (0 disables)")
+	trustedProxies := flag.String("trusted-proxies", os.Getenv("VT_TRUSTED_PROXIES"), "comma-separated addresses or CIDR ranges of reverse proxies whose X-Real-IP header names the client (default $VT_TRUSTED_PROXIES)")
+	corsOrigins := flag.String("cors-origins", os.Getenv("VT_CORS_ORIGINS"), "comma-separated origins (https://host[:port]) of frontends served from another host that may call the API (default $VT_CORS_ORIGINS)")
+	adminToken := flag.String("admin-token", os.Getenv("VT_ADMIN_TOKEN"), "bearer token for /api/admin endpoints (default $VT_ADMIN_TOKEN; empty disables them)")
+	sessionTTL := flag.Duration("session-ttl", defaultSessionTTL, "how long login sessions last")
+	legacyCookies := flag.Bool("legacy-cookies", false, "let browsers trade the old vt_user_id cookie for a session (migration only; that cookie can be forged)")
+	var oidcCfg OIDCConfig
+	flag.StringVar(&oidcCfg.Issuer, "oidc-issuer", os.Getenv("VT_OIDC_ISSUER"), "OpenID Connect issuer URL for single sign-on (default $VT_OIDC_ISSUER)")
+	flag.StringVar(&oidcCfg.ClientID, "oidc-client-id", os.Getenv("VT_OIDC_CLIENT_ID"), "OpenID Connect client ID (default $VT_OIDC_CLIENT_ID)")
+	flag.StringVar(&oidcCfg.ClientSecret, "oidc-client-secret", os.Getenv("VT_OIDC_CLIENT_SECRET"), "OpenID Connect client secret (default $VT_OIDC_CLIENT_SECRET)")
+	flag.StringVar(&oidcCfg.RedirectURL, "oidc-redirect-url", os.Getenv("VT_OIDC_REDIRECT_URL"), "callback URL registered with the provider (default <request origin>/api/auth/callback)")
+	flag.StringVar(&oidcCfg.Name, "oidc-name", os.Getenv("VT_OIDC_NAME"), "provider name shown on the login button")
+	oidcMock := flag.Bool("oidc-mock", false, "enable single sign-on through a built-in mock provider (development only)")
 	flag.Parse()
 
-	courses, err := ScanCourses(*coursesRoot)
+	catalog, err := NewCatalog(*coursesRoot)
 	if err != nil {
 		log.Fatalf("scanning courses: %v", err)
 	}
+	courses := catalog.Courses()
 	log.Printf("loaded %d course(s) from %s", len(courses), *coursesRoot)
 	for _, c := range courses {
 		log.Printf("  - %s (%d lessons)", c.ID, len(c.Lessons))
 	}
 
-	// Pre-spin clusters for kubernetes courses in the background
-	for _, c := range courses {
-		if c.Language == "kubernetes" {
-			setupScript := filepath.Join(c.Path, "shared", "setup.sh")
-			if _, err := os.Stat(setupScript); err == nil {
-				go func(course *Course, script string) {
-					log.Printf("pre-spinning cluster for %s...", course.ID)
-					cmd := exec.Command("bash", script)
-					cmd.Dir = course.Path
-					cmd.Stdout = os.Stdout
-					cmd.Stderr = os.Stderr
-					cmd.Env = os.Environ()
-					if err := cmd.Run(); err != nil {
-						log.Printf("warning: cluster setup for %s failed: %v", course.ID, err)
-					} else {
-						log.Printf("cluster for %s is ready", course.ID)
-					}
-				}(c, setupScript)
+	// Bring up clusters for kubernetes courses in the background, and keep
+	// them healthy
+	provisioner, err := NewClusterProvisioner(*clusterProvisioner)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	clusters := NewClusterManager(provisioner, clusterCheckInterval)
+	clusters.Sync(courses)
+	catalog.OnReload(clusters.Sync)
+
+	var store Store
+	if *databaseURL != "" {
+		if !isPostgresURL(*databaseURL) {
+			log.Fatalf("--database-url must be a postgres:// URL")
+		}
+		store, err = OpenStore(*databaseURL)
+		if err != nil {
+			log.Fatalf("opening database: %v", err)
+		}
+		log.Printf("using PostgreSQL database")
+	} else {
+		store, err = OpenStore(*dbPath)
+		if err != nil {
+			log.Fatalf("opening database: %v", err)
+		}
+		log.Printf("database opened at %s", *dbPath)
+	}
+
+	if n := ReapWorkspaces(os.TempDir()); n > 0 {
+		log.Printf("removed %d leftover workspace(s) from %s", n, os.TempDir())
+	}
+
+	// Runs of kubernetes courses get their tenant's kubeconfig, never the
+	// runner's cluster admin one
+	hide := []string{filepath.Dir(*dbPath), "/var/run/docker.sock"}
+	if *kubeTenants {
+		hide = append(hide, RunnerKubeconfigs(*kubeTenantDir)...)
+	}
+	executor, err := NewExecutor(*executorName, SandboxOptions{
+		CacheDir:  *sandboxCache,
+		CgroupDir: *sandboxCgroup,
+		Hide:      hide,
+		Courses:   *coursesRoot,
+	})
+	if err != nil {
+		log.Fatalf("setting up %s executor: %v", *executorName, err)
+	}
+	log.Printf("running tests with the %s executor", *executorName)
+
+	// Pick up course changes without a restart
+	if *watchInterval > 0 {
+		go catalog.Watch(context.Background(), *watchInterval)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := catalog.Reload(); err != nil {
+				log.Printf("course reload failed, keeping previous courses: %v", err)
 			}
 		}
+	}()
+
+	auth := AuthConfig{SessionTTL: *sessionTTL, LegacyCookies: *legacyCookies}
+	switch {
+	case *oidcMock:
+		// The runner talks to the mock over loopback, on its own port
+		if auth.MockIdP, err = NewMockIdP(fmt.Sprintf("http://127.0.0.1:%d%s", *port, mockIdPPath)); err != nil {
+			log.Fatalf("starting mock identity provider: %v", err)
+		}
+		auth.OIDC = auth.MockIdP.Provider()
+		log.Printf("single sign-on through the mock identity provider at %s/authorize", mockIdPPath)
+	case oidcCfg.Issuer != "":
+		if auth.OIDC, err = NewOIDCProvider(oidcCfg, nil); err != nil {
+			log.Fatalf("configuring single sign-on: %v", err)
+		}
+		log.Printf("single sign-on through %s", oidcCfg.Issuer)
+	}
+	scheduler := NewScheduler(*maxRuns, *maxRunsPerUser)
+	log.Printf("allowing %d test run(s) at once, %d per user", *maxRuns, *maxRunsPerUser)
+
+	var tenants *KubeTenants
+	if *kubeTenants {
+		if tenants, err = NewKubeTenants(*kubeTenantDir, *kubeTenantIdle); err != nil {
+			log.Fatalf("setting up kubernetes namespaces: %v", err)
+		}
+		go tenants.Collect(context.Background())
 	}
 
-	store, err := OpenStore(*dbPath)
+	terminals := NewTerminalSessions(*terminalGrace)
+
+	proxies, err := ParseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatalf("--trusted-proxies: %v", err)
+	}
+	origins, err := ParseOrigins(*corsOrigins)
 	if err != nil {
-		log.Fatalf("opening database: %v", err)
+		log.Fatalf("--cors-origins: %v", err)
 	}
-	log.Printf("database opened at %s", *dbPath)
 
-	srv := newServer(courses, store)
+	srv := newServer(catalog, store, executor, scheduler, clusters, tenants, terminals, auth, *adminToken, proxies, origins)
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("runner listening on %s", addr)
 	log.Fatal(http.ListenAndServe(addr, srv))
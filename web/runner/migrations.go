@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// A migration is one numbered step of the database schema. Migrations run in
+// order, each in its own transaction, and the versions applied so far are
+// recorded in schema_version. Never edit a migration that has been released:
+// to change the schema, append a new one.
+//
+// Each migration is written for SQLite and, where the SQL differs, again for
+// PostgreSQL. Both versions must leave the same tables and columns behind.
+type migration struct {
+	version  int
+	name     string
+	sql      string
+	postgres string // "" if sql works as is
+}
+
+func (m migration) sqlFor(d dialect) string {
+	if d == dialectPostgres && m.postgres != "" {
+		return m.postgres
+	}
+	return m.sql
+}
+
+var migrations = []migration{
+	// The schema as it was before versioned migrations existed. Every
+	// migration only creates what is missing, so databases created back then,
+	// by any version, are adopted as they stand.
+	{1, "users and completions", `
+		CREATE TABLE IF NOT EXISTS users (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			created_at TEXT NOT NULL DEFAULT (datetime('now'))
+		);
+		CREATE TABLE IF NOT EXISTS completions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			points INTEGER NOT NULL,
+			viewed_solution INTEGER NOT NULL DEFAULT 0,
+			completed_at TEXT NOT NULL DEFAULT (datetime('now')),
+			UNIQUE(user_id, course_id, lesson_slug)
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS users (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			created_at TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS completions (
+			id BIGSERIAL PRIMARY KEY,
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			points INTEGER NOT NULL,
+			viewed_solution INTEGER NOT NULL DEFAULT 0,
+			completed_at TEXT NOT NULL,
+			UNIQUE(user_id, course_id, lesson_slug)
+		);
+	`},
+	{2, "solution views", `
+		CREATE TABLE IF NOT EXISTS solution_views (
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			viewed_at TEXT NOT NULL DEFAULT (datetime('now')),
+			PRIMARY KEY(user_id, course_id, lesson_slug)
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS solution_views (
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			viewed_at TEXT NOT NULL,
+			PRIMARY KEY(user_id, course_id, lesson_slug)
+		);
+	`},
+	{3, "snapshots of passing code", `
+		CREATE TABLE IF NOT EXISTS snapshots (
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			code TEXT NOT NULL,
+			saved_at TEXT NOT NULL DEFAULT (datetime('now')),
+			PRIMARY KEY(user_id, course_id, lesson_slug)
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS snapshots (
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			code TEXT NOT NULL,
+			saved_at TEXT NOT NULL,
+			PRIMARY KEY(user_id, course_id, lesson_slug)
+		);
+	`},
+	{4, "drafts", `
+		CREATE TABLE IF NOT EXISTS drafts (
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			revision INTEGER NOT NULL,
+			code TEXT NOT NULL,
+			saved_at TEXT NOT NULL DEFAULT (datetime('now')),
+			PRIMARY KEY(user_id, course_id, lesson_slug, revision)
+		);
+	`, `
+		CREATE TABLE IF NOT EXISTS drafts (
+			user_id TEXT NOT NULL REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			revision INTEGER NOT NULL,
+			code TEXT NOT NULL,
+			saved_at TEXT NOT NULL,
+			PRIMARY KEY(user_id, course_id, lesson_slug, revision)
+		);
+	`},
+	{5, "sessions and passwords", `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL UNIQUE,
+			user_id TEXT NOT NULL REFERENCES users(id),
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			expires_at TEXT NOT NULL,
+			revoked_at TEXT
+		);
+		CREATE INDEX IF NOT EXISTS sessions_user ON sessions(user_id);
+		CREATE TABLE IF NOT EXISTS credentials (
+			user_id TEXT PRIMARY KEY REFERENCES users(id),
+			password_hash TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);
+	`, ""},
+	{6, "OpenID Connect identities", `
+		CREATE TABLE IF NOT EXISTS identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id TEXT NOT NULL REFERENCES users(id),
+			email TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			PRIMARY KEY(issuer, subject)
+		);
+	`, ""},
+	{7, "groups", `
+		CREATE TABLE IF NOT EXISTS groups (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			course_id TEXT NOT NULL DEFAULT '',
+			join_code TEXT NOT NULL UNIQUE,
+			created_by TEXT NOT NULL REFERENCES users(id),
+			created_at TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS group_members (
+			group_id TEXT NOT NULL REFERENCES groups(id),
+			user_id TEXT NOT NULL REFERENCES users(id),
+			role TEXT NOT NULL,
+			joined_at TEXT NOT NULL,
+			PRIMARY KEY(group_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS group_members_user ON group_members(user_id);
+	`, ""},
+	{8, "test runs", `
+		CREATE TABLE IF NOT EXISTS runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			exit_code INTEGER NOT NULL,
+			duration_ms INTEGER NOT NULL,
+			passed INTEGER NOT NULL DEFAULT 0,
+			failed INTEGER NOT NULL DEFAULT 0,
+			skipped INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			output BLOB NOT NULL,
+			output_size INTEGER NOT NULL,
+			started_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS runs_user ON runs(user_id, started_at);
+		CREATE INDEX IF NOT EXISTS runs_lesson ON runs(course_id, lesson_slug);
+	`, `
+		CREATE TABLE IF NOT EXISTS runs (
+			id BIGSERIAL PRIMARY KEY,
+			user_id TEXT REFERENCES users(id),
+			course_id TEXT NOT NULL,
+			lesson_slug TEXT NOT NULL,
+			exit_code INTEGER NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			passed INTEGER NOT NULL DEFAULT 0,
+			failed INTEGER NOT NULL DEFAULT 0,
+			skipped INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			output BYTEA NOT NULL,
+			output_size INTEGER NOT NULL,
+			started_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS runs_user ON runs(user_id, started_at);
+		CREATE INDEX IF NOT EXISTS runs_lesson ON runs(course_id, lesson_slug);
+	`},
+}
+
+// migrationLockID identifies the PostgreSQL advisory lock taken while
+// migrating.
+const migrationLockID = 0x76742d6d6967 // "vt-mig"
+
+// schemaVersion is the newest schema this binary knows about.
+func schemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the database schema up to date. It refuses to touch a
+// database that a newer binary has already migrated further.
+func (s *sqlStore) migrate() error {
+	if _, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+
+	current, err := s.currentSchemaVersion(s.db)
+	if err != nil {
+		return err
+	}
+	if current > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this runner supports (%d); upgrade the runner", current, schemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Another runner sharing the database may have got here first. On
+	// PostgreSQL, hold a lock until commit so runners migrate one at a time;
+	// SQLite serializes writers on its own.
+	if s.db.dialect == dialectPostgres {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID); err != nil {
+			return err
+		}
+	}
+	current, err := s.currentSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if m.version <= current {
+		return nil
+	}
+
+	if _, err := tx.Exec(m.sqlFor(s.db.dialect)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version, m.name, time.Now().UTC().Format(timeFormat),
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("applied database migration %d: %s", m.version, m.name)
+	return nil
+}
+
+func (s *sqlStore) currentSchemaVersion(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (int, error) {
+	var version int
+	err := q.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mock identity provider settings. The mock is served by the runner itself
+// under mockIdPPath.
+const (
+	mockIdPPath         = "/api/auth/mock-idp"
+	mockIdPClientID     = "vibe-train"
+	mockIdPClientSecret = "mock-secret"
+)
+
+// MockIdP is a minimal OpenID Connect provider for development and
+// integration testing. Its authorize page lets you sign in as any subject.
+type MockIdP struct {
+	issuer string
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu    sync.Mutex
+	codes map[string]mockAuthCode
+}
+
+type mockAuthCode struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      map[string]string // sub, preferred_username, name, email
+	expires     time.Time
+}
+
+// NewMockIdP returns a mock provider whose issuer is the URL the runner
+// reaches its Handler at, usually the runner's own address plus
+// mockIdPPath. Browsers are sent to mockIdPPath on whatever host they use.
+func NewMockIdP(issuer string) (*MockIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &MockIdP{issuer: issuer, key: key, kid: randomToken(8), codes: make(map[string]mockAuthCode)}, nil
+}
+
+// Provider returns an OIDCProvider that signs in through the mock.
+func (m *MockIdP) Provider() *OIDCProvider {
+	p, _ := NewOIDCProvider(OIDCConfig{
+		Name:         "Mock IdP",
+		Issuer:       m.issuer,
+		ClientID:     mockIdPClientID,
+		ClientSecret: mockIdPClientSecret,
+	}, nil)
+	return p
+}
+
+// Handler serves the provider's endpoints, relative to its root.
+func (m *MockIdP) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 m.issuer,
+			"authorization_endpoint": mockIdPPath + "/authorize",
+			"token_endpoint":         m.issuer + "/token",
+			"jwks_uri":               m.issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", m.handleJWKS)
+	mux.HandleFunc("GET /authorize", m.handleAuthorizeForm)
+	mux.HandleFunc("POST /authorize", m.handleAuthorize)
+	mux.HandleFunc("POST /token", m.handleToken)
+	return mux
+}
+
+func (m *MockIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": m.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var mockAuthorizeForm = template.Must(template.New("authorize").Parse(`<!doctype html>
+<title>Mock IdP</title>
+<style>body{font-family:sans-serif;max-width:24rem;margin:4rem auto}label{display:block;margin:.5rem 0}input{width:100%}</style>
+<h1>Mock IdP</h1>
+<p>Sign in as any user. For development only.</p>
+<form method="post">
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}<label>Subject <input name="sub" value="alice" required></label>
+<label>Username <input name="preferred_username" value="alice"></label>
+<label>Name <input name="name" value="Alice Example"></label>
+<label>Email <input name="email" value="alice@example.com"></label>
+<button type="submit">Sign in</button>
+</form>
+`))
+
+func (m *MockIdP) handleAuthorizeForm(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != mockIdPClientID || q.Get("response_type") != "code" {
+		http.Error(w, "unknown client or unsupported response type", http.StatusBadRequest)
+		return
+	}
+	params := make(map[string]string)
+	for _, k := range []string{"redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		params[k] = q.Get(k)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mockAuthorizeForm.Execute(w, map[string]any{"Params": params})
+}
+
+func (m *MockIdP) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("sub") == "" {
+		http.Error(w, "subject is required", http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(r.PostForm.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomToken(24)
+	claims := make(map[string]string)
+	for _, k := range []string{"sub", "preferred_username", "name", "email"} {
+		if v := r.PostForm.Get(k); v != "" {
+			claims[k] = v
+		}
+	}
+	m.mu.Lock()
+	m.codes[code] = mockAuthCode{
+		redirectURI: redirect.String(),
+		challenge:   r.PostForm.Get("code_challenge"),
+		nonce:       r.PostForm.Get("nonce"),
+		claims:      claims,
+		expires:     time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", r.PostForm.Get("state"))
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *MockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if !ok || id != mockIdPClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(mockIdPClientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code")) // codes are single use
+	m.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || time.Now().After(code.expires) ||
+		code.redirectURI != r.PostForm.Get("redirect_uri") ||
+		code.challenge != base64.RawURLEncoding.EncodeToString(verifier[:]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   m.issuer,
+		"aud":   mockIdPClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": code.nonce,
+	}
+	for k, v := range code.claims {
+		claims[k] = v
+	}
+	idToken, err := m.sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomToken(24),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns claims as an RS256-signed JWT.
+func (m *MockIdP) sign(claims map[string]any) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": m.kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{signing, base64.RawURLEncoding.EncodeToString(sig)}, "."), nil
+}
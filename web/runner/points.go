@@ -9,7 +9,8 @@ var difficultyPoints = map[string]int{
 }
 
 // CalcLessonPoints returns points for completing a lesson.
-// Returns 0 if viewedSolution is true.
+// Returns 0 if viewedSolution is true; callers take it from the solution
+// views recorded in the Store, never from the client.
 // Returns 1.5x if hasSolution is false (hard mode — no solution available).
 func CalcLessonPoints(difficulty string, viewedSolution bool, hasSolution bool) int {
 	if viewedSolution {
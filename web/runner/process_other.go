@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// killProcessGroupOnCancel leaves cmd alone: process groups are a Unix
+// thing, so only cmd itself is killed on cancel.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {}
+
+func attachTerminal(cmd *exec.Cmd, tty *os.File) {
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+}
+
+func hangUpShell(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+	cmd.Wait()
+}
@@ -0,0 +1,68 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessGroupOnCancel starts cmd in a process group of its own and
+// makes canceling its context kill the whole group, not just cmd: test
+// binaries, node workers and the like that the command started go with it.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		killProcessGroup(cmd)
+		return nil
+	}
+}
+
+// killProcessGroup kills what is left of the process group of a command
+// started with killProcessGroupOnCancel.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// attachTerminal makes tty cmd's stdio and controlling terminal, in a
+// session of its own, so job control and hangups work as in a login.
+func attachTerminal(cmd *exec.Cmd, tty *os.File) {
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 // stdin, in the child
+}
+
+// shellHangupGrace is how long an interactive shell gets to pass a hangup on
+// to its jobs before it is killed.
+const shellHangupGrace = time.Second
+
+// hangUpShell ends an interactive shell started on a pty and waits for it.
+// On hangup bash sends SIGHUP to every job, including background ones in
+// their own process groups; whatever outlives the grace period in the
+// shell's own group is killed.
+func hangUpShell(cmd *exec.Cmd) {
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	cmd.Process.Signal(syscall.SIGHUP)
+	select {
+	case <-done:
+	case <-time.After(shellHangupGrace):
+		cmd.Process.Kill()
+		<-done
+	}
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewClusterProvisioner returns the provisioner with the given name: "k3d"
+// or "fake".
+func NewClusterProvisioner(name string) (ClusterProvisioner, error) {
+	switch name {
+	case "k3d":
+		return &K3dProvisioner{}, nil
+	case "fake":
+		return &FakeProvisioner{Delay: 2 * time.Second}, nil
+	default:
+		return nil, fmt.Errorf("unknown cluster provisioner %q", name)
+	}
+}
+
+// K3dProvisioner brings clusters up with the course's shared/setup.sh, which
+// creates or reuses a k3d cluster and merges its kubeconfig into the
+// runner's. Health checks ask the API server's /readyz.
+//
+// Every kubernetes course gets the same cluster, the one setup.sh names, so
+// the provisioner counts the courses that have it up. Tearing one down only
+// deletes the cluster once no other course holds it, or if it is broken for
+// all of them.
+type K3dProvisioner struct {
+	mu      sync.Mutex          // serializes setup.sh and deletes
+	courses map[string]struct{} // IDs of the courses holding the cluster
+}
+
+func (p *K3dProvisioner) Provision(ctx context.Context, course *Course) error {
+	script := filepath.Join(course.Path, "shared", "setup.sh")
+	if _, err := os.Stat(script); err != nil {
+		return fmt.Errorf("no setup script: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Even a failed setup may have left the cluster up; the teardown that
+	// follows the failure lets go of it.
+	if p.courses == nil {
+		p.courses = make(map[string]struct{})
+	}
+	p.courses[course.ID] = struct{}{}
+
+	cmd := exec.CommandContext(ctx, "bash", script)
+	cmd.Dir = course.Path
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	cmd.WaitDelay = runWaitDelay
+	killProcessGroupOnCancel(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("setup.sh: %w", err)
+	}
+	return nil
+}
+
+// Check checks the shared cluster, whichever course asks.
+func (p *K3dProvisioner) Check(ctx context.Context, course *Course) error {
+	out, err := kubectl(ctx, nil, "get", "--raw", "/readyz")
+	if err != nil {
+		return err
+	}
+	if s := strings.TrimSpace(string(out)); s != "ok" {
+		return fmt.Errorf("API server not ready: %s", s)
+	}
+	return nil
+}
+
+// Teardown lets go of the cluster for course. It deletes the cluster, named
+// by $K3D_CLUSTER_NAME, the variable setup.sh reads, or vibe-train, unless
+// other courses still hold it and it passes a health check.
+func (p *K3dProvisioner) Teardown(ctx context.Context, course *Course) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.courses, course.ID)
+	if len(p.courses) > 0 && p.Check(ctx, course) == nil {
+		log.Printf("keeping the k3d cluster for %d other course(s)", len(p.courses))
+		return nil
+	}
+
+	name := os.Getenv("K3D_CLUSTER_NAME")
+	if name == "" {
+		name = "vibe-train"
+	}
+	out, err := exec.CommandContext(ctx, "k3d", "cluster", "delete", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("k3d cluster delete: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// FakeProvisioner pretends to run clusters, for working on the runner
+// without Docker (--clusters fake) and for exercising the cluster manager.
+// Provisioning takes Delay. Fail breaks a course's cluster.
+type FakeProvisioner struct {
+	Delay time.Duration
+
+	mu         sync.Mutex
+	failures   map[string]error // by course ID
+	provisions map[string]int
+	teardowns  map[string]int
+}
+
+// Fail makes the course's cluster fail health checks and provisioning with
+// err until Fail is called again with a nil error.
+func (p *FakeProvisioner) Fail(courseID string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures == nil {
+		p.failures = make(map[string]error)
+	}
+	if err == nil {
+		delete(p.failures, courseID)
+	} else {
+		p.failures[courseID] = err
+	}
+}
+
+// Provisions returns how many times the course's cluster was provisioned.
+func (p *FakeProvisioner) Provisions(courseID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.provisions[courseID]
+}
+
+// Teardowns returns how many times the course's cluster was deleted.
+func (p *FakeProvisioner) Teardowns(courseID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.teardowns[courseID]
+}
+
+func (p *FakeProvisioner) Provision(ctx context.Context, course *Course) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(p.Delay):
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provisions == nil {
+		p.provisions = make(map[string]int)
+	}
+	p.provisions[course.ID]++
+	return p.failures[course.ID]
+}
+
+func (p *FakeProvisioner) Check(ctx context.Context, course *Course) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failures[course.ID]
+}
+
+func (p *FakeProvisioner) Teardown(ctx context.Context, course *Course) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.teardowns == nil {
+		p.teardowns = make(map[string]int)
+	}
+	p.teardowns[course.ID]++
+	return ctx.Err()
+}
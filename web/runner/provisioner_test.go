@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeK3d puts stand-ins for k3d and kubectl first on PATH. The cluster is
+// healthy until the test calls the returned break function, and k3d
+// calls are logged to the returned file.
+func fakeK3d(t *testing.T) (calls string, breakCluster func()) {
+	t.Helper()
+	bin := t.TempDir()
+	calls = filepath.Join(bin, "k3d.log")
+	broken := filepath.Join(bin, "broken")
+	scripts := map[string]string{
+		"k3d":     "#!/bin/sh\necho \"$*\" >> " + shellQuote(calls) + "\n",
+		"kubectl": "#!/bin/sh\n[ -e " + shellQuote(broken) + " ] && exit 1\necho ok\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("K3D_CLUSTER_NAME", "")
+	return calls, func() {
+		if err := os.WriteFile(broken, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// k3dCourse returns a kubernetes course whose setup.sh does nothing.
+func k3dCourse(t *testing.T, id string) *Course {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "shared", "setup.sh"), []byte("exit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &Course{ID: id, Language: "kubernetes", Path: dir}
+}
+
+// k3dCalls returns the k3d command lines logged so far.
+func k3dCalls(t *testing.T, log string) []string {
+	t.Helper()
+	b, err := os.ReadFile(log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestK3dProvisionerSharesCluster(t *testing.T) {
+	deletes, _ := fakeK3d(t)
+	ctx := context.Background()
+	p := &K3dProvisioner{}
+	a, b := k3dCourse(t, "a"), k3dCourse(t, "b")
+	for _, c := range []*Course{a, b} {
+		if err := p.Provision(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Teardown(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := k3dCalls(t, deletes); got != nil {
+		t.Fatalf("tearing down one of two courses ran k3d %v", got)
+	}
+	if err := p.Teardown(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := k3dCalls(t, deletes), []string{"cluster delete vibe-train"}; !slices.Equal(got, want) {
+		t.Errorf("tearing down the last course ran k3d %q, want %q", got, want)
+	}
+}
+
+func TestK3dProvisionerDeletesBrokenCluster(t *testing.T) {
+	deletes, breakCluster := fakeK3d(t)
+	ctx := context.Background()
+	p := &K3dProvisioner{}
+	a, b := k3dCourse(t, "a"), k3dCourse(t, "b")
+	for _, c := range []*Course{a, b} {
+		if err := p.Provision(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	breakCluster()
+	if err := p.Teardown(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := k3dCalls(t, deletes); len(got) == 0 {
+		t.Error("a broken cluster was kept for the other course")
+	}
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxInitArg is the hidden first argument the runner re-executes itself
+// with to set up the sandbox from inside the new namespaces.
+const sandboxInitArg = "__sandbox-init"
+
+// SandboxExecutor runs each command in fresh mount, PID, IPC, UTS and (unless
+// the course allows network access) network namespaces. The root filesystem
+// is read-only, /tmp is private, only the workspace is writable, and the
+// command runs without capabilities. CPU, memory and PID caps come from a
+// per-run cgroup when cgroup v2 is available, otherwise from rlimits.
+type SandboxExecutor struct {
+	self    string
+	opts    SandboxOptions
+	cgroups bool
+}
+
+// sandboxInitConfig is passed to the re-executed init process over a pipe.
+type sandboxInitConfig struct {
+	Command     string   `json:"command"`
+	Dir         string   `json:"dir"`
+	Env         []string `json:"env"`
+	WorkDir     string   `json:"work_dir"`
+	Network     bool     `json:"network"`
+	ReadWrite   []string `json:"read_write"`
+	ReadOnly    []string `json:"read_only"`
+	Hide        []string `json:"hide"`
+	CacheDir    string   `json:"cache_dir"`
+	CacheTarget string   `json:"cache_target"`
+	MemoryBytes uint64   `json:"memory_bytes"` // RLIMIT_DATA, only without cgroups
+	CPUSeconds  uint64   `json:"cpu_seconds"`
+}
+
+// NewSandboxExecutor checks that namespaces can be created and returns an
+// executor. It fails if the runner lacks the privileges to sandbox runs.
+func NewSandboxExecutor(opts SandboxOptions) (Executor, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locating runner binary: %w", err)
+	}
+	e := &SandboxExecutor{self: self, opts: opts}
+
+	if opts.CgroupDir != "" {
+		if err := setupCgroupRoot(opts.CgroupDir); err != nil {
+			log.Printf("sandbox: cgroup limits unavailable, falling back to rlimits: %v", err)
+		} else {
+			e.cgroups = true
+		}
+	}
+
+	probeDir, err := os.MkdirTemp("", "vibe-probe-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(probeDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var out strings.Builder
+	code, err := e.Run(ctx, &RunSpec{Command: "true", Dir: probeDir, WorkDir: probeDir}, &out, &out)
+	if err != nil || code != 0 {
+		return nil, fmt.Errorf("sandbox unavailable (exit %d, %v): %s", code, err, strings.TrimSpace(out.String()))
+	}
+	return e, nil
+}
+
+func (e *SandboxExecutor) Run(ctx context.Context, spec *RunSpec, stdout, stderr io.Writer) (int, error) {
+	// The init process execs the command as PID 1 of the new PID namespace,
+	// so killing it on cancel takes down everything the command started.
+	// With cgroups, the whole cgroup is killed at once.
+	cmd := exec.CommandContext(ctx, e.self, sandboxInitArg)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cleanup, err := e.start(cmd, spec)
+	if err != nil {
+		return -1, err
+	}
+	defer cleanup()
+	return exitStatus(ctx, cmd.Wait())
+}
+
+func (e *SandboxExecutor) Start(spec *RunSpec, tty *os.File) (func(), error) {
+	cmd := exec.Command(e.self, sandboxInitArg)
+	attachTerminal(cmd, tty)
+	cleanup, err := e.start(cmd, spec)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		hangUpShell(cmd)
+		cleanup()
+	}, nil
+}
+
+// start starts cmd, the runner re-executed as sandbox init, in a sandbox
+// for spec. cleanup removes the run's cgroup once cmd has exited.
+func (e *SandboxExecutor) start(cmd *exec.Cmd, spec *RunSpec) (cleanup func(), err error) {
+	sc := spec.Sandbox.withDefaults()
+	home := os.Getenv("HOME")
+
+	cfg := sandboxInitConfig{
+		Command: spec.Command,
+		Dir:     spec.Dir,
+		Env:     append(sandboxEnv(os.Environ()), spec.Env...),
+		WorkDir: spec.WorkDir,
+		Network: sc.Network,
+	}
+	for _, p := range sc.ReadWrite {
+		if p == "~" || strings.HasPrefix(p, "~/") {
+			p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+		if !withinAny(p, e.opts.Hide) {
+			cfg.ReadWrite = append(cfg.ReadWrite, p)
+		}
+	}
+	// Solutions are looked up on each run, so lessons added by a course
+	// reload are covered too.
+	cfg.Hide = append(append([]string(nil), e.opts.Hide...), SolutionDirs(e.opts.Courses)...)
+	cfg.ReadOnly = append(externalLinkTargets(spec.WorkDir), spec.ReadOnly...)
+
+	// Give each course a persistent, writable ~/.cache (Go build cache,
+	// npm cache, ...) so runs don't start cold every time.
+	if e.opts.CacheDir != "" && spec.CacheKey != "" && home != "" && home != "/" {
+		dir := filepath.Join(e.opts.CacheDir, spec.CacheKey)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("creating sandbox cache: %w", err)
+		}
+		cfg.CacheDir = dir
+		cfg.CacheTarget = filepath.Join(home, ".cache")
+	}
+
+	if spec.Timeout > 0 {
+		cfg.CPUSeconds = uint64(spec.Timeout.Seconds()*sc.CPUs) + 1
+	}
+
+	flags := uintptr(syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if !sc.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	cmd.Env = []string{}
+	cmd.ExtraFiles = []*os.File{r}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags = flags
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	cmd.WaitDelay = runWaitDelay
+
+	cleanup = func() {}
+	if e.cgroups {
+		cg, err := newRunCgroup(e.opts.CgroupDir, sc)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("creating cgroup: %w", err)
+		}
+		cleanup = cg.remove
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cg.fd.Fd())
+		if cmd.Cancel != nil { // a run, canceled through its context
+			cmd.Cancel = func() error {
+				cg.kill()
+				return cmd.Process.Kill()
+			}
+		}
+	} else {
+		cfg.MemoryBytes = uint64(sc.MemoryMB) << 20
+	}
+
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := json.NewEncoder(w).Encode(&cfg); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		cleanup()
+		return nil, fmt.Errorf("sending sandbox config: %w", err)
+	}
+	w.Close()
+	return cleanup, nil
+}
+
+// externalLinkTargets returns the targets of top-level symlinks in dir that
+// point outside it (e.g. the shared node_modules cache), so the sandbox can
+// keep them visible read-only.
+func externalLinkTargets(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var targets []string
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, entry.Name()))
+		if err != nil || strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// withinAny reports whether path is one of dirs or inside one of them.
+func withinAny(path string, dirs []string) bool {
+	path = filepath.Clean(path)
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runCgroup is a cgroup v2 directory holding a single run.
+type runCgroup struct {
+	dir string
+	fd  *os.File
+}
+
+func setupCgroupRoot(dir string) error {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return fmt.Errorf("cgroup v2 not mounted")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Clear out cgroups of runs that were in progress when the runner last
+	// stopped, along with anything still running in them.
+	leftover, _ := filepath.Glob(filepath.Join(dir, "run-*"))
+	for _, d := range leftover {
+		(&runCgroup{dir: d}).remove()
+	}
+	if len(leftover) > 0 {
+		log.Printf("sandbox: removed %d leftover run cgroup(s)", len(leftover))
+	}
+
+	return os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644)
+}
+
+func newRunCgroup(root string, sc SandboxConfig) (*runCgroup, error) {
+	dir, err := os.MkdirTemp(root, "run-")
+	if err != nil {
+		return nil, err
+	}
+	cg := &runCgroup{dir: dir}
+	limits := map[string]string{
+		"cpu.max":    fmt.Sprintf("%d 100000", int(sc.CPUs*100000)),
+		"memory.max": strconv.Itoa(sc.MemoryMB << 20),
+		"pids.max":   strconv.Itoa(sc.PIDs),
+	}
+	for file, value := range limits {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("setting %s: %w", file, err)
+		}
+	}
+	// No swap controller on some hosts; the memory cap still applies.
+	os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+
+	if cg.fd, err = os.Open(dir); err != nil {
+		cg.remove()
+		return nil, err
+	}
+	return cg, nil
+}
+
+// kill kills every process in the cgroup. cgroup.kill needs Linux 5.14; on
+// older kernels the PID namespace teardown has to do.
+func (cg *runCgroup) kill() {
+	os.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0644)
+}
+
+// remove kills anything left in the cgroup and deletes it. The kernel
+// refuses while exiting processes are still attached, so retry briefly.
+func (cg *runCgroup) remove() {
+	if cg.fd != nil {
+		cg.fd.Close()
+	}
+	cg.kill()
+	for i := 0; i < 50; i++ {
+		if err := os.Remove(cg.dir); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	log.Printf("sandbox: could not remove cgroup %s", cg.dir)
+}
+
+// maybeSandboxInit takes over the process when the runner was re-executed as
+// a sandbox init. It never returns in that case.
+func maybeSandboxInit() {
+	if len(os.Args) < 2 || os.Args[1] != sandboxInitArg {
+		return
+	}
+	// Capabilities are per-thread; everything up to exec must happen on the
+	// thread that calls it.
+	runtime.LockOSThread()
+	if err := sandboxInit(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(125)
+	}
+}
+
+func sandboxInit() error {
+	f := os.NewFile(3, "sandbox-config")
+	var cfg sandboxInitConfig
+	err := json.NewDecoder(f).Decode(&cfg)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	os.Clearenv()
+	for _, kv := range cfg.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			os.Setenv(k, v)
+		}
+	}
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		return err
+	}
+
+	if err := setupSandboxMounts(&cfg); err != nil {
+		return err
+	}
+	if !cfg.Network {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("bringing up loopback: %w", err)
+		}
+	}
+	unix.Sethostname([]byte("sandbox"))
+
+	limits := map[int]uint64{
+		unix.RLIMIT_CORE:  0,
+		unix.RLIMIT_FSIZE: 256 << 20,
+	}
+	if cfg.CPUSeconds > 0 {
+		limits[unix.RLIMIT_CPU] = cfg.CPUSeconds
+	}
+	if cfg.MemoryBytes > 0 {
+		limits[unix.RLIMIT_DATA] = cfg.MemoryBytes
+	}
+	for resource, max := range limits {
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: max, Max: max}); err != nil {
+			return fmt.Errorf("setrlimit %d: %w", resource, err)
+		}
+	}
+
+	if err := os.Chdir(cfg.Dir); err != nil {
+		return err
+	}
+	if err := dropCapabilities(); err != nil {
+		return fmt.Errorf("dropping capabilities: %w", err)
+	}
+	return syscall.Exec(bash, []string{"bash", "-c", cfg.Command}, os.Environ())
+}
+
+// setupSandboxMounts makes the filesystem read-only, replaces /tmp with a
+// private tmpfs and re-exposes the workspace and any allowed host paths.
+func setupSandboxMounts(cfg *sandboxInitConfig) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+
+	// Hold on to the paths we need before /tmp is shadowed by the tmpfs.
+	type bind struct {
+		fd       int
+		target   string
+		readOnly bool
+	}
+	var binds []bind
+	hold := func(src, target string, readOnly bool) error {
+		fd, err := unix.Open(src, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", src, err)
+		}
+		binds = append(binds, bind{fd: fd, target: target, readOnly: readOnly})
+		return nil
+	}
+	if err := hold(cfg.WorkDir, cfg.WorkDir, false); err != nil {
+		return err
+	}
+	for _, p := range cfg.ReadOnly {
+		if err := hold(p, p, true); err != nil {
+			return err
+		}
+	}
+	for _, p := range cfg.ReadWrite {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			continue
+		}
+		if err := hold(p, p, false); err != nil {
+			return err
+		}
+	}
+	if cfg.CacheDir != "" {
+		// The target may not exist yet on the host; create it while we can.
+		if err := os.MkdirAll(cfg.CacheTarget, 0755); err != nil {
+			return err
+		}
+		if err := hold(cfg.CacheDir, cfg.CacheTarget, false); err != nil {
+			return err
+		}
+	}
+
+	if err := remountAllReadOnly(); err != nil {
+		return err
+	}
+
+	for _, dir := range []string{"/tmp", "/dev/shm"} {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777,size=256m"); err != nil {
+			return fmt.Errorf("mounting tmpfs on %s: %w", dir, err)
+		}
+	}
+
+	// Hidden directories stay writable until the binds below have made
+	// their mountpoints, which may be inside them (a run's own kubeconfig).
+	var hiddenDirs []string
+	for _, p := range cfg.Hide {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			err = unix.Mount("tmpfs", p, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=0755,size=4k")
+			hiddenDirs = append(hiddenDirs, p)
+		} else {
+			err = unix.Mount("/dev/null", p, "", unix.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("hiding %s: %w", p, err)
+		}
+	}
+
+	for _, b := range binds {
+		if err := ensureMountpoint(b.fd, b.target); err != nil {
+			return err
+		}
+		src := fmt.Sprintf("/proc/self/fd/%d", b.fd)
+		if err := unix.Mount(src, b.target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("binding %s: %w", b.target, err)
+		}
+		// Bind mounts inherit the read-only flag of their source mount, so
+		// set the mode explicitly.
+		flags := uintptr(unix.MS_REMOUNT | unix.MS_BIND | unix.MS_NOSUID | unix.MS_NODEV)
+		if b.readOnly {
+			flags |= unix.MS_RDONLY
+		}
+		if err := unix.Mount("", b.target, "", flags, ""); err != nil {
+			return fmt.Errorf("remounting %s: %w", b.target, err)
+		}
+		unix.Close(b.fd)
+	}
+	for _, p := range hiddenDirs {
+		if err := unix.Mount("", p, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+			return fmt.Errorf("remounting %s: %w", p, err)
+		}
+	}
+
+	if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mounting /proc: %w", err)
+	}
+	return nil
+}
+
+// ensureMountpoint creates target (a directory or an empty file, matching
+// the held source) if it doesn't exist inside the new tmpfs.
+func ensureMountpoint(fd int, target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		return nil
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(target, nil, 0644)
+}
+
+// remountAllReadOnly sets every mount in the namespace read-only, keeping
+// its nosuid/nodev/noexec flags.
+func remountAllReadOnly() error {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		target := unescapeMountPath(fields[4])
+		flags := uintptr(unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY)
+		for _, opt := range strings.Split(fields[5], ",") {
+			switch opt {
+			case "nosuid":
+				flags |= unix.MS_NOSUID
+			case "nodev":
+				flags |= unix.MS_NODEV
+			case "noexec":
+				flags |= unix.MS_NOEXEC
+			}
+		}
+		err := unix.Mount("", target, "", flags, "")
+		if err != nil && target == "/" {
+			return fmt.Errorf("remounting / read-only: %w", err)
+		}
+		// /proc and /sys have submounts the kernel won't let us touch; the
+		// fresh /proc below replaces the former and the latter is read-only
+		// in the container already.
+		if err != nil && !strings.HasPrefix(target, "/proc") && !strings.HasPrefix(target, "/sys") {
+			return fmt.Errorf("remounting %s read-only: %w", target, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (\040 etc.) used in mountinfo.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// dropCapabilities clears every capability from the calling thread and its
+// bounding set, so the exec'd command can't undo the mounts even as uid 0.
+func dropCapabilities() error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	for c := 0; c <= unix.CAP_LAST_CAP; c++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return err
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return err
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	return unix.Capset(&hdr, &data[0])
+}
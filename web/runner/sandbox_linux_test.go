@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSandboxHidesSolutions(t *testing.T) {
+	courses, err := filepath.Abs("../../courses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewSandboxExecutor(SandboxOptions{Courses: courses})
+	if err != nil {
+		t.Skipf("sandbox unavailable: %v", err)
+	}
+	lesson := filepath.Join(courses, "go", "api", "lessons", "01-hello-http")
+	work := t.TempDir()
+
+	// A listing or file that comes out empty counts as unreadable.
+	tests := []struct {
+		command  string
+		readable bool
+	}{
+		{"ls -A " + shellQuote(filepath.Join(lesson, "tests")), true},
+		{"cat " + shellQuote(filepath.Join(lesson, "starter", "main.go")), true},
+		{"ls -A " + shellQuote(filepath.Join(lesson, "solution")), false},
+		{"cat " + shellQuote(filepath.Join(lesson, "solution", "main.go")), false},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		spec := &RunSpec{Command: tt.command, Dir: work, WorkDir: work}
+		code, err := e.Run(context.Background(), spec, &out, &out)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.command, err)
+		}
+		readable := code == 0 && strings.TrimSpace(out.String()) != ""
+		if readable != tt.readable {
+			t.Errorf("%s: readable = %v, want %v; output:\n%s", tt.command, readable, tt.readable, out.String())
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// NewSandboxExecutor is only implemented on Linux.
+func NewSandboxExecutor(opts SandboxOptions) (Executor, error) {
+	return nil, fmt.Errorf("sandbox executor requires Linux; use --executor=local")
+}
+
+func maybeSandboxInit() {}
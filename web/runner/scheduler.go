@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Scheduler limits how many runs execute at once, overall and per user.
+// Runs over either limit wait in a queue. When a slot frees up it goes to
+// the longest-waiting run of the user with the fewest runs in progress, so
+// one student pressing Run over and over can't starve the rest of a class.
+type Scheduler struct {
+	maxRuns    int
+	maxPerUser int
+
+	mu      sync.Mutex
+	running int
+	perUser map[string]int // runs in progress by user key
+	queue   []*runTicket   // in arrival order
+}
+
+// runTicket is a run waiting in the queue.
+type runTicket struct {
+	user     string
+	ready    chan struct{} // closed when the run may start
+	position chan int      // latest queue position, 1-based
+	sent     int           // last position put on the channel
+}
+
+// NewScheduler returns a scheduler allowing maxRuns runs at once, at most
+// maxPerUser of them by the same user.
+func NewScheduler(maxRuns, maxPerUser int) *Scheduler {
+	if maxRuns < 1 {
+		maxRuns = 1
+	}
+	if maxPerUser < 1 || maxPerUser > maxRuns {
+		maxPerUser = maxRuns
+	}
+	return &Scheduler{maxRuns: maxRuns, maxPerUser: maxPerUser, perUser: make(map[string]int)}
+}
+
+// Acquire waits until user may start a run and returns the function that
+// ends it. While the run is queued, queued is called with its position in
+// the queue every time that changes. If ctx ends first, the run leaves the
+// queue and Acquire returns ctx's error.
+func (s *Scheduler) Acquire(ctx context.Context, user string, queued func(position int)) (release func(), err error) {
+	s.mu.Lock()
+	if len(s.queue) == 0 && s.canStart(user) {
+		s.start(user)
+		s.mu.Unlock()
+		return s.releaser(user), nil
+	}
+	t := &runTicket{user: user, ready: make(chan struct{}), position: make(chan int, 1)}
+	s.queue = append(s.queue, t)
+	s.dispatch()
+	s.mu.Unlock()
+
+	for {
+		select {
+		case <-t.ready:
+			return s.releaser(user), nil
+		case pos := <-t.position:
+			queued(pos)
+		case <-ctx.Done():
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			select {
+			case <-t.ready:
+				// Started just now; hand the slot back.
+				s.finish(user)
+			default:
+				s.remove(t)
+			}
+			s.dispatch()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stats returns how many runs are in progress and how many are queued.
+func (s *Scheduler) Stats() (running, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, len(s.queue)
+}
+
+func (s *Scheduler) releaser(user string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.finish(user)
+			s.dispatch()
+		})
+	}
+}
+
+func (s *Scheduler) canStart(user string) bool {
+	return s.running < s.maxRuns && s.perUser[user] < s.maxPerUser
+}
+
+func (s *Scheduler) start(user string) {
+	s.running++
+	s.perUser[user]++
+}
+
+func (s *Scheduler) finish(user string) {
+	s.running--
+	if s.perUser[user]--; s.perUser[user] <= 0 {
+		delete(s.perUser, user)
+	}
+}
+
+func (s *Scheduler) remove(t *runTicket) {
+	for i, q := range s.queue {
+		if q == t {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch starts queued runs while there are free slots, then tells the
+// runs still waiting where they are in the queue. Callers hold s.mu.
+func (s *Scheduler) dispatch() {
+	for s.running < s.maxRuns {
+		next := -1
+		for i, t := range s.queue {
+			if !s.canStart(t.user) {
+				continue
+			}
+			if next < 0 || s.perUser[t.user] < s.perUser[s.queue[next].user] {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		t := s.queue[next]
+		s.queue = append(s.queue[:next], s.queue[next+1:]...)
+		s.start(t.user)
+		close(t.ready)
+	}
+
+	for i, t := range s.queue {
+		if t.sent == i+1 {
+			continue
+		}
+		// Keep only the latest position for a waiter that hasn't caught up.
+		select {
+		case <-t.position:
+		default:
+		}
+		t.position <- i + 1
+		t.sent = i + 1
+	}
+}
@@ -1,48 +1,92 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
-func newServer(courses []*Course, store *Store) http.Handler {
+func newServer(catalog *Catalog, store Store, executor Executor, scheduler *Scheduler, clusters *ClusterManager, tenants *KubeTenants, terminals *TerminalSessions, auth AuthConfig, adminToken string, proxies TrustedProxies, corsOrigins []string) http.Handler {
 	mux := http.NewServeMux()
 
-	// Build course index
-	courseIndex := make(map[string]*Course)
-	for _, c := range courses {
-		courseIndex[c.ID] = c
-	}
+	// Health
+	mux.HandleFunc("GET /healthz", handleHealthz())
+	mux.HandleFunc("GET /readyz", handleReadyz(catalog, store, clusters))
+	mux.HandleFunc("GET /api/status", handleStatus(catalog, clusters, scheduler))
 
 	// REST endpoints
-	mux.HandleFunc("GET /api/courses", handleListCourses(courses, store))
-	mux.HandleFunc("GET /api/courses/{id}", handleGetCourse(courseIndex, store))
-	mux.HandleFunc("GET /api/courses/{id}/lessons/{slug}", handleGetLesson(courseIndex))
+	mux.HandleFunc("GET /api/courses", handleListCourses(catalog, store))
+	mux.HandleFunc("GET /api/courses/{id}", handleGetCourse(catalog, store))
+	mux.HandleFunc("GET /api/courses/{id}/lessons/{slug}", handleGetLesson(catalog, store))
+	mux.HandleFunc("GET /api/courses/{id}/lessons/{slug}/solution", handleGetSolution(catalog, store))
+	mux.HandleFunc("GET /api/courses/{id}/lessons/{slug}/draft", handleGetDraft(catalog, store))
+	mux.HandleFunc("PUT /api/courses/{id}/lessons/{slug}/draft", handleSaveDraft(catalog, store))
 
 	// Auth endpoints
-	mux.HandleFunc("POST /api/users", handleCreateUser(store))
-	mux.HandleFunc("GET /api/users/me", handleGetMe(store))
+	mux.HandleFunc("POST /api/users", handleCreateUser(store, auth))
+	mux.HandleFunc("GET /api/users/me", handleGetMe(store, auth))
+	mux.HandleFunc("PUT /api/users/me/password", handleSetPassword(store))
 	mux.HandleFunc("GET /api/users/me/progress", handleGetMyProgress(store))
+	mux.HandleFunc("GET /api/users/me/runs", handleListMyRuns(store))
+	mux.HandleFunc("GET /api/users/me/runs/{runID}", handleGetMyRun(store))
+	mux.HandleFunc("POST /api/auth/login", handleLogin(store, auth))
+	mux.HandleFunc("GET /api/auth/providers", handleAuthProviders(auth))
+	mux.HandleFunc("GET /api/auth/login", handleOIDCLogin(auth))
+	mux.HandleFunc("GET /api/auth/callback", handleOIDCCallback(store, auth))
+	mux.HandleFunc("POST /api/auth/logout", handleLogout(store))
+	mux.HandleFunc("GET /api/auth/sessions", handleListSessions(store))
+	mux.HandleFunc("DELETE /api/auth/sessions/{sessionID}", handleRevokeSession(store))
+
+	if auth.MockIdP != nil {
+		mux.Handle(mockIdPPath+"/", http.StripPrefix(mockIdPPath, auth.MockIdP.Handler()))
+	}
 
 	// Leaderboard
-	mux.HandleFunc("GET /api/leaderboard", handleGetLeaderboard(store))
+	mux.HandleFunc("GET /api/leaderboard", handleGetLeaderboard(catalog, store))
+
+	// Groups
+	mux.HandleFunc("POST /api/groups", handleCreateGroup(catalog, store))
+	mux.HandleFunc("GET /api/groups", handleListGroups(store))
+	mux.HandleFunc("POST /api/groups/join", handleJoinGroup(store))
+	mux.HandleFunc("GET /api/groups/{id}", handleGetGroup(store))
+	mux.HandleFunc("POST /api/groups/{id}/code", handleRotateJoinCode(store))
+	mux.HandleFunc("PUT /api/groups/{id}/members/{userID}", handleUpdateGroupMember(store))
+	mux.HandleFunc("DELETE /api/groups/{id}/members/{userID}", handleRemoveGroupMember(store))
+	mux.HandleFunc("GET /api/groups/{id}/leaderboard", handleGroupLeaderboard(catalog, store))
+	mux.HandleFunc("GET /api/groups/{id}/dashboard", handleGroupDashboard(catalog, store))
+
+	// Admin
+	mux.HandleFunc("POST /api/admin/reload", requireAdmin(adminToken, handleReloadCourses(catalog)))
+	mux.HandleFunc("GET /api/admin/courses/{id}/analytics", requireAdmin(adminToken, handleCourseAnalytics(catalog, store)))
 
 	// WebSocket endpoints
-	mux.HandleFunc("/api/run", handleRun(courseIndex, store))
-	mux.HandleFunc("/api/terminal", handleTerminal(courseIndex))
+	mux.HandleFunc("/api/run", handleRun(catalog, store, executor, scheduler, clusters, tenants))
+	mux.HandleFunc("/api/terminal", handleTerminal(catalog, store, executor, scheduler, clusters, tenants, terminals))
 
-	return corsMiddleware(mux)
+	return corsMiddleware(corsOrigins, realIPMiddleware(proxies, mux))
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
+// corsMiddleware lets pages from the listed origins call the API with the
+// user's session cookie. The bundled frontend is served from the runner's own
+// origin and needs no entry; requests from any other origin get no CORS
+// headers, so browsers keep other sites from reading the responses.
+func corsMiddleware(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
 		origin := r.Header.Get("Origin")
-		if origin != "" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
+		if origin == "" || !allowed[origin] {
+			next.ServeHTTP(w, r)
+			return
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -51,3 +95,90 @@ func corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// ParseOrigins reads a comma-separated list of origins such as
+// https://learn.example.com.
+func ParseOrigins(list string) ([]string, error) {
+	var origins []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimRight(strings.TrimSpace(entry), "/")
+		if entry == "" {
+			continue
+		}
+		u, err := url.Parse(entry)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid origin %q: want scheme://host[:port]", entry)
+		}
+		origins = append(origins, entry)
+	}
+	return origins, nil
+}
+
+// TrustedProxies are the reverse proxies in front of the runner, whose
+// X-Real-IP header is taken as the client's address.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies reads a comma-separated list of addresses and CIDR
+// ranges.
+func ParseTrustedProxies(list string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Trusts reports whether a request's peer address is one of the proxies.
+func (p TrustedProxies) Trusts(remoteAddr string) bool {
+	ip := net.ParseIP(remoteHost(remoteAddr))
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIPMiddleware replaces the peer address of requests that come through a
+// trusted proxy with the client address the proxy passes in X-Real-IP. From
+// anyone else the header is ignored, since clients can send what they like.
+func realIPMiddleware(proxies TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil && proxies.Trusts(r.RemoteAddr) {
+			r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteHost is the address part of a request's RemoteAddr.
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientKeyTrustsOnlyProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.5, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:40000", "", "ip:203.0.113.7"},
+		{"direct client claiming another address", "203.0.113.7:40000", "198.51.100.1", "ip:203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:40000", "198.51.100.1", "ip:198.51.100.1"},
+		{"trusted proxy range", "[fd00::1]:40000", "198.51.100.1", "ip:198.51.100.1"},
+		{"trusted proxy without the header", "10.0.0.5:40000", "", "ip:10.0.0.5"},
+		{"trusted proxy with a bad header", "10.0.0.5:40000", "not-an-ip", "ip:10.0.0.5"},
+		{"untrusted neighbour", "10.0.0.6:40000", "198.51.100.1", "ip:10.0.0.6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := realIPMiddleware(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientKey(r, nil)
+			}))
+			r := httptest.NewRequest("GET", "/api/run", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("clientKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("ParseTrustedProxies accepted an invalid range")
+	}
+}
+
+func TestCORSAllowsOnlyListedOrigins(t *testing.T) {
+	origins, err := ParseOrigins("https://learn.example.com, http://localhost:5173/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := corsMiddleware(origins, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		name   string
+		method string
+		origin string
+		allow  string
+		status int
+	}{
+		{"listed origin", "GET", "https://learn.example.com", "https://learn.example.com", http.StatusNoContent},
+		{"listed origin preflight", "OPTIONS", "http://localhost:5173", "http://localhost:5173", http.StatusOK},
+		{"other origin", "GET", "https://evil.example", "", http.StatusNoContent},
+		{"other origin preflight", "OPTIONS", "https://evil.example", "", http.StatusNoContent},
+		{"same origin", "GET", "", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/users/me", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allow)
+			}
+			wantCreds := ""
+			if tt.allow != "" {
+				wantCreds = "true"
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, wantCreds)
+			}
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+
+	if _, err := ParseOrigins("learn.example.com"); err == nil {
+		t.Error("ParseOrigins accepted an origin without a scheme")
+	}
+}
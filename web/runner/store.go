@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Store is the runner's persistent state: users and their sessions, lesson
+// progress, drafts, test runs and groups. It is backed by SQLite or
+// PostgreSQL; both pass the checks in store_test.go.
+type Store interface {
+	// Users and login
+	CreateUser(username string) (*User, error)
+	GetUser(id string) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	CreateSession(userID, userAgent string, ttl time.Duration) (string, *Session, error)
+	GetSession(token string) (*Session, *User, error)
+	ListSessions(userID string) ([]Session, error)
+	RevokeSession(userID, sessionID string) (bool, error)
+	RevokeOtherSessions(userID, keepID string) error
+	GetIdentityUser(issuer, subject string) (*User, error)
+	LinkIdentity(issuer, subject, userID, email string) error
+	SetPassword(userID, passwordHash string) error
+	GetPasswordHash(userID string) (string, error)
+
+	// Progress
+	GetUserTotalPoints(userID string) (int, error)
+	RecordCompletion(userID, courseID, lessonSlug string, points int, viewedSolution bool, code map[string]string) error
+	GetSnapshot(userID, courseID, lessonSlug string) (map[string]string, error)
+	RecordSolutionView(userID, courseID, lessonSlug string) error
+	HasViewedSolution(userID, courseID, lessonSlug string) (bool, error)
+	RecordCourseBonus(userID, courseID string, bonusPoints int) error
+	HasCourseBonus(userID, courseID string) (bool, error)
+	GetUserCompletions(userID string) ([]Completion, error)
+	GetCourseCompletions(userID, courseID string) ([]Completion, error)
+	GetCompletedLessonsMap(userID, courseID string) (map[string]bool, error)
+	GetCompletedLessonCounts(userID string) (map[string]int, error)
+	GetLeaderboard(q LeaderboardQuery) ([]LeaderboardEntry, int, error)
+	GetLeaderboardEntry(q LeaderboardQuery, userID string) (*LeaderboardEntry, error)
+	GetLessonActivity(courseID string) (map[string]*LessonActivity, error)
+
+	// Drafts
+	SaveDraft(userID, courseID, lessonSlug string, code map[string]string) (*Draft, error)
+	GetDraft(userID, courseID, lessonSlug string, revision int) (*Draft, error)
+	ListDraftRevisions(userID, courseID, lessonSlug string) ([]Draft, error)
+
+	// Test runs
+	RecordRun(r *Run) error
+	ListRuns(userID string, q RunQuery) ([]Run, int, error)
+	GetRun(userID string, id int64) (*Run, error)
+
+	// Groups
+	CreateGroup(name, courseID, instructorID string) (*Group, error)
+	GetGroup(id string) (*Group, error)
+	GetGroupByJoinCode(code string) (*Group, error)
+	ListUserGroups(userID string) ([]Group, error)
+	GetGroupRole(groupID, userID string) (string, error)
+	AddGroupMember(groupID, userID, role string) error
+	SetGroupRole(groupID, userID, role string) (bool, error)
+	RemoveGroupMember(groupID, userID string) (bool, error)
+	ListGroupMembers(groupID string) ([]GroupMember, error)
+	RotateJoinCode(groupID string) (string, error)
+	GetGroupProgress(groupID, courseID string) (map[string]map[string]*LessonStatus, error)
+
+	// Ping checks that the database is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// OpenStore opens the database at url and brings its schema up to date. A
+// postgres:// or postgresql:// URL selects PostgreSQL; anything else is the
+// path of a SQLite database file.
+func OpenStore(url string) (Store, error) {
+	d, driver, dsn := dialectSQLite, "sqlite", url+"?_pragma=journal_mode(wal)&_pragma=busy_timeout(5000)"
+	if isPostgresURL(url) {
+		d, driver, dsn = dialectPostgres, "pgx", url
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("pinging database: %w", err)
+	}
+
+	s := &sqlStore{db: sqlDB{db, d}}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("running migrations: %w", err)
+	}
+
+	return s, nil
+}
+
+func isPostgresURL(url string) bool {
+	return strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://")
+}
+
+// sqlStore implements Store on top of database/sql. Queries are written for
+// SQLite with ? placeholders, in SQL that PostgreSQL accepts too; sqlDB
+// rewrites the placeholders for PostgreSQL.
+type sqlStore struct {
+	db sqlDB
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// dialect is the flavor of SQL a database speaks.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+func (d dialect) String() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// rebind rewrites ? placeholders to PostgreSQL's $1, $2, ... Question marks
+// inside quoted strings and identifiers are left alone.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sqlDB is a *sql.DB that rebinds queries for its dialect, so store methods
+// are written once for both databases.
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db sqlDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Begin() (sqlTx, error) {
+	tx, err := db.DB.Begin()
+	return sqlTx{tx, db.dialect}, err
+}
+
+// sqlTx is a *sql.Tx that rebinds queries like sqlDB.
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx sqlTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// isUniqueViolation reports whether err is a database error for a duplicate
+// primary key or unique column.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code()
+		return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	return false
+}
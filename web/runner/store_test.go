@@ -0,0 +1,712 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A storeCheck is behavior every Store implementation must have. Each check
+// gets a fresh, empty store.
+type storeCheck struct {
+	name string
+	run  func(s Store) error
+}
+
+// TestStore runs the store checks against SQLite and PostgreSQL, each
+// PostgreSQL check in a throwaway schema. The PostgreSQL database is the one
+// at DATABASE_URL, or else a temporary server started from the PostgreSQL
+// binaries on PATH. Without either the PostgreSQL half is skipped, except in
+// CI, where it fails.
+func TestStore(t *testing.T) {
+	log.SetOutput(io.Discard) // every check applies the migrations again
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	t.Run("sqlite", func(t *testing.T) {
+		checkStore(t, func(t *testing.T) Store {
+			s, err := OpenStore(filepath.Join(t.TempDir(), "check.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		})
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("DATABASE_URL")
+		if url == "" {
+			url = localPostgres(t)
+		}
+		if !isPostgresURL(url) {
+			t.Fatal("DATABASE_URL must be a postgres:// URL")
+		}
+		checkStore(t, func(t *testing.T) Store { return tempPostgresStore(t, url) })
+	})
+}
+
+// localPostgres starts a PostgreSQL server of its own for the test, listening
+// only on a unix socket, and returns its URL. It skips the test when there is
+// no PostgreSQL to start, or fails it when running in CI.
+func localPostgres(t *testing.T) string {
+	t.Helper()
+	unavailable := func(reason string) {
+		t.Helper()
+		if os.Getenv("CI") != "" {
+			t.Fatalf("%s; set DATABASE_URL to test the PostgreSQL store", reason)
+		}
+		t.Skipf("%s; set DATABASE_URL or install PostgreSQL to test the PostgreSQL store", reason)
+	}
+
+	bin := ""
+	if pgCtl, err := exec.LookPath("pg_ctl"); err == nil {
+		bin = filepath.Dir(pgCtl)
+	} else if out, err := exec.Command("pg_config", "--bindir").Output(); err == nil {
+		bin = strings.TrimSpace(string(out))
+	}
+	if _, err := os.Stat(filepath.Join(bin, "initdb")); bin == "" || err != nil {
+		unavailable("DATABASE_URL is not set and PostgreSQL is not installed")
+	}
+	if os.Geteuid() == 0 {
+		unavailable("DATABASE_URL is not set and PostgreSQL won't run as root")
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	// Unix socket paths are short, so the socket doesn't go under dir
+	sockets, err := os.MkdirTemp("", "vt-pg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(sockets) })
+
+	run := func(name string, args ...string) {
+		t.Helper()
+		if out, err := exec.Command(filepath.Join(bin, name), args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", name, err, out)
+		}
+	}
+	run("initdb", "-D", data, "-U", "postgres", "-A", "trust", "--no-sync")
+	run("pg_ctl", "start", "-w", "-D", data, "-l", filepath.Join(dir, "postgres.log"),
+		"-o", "-F -k "+sockets+" -c listen_addresses=''")
+	t.Cleanup(func() {
+		exec.Command(filepath.Join(bin, "pg_ctl"), "stop", "-D", data, "-m", "immediate").Run()
+	})
+
+	q := neturl.Values{"host": {sockets}, "sslmode": {"disable"}}
+	return "postgres://postgres@/postgres?" + q.Encode()
+}
+
+// checkStore runs every store check against a fresh store from open.
+func checkStore(t *testing.T, open func(t *testing.T) Store) {
+	for _, c := range storeChecks {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(open(t)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// tempPostgresStore opens a store in a new schema of the database at url,
+// which is dropped when the test ends.
+func tempPostgresStore(t *testing.T, url string) Store {
+	t.Helper()
+	u, err := neturl.Parse(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := sql.Open("pgx", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "storecheck_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	s, err := OpenStore(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// mismatch reports a value that differs from what a check expected.
+func mismatch(what string, got, want any) error {
+	return fmt.Errorf("%s = %v, want %v", what, got, want)
+}
+
+var storeChecks = []storeCheck{
+	{"users", func(s Store) error {
+		u, err := s.CreateUser("ada")
+		if err != nil {
+			return err
+		}
+		got, err := s.GetUser(u.ID)
+		if err != nil {
+			return err
+		}
+		if *got != *u {
+			return mismatch("GetUser", *got, *u)
+		}
+		if got, err = s.GetUserByUsername("ada"); err != nil || got.ID != u.ID {
+			return mismatch("GetUserByUsername", got, u)
+		}
+		if _, err := s.GetUser("nobody"); !errors.Is(err, sql.ErrNoRows) {
+			return mismatch("GetUser of a missing user", err, sql.ErrNoRows)
+		}
+		if _, err := s.CreateUser("ada"); !isUniqueViolation(err) {
+			return mismatch("CreateUser of a taken username", err, "a unique violation")
+		}
+		return nil
+	}},
+
+	{"sessions", func(s Store) error {
+		u, err := s.CreateUser("ada")
+		if err != nil {
+			return err
+		}
+		token, sess, err := s.CreateSession(u.ID, "browser", time.Hour)
+		if err != nil {
+			return err
+		}
+		gotSess, gotUser, err := s.GetSession(token)
+		if err != nil {
+			return err
+		}
+		if *gotSess != *sess || gotUser.ID != u.ID {
+			return mismatch("GetSession", *gotSess, *sess)
+		}
+		if _, _, err := s.GetSession("not-a-token"); !errors.Is(err, sql.ErrNoRows) {
+			return mismatch("GetSession of an unknown token", err, sql.ErrNoRows)
+		}
+		expired, _, err := s.CreateSession(u.ID, "old", -time.Minute)
+		if err != nil {
+			return err
+		}
+		if _, _, err := s.GetSession(expired); !errors.Is(err, sql.ErrNoRows) {
+			return mismatch("GetSession of an expired session", err, sql.ErrNoRows)
+		}
+
+		_, other, err := s.CreateSession(u.ID, "phone", time.Hour)
+		if err != nil {
+			return err
+		}
+		if _, _, err := s.CreateSession(u.ID, "tablet", time.Hour); err != nil {
+			return err
+		}
+		if list, err := s.ListSessions(u.ID); err != nil || len(list) != 3 {
+			return mismatch("number of live sessions", len(list), 3)
+		}
+		if ok, err := s.RevokeSession(u.ID, other.ID); err != nil || !ok {
+			return mismatch("RevokeSession", ok, true)
+		}
+		if ok, err := s.RevokeSession(u.ID, other.ID); err != nil || ok {
+			return mismatch("RevokeSession of a revoked session", ok, false)
+		}
+		if err := s.RevokeOtherSessions(u.ID, sess.ID); err != nil {
+			return err
+		}
+		list, err := s.ListSessions(u.ID)
+		if err != nil {
+			return err
+		}
+		if len(list) != 1 || list[0].ID != sess.ID {
+			return mismatch("sessions after revoking the others", list, []string{sess.ID})
+		}
+		return nil
+	}},
+
+	{"identities and passwords", func(s Store) error {
+		u, err := s.CreateUser("ada")
+		if err != nil {
+			return err
+		}
+		if _, err := s.GetIdentityUser("https://idp", "sub-1"); !errors.Is(err, sql.ErrNoRows) {
+			return mismatch("GetIdentityUser of an unlinked identity", err, sql.ErrNoRows)
+		}
+		if err := s.LinkIdentity("https://idp", "sub-1", u.ID, "ada@example.com"); err != nil {
+			return err
+		}
+		if got, err := s.GetIdentityUser("https://idp", "sub-1"); err != nil || got.ID != u.ID {
+			return mismatch("GetIdentityUser", got, u)
+		}
+		if err := s.LinkIdentity("https://idp", "sub-1", u.ID, ""); !isUniqueViolation(err) {
+			return mismatch("LinkIdentity of a linked identity", err, "a unique violation")
+		}
+
+		if hash, err := s.GetPasswordHash(u.ID); err != nil || hash != "" {
+			return mismatch("GetPasswordHash without a password", hash, "")
+		}
+		for _, hash := range []string{"first", "second"} {
+			if err := s.SetPassword(u.ID, hash); err != nil {
+				return err
+			}
+		}
+		if hash, err := s.GetPasswordHash(u.ID); err != nil || hash != "second" {
+			return mismatch("GetPasswordHash", hash, "second")
+		}
+		return nil
+	}},
+
+	{"completions", func(s Store) error {
+		u, err := s.CreateUser("ada")
+		if err != nil {
+			return err
+		}
+		if err := s.RecordCompletion(u.ID, "go", "hello", 100, false, map[string]string{"main.go": "v1"}); err != nil {
+			return err
+		}
+		// A second pass replaces the snapshot but not the points.
+		if err := s.RecordCompletion(u.ID, "go", "hello", 50, true, map[string]string{"main.go": "v2"}); err != nil {
+			return err
+		}
+		if err := s.RecordCompletion(u.ID, "go", "loops", 70, true, nil); err != nil {
+			return err
+		}
+		if err := s.RecordCompletion(u.ID, "rust", "hello", 30, false, nil); err != nil {
+			return err
+		}
+		if err := s.RecordCourseBonus(u.ID, "go", 500); err != nil {
+			return err
+		}
+		if err := s.RecordCourseBonus(u.ID, "go", 500); err != nil {
+			return err
+		}
+
+		if total, err := s.GetUserTotalPoints(u.ID); err != nil || total != 700 {
+			return mismatch("GetUserTotalPoints", total, 700)
+		}
+		code, err := s.GetSnapshot(u.ID, "go", "hello")
+		if err != nil {
+			return err
+		}
+		if want := map[string]string{"main.go": "v2"}; !reflect.DeepEqual(code, want) {
+			return mismatch("GetSnapshot", code, want)
+		}
+		if code, err := s.GetSnapshot(u.ID, "go", "missing"); err != nil || code != nil {
+			return mismatch("GetSnapshot of an unpassed lesson", code, nil)
+		}
+
+		all, err := s.GetUserCompletions(u.ID)
+		if err != nil {
+			return err
+		}
+		if len(all) != 3 {
+			return mismatch("number of completions", len(all), 3)
+		}
+		course, err := s.GetCourseCompletions(u.ID, "go")
+		if err != nil {
+			return err
+		}
+		if len(course) != 2 {
+			return mismatch("number of go completions", len(course), 2)
+		}
+		for _, c := range course {
+			if c.LessonSlug == "hello" && (c.Points != 100 || c.ViewedSolution) {
+				return mismatch("hello completion", c, "100 points without the solution")
+			}
+		}
+		done, err := s.GetCompletedLessonsMap(u.ID, "go")
+		if err != nil {
+			return err
+		}
+		if want := map[string]bool{"hello": true, "loops": true}; !reflect.DeepEqual(done, want) {
+			return mismatch("GetCompletedLessonsMap", done, want)
+		}
+		counts, err := s.GetCompletedLessonCounts(u.ID)
+		if err != nil {
+			return err
+		}
+		if want := map[string]int{"go": 2, "rust": 1}; !reflect.DeepEqual(counts, want) {
+			return mismatch("GetCompletedLessonCounts", counts, want)
+		}
+		if ok, err := s.HasCourseBonus(u.ID, "go"); err != nil || !ok {
+			return mismatch("HasCourseBonus", ok, true)
+		}
+		if ok, err := s.HasCourseBonus(u.ID, "rust"); err != nil || ok {
+			return mismatch("HasCourseBonus of a course without one", ok, false)
+		}
+		return nil
+	}},
+
+	{"solution views", func(s Store) error {
+		u, err := s.CreateUser("ada")
+		if err != nil {
+			return err
+		}
+		if ok, err := s.HasViewedSolution(u.ID, "go", "hello"); err != nil || ok {
+			return mismatch("HasViewedSolution before viewing", ok, false)
+		}
+		for range 2 {
+			if err := s.RecordSolutionView(u.ID, "go", "hello"); err != nil {
+				return err
+			}
+		}
+		if ok, err := s.HasViewedSolution(u.ID, "go", "hello"); err != nil || !ok {
+			return mismatch("HasViewedSolution", ok, true)
+		}
+		return nil
+	}},
+
+	{"drafts", func(s Store) error {
+		u, err := s.CreateUser("ada")
+		if err != nil {
+			return err
+		}
+		if d, err := s.GetDraft(u.ID, "go", "hello", 0); err != nil || d != nil {
+			return mismatch("GetDraft without drafts", d, nil)
+		}
+		first, err := s.SaveDraft(u.ID, "go", "hello", map[string]string{"main.go": "0"})
+		if err != nil {
+			return err
+		}
+		same, err := s.SaveDraft(u.ID, "go", "hello", map[string]string{"main.go": "0"})
+		if err != nil {
+			return err
+		}
+		if same.Revision != first.Revision {
+			return mismatch("revision after saving unchanged code", same.Revision, first.Revision)
+		}
+		for i := 1; i <= draftHistory; i++ {
+			if _, err := s.SaveDraft(u.ID, "go", "hello", map[string]string{"main.go": fmt.Sprint(i)}); err != nil {
+				return err
+			}
+		}
+
+		revisions, err := s.ListDraftRevisions(u.ID, "go", "hello")
+		if err != nil {
+			return err
+		}
+		if len(revisions) != draftHistory || revisions[0].Revision != draftHistory+1 {
+			return mismatch("revisions kept", len(revisions), draftHistory)
+		}
+		latest, err := s.GetDraft(u.ID, "go", "hello", 0)
+		if err != nil {
+			return err
+		}
+		if latest.Revision != draftHistory+1 || latest.Code["main.go"] != fmt.Sprint(draftHistory) {
+			return mismatch("latest draft", latest, draftHistory+1)
+		}
+		if d, err := s.GetDraft(u.ID, "go", "hello", 5); err != nil || d == nil || d.Code["main.go"] != "4" {
+			return mismatch("draft revision 5", d, "main.go = 4")
+		}
+		if d, err := s.GetDraft(u.ID, "go", "hello", first.Revision); err != nil || d != nil {
+			return mismatch("pruned draft revision", d, nil)
+		}
+		return nil
+	}},
+
+	{"leaderboard", func(s Store) error {
+		points := map[string]int{"ada": 300, "bob": 300, "cy": 100, "dee": 0}
+		ids := map[string]string{}
+		for _, name := range []string{"ada", "bob", "cy", "dee"} {
+			u, err := s.CreateUser(name)
+			if err != nil {
+				return err
+			}
+			ids[name] = u.ID
+			if err := s.RecordCompletion(u.ID, "go", "hello", points[name], false, nil); err != nil {
+				return err
+			}
+		}
+		if err := s.RecordCompletion(ids["cy"], "rust", "hello", 500, false, nil); err != nil {
+			return err
+		}
+
+		entries, total, err := s.GetLeaderboard(LeaderboardQuery{Limit: 10})
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, fmt.Sprintf("%d %s %d", e.Rank, e.Username, e.TotalPoints))
+		}
+		if want := []string{"1 cy 600", "2 ada 300", "2 bob 300"}; total != 3 || !reflect.DeepEqual(got, want) {
+			return mismatch("leaderboard", got, want)
+		}
+
+		entries, total, err = s.GetLeaderboard(LeaderboardQuery{CourseID: "go", Limit: 1, Offset: 1})
+		if err != nil {
+			return err
+		}
+		if total != 3 || len(entries) != 1 || entries[0].Username != "bob" || entries[0].Rank != 1 {
+			return mismatch("second page of the go leaderboard", entries, "bob ranked 1 of 3")
+		}
+		entries, total, err = s.GetLeaderboard(LeaderboardQuery{Since: time.Now().Add(time.Hour), Limit: 10})
+		if err != nil {
+			return err
+		}
+		if total != 0 || len(entries) != 0 {
+			return mismatch("leaderboard since the future", total, 0)
+		}
+
+		me, err := s.GetLeaderboardEntry(LeaderboardQuery{CourseID: "go"}, ids["cy"])
+		if err != nil {
+			return err
+		}
+		if me == nil || me.Rank != 3 || me.TotalPoints != 100 || me.CompletedCount != 1 {
+			return mismatch("cy's place on the go leaderboard", me, "rank 3 with 100 points")
+		}
+		if me, err := s.GetLeaderboardEntry(LeaderboardQuery{}, ids["dee"]); err != nil || me != nil {
+			return mismatch("place of a user without points", me, nil)
+		}
+
+		g, err := s.CreateGroup("class", "", ids["ada"])
+		if err != nil {
+			return err
+		}
+		if err := s.AddGroupMember(g.ID, ids["bob"], groupStudent); err != nil {
+			return err
+		}
+		entries, total, err = s.GetLeaderboard(LeaderboardQuery{GroupID: g.ID, Limit: 10})
+		if err != nil {
+			return err
+		}
+		if total != 1 || entries[0].Username != "bob" {
+			return mismatch("group leaderboard", entries, "only bob, the group's student")
+		}
+		return nil
+	}},
+
+	{"runs", func(s Store) error {
+		u, err := s.CreateUser("ada")
+		if err != nil {
+			return err
+		}
+		other, err := s.CreateUser("bob")
+		if err != nil {
+			return err
+		}
+		output := strings.Repeat("=== RUN TestHello\n", 100)
+		now := time.Now().UTC().Format(timeFormat)
+		runs := []*Run{
+			{UserID: u.ID, CourseID: "go", LessonSlug: "hello", ExitCode: 1, DurationMS: 1200, Failed: 1, Output: output, StartedAt: now},
+			{UserID: u.ID, CourseID: "go", LessonSlug: "hello", ExitCode: 0, DurationMS: 900, Passed: 1, StartedAt: now},
+			{UserID: u.ID, CourseID: "go", LessonSlug: "loops", ExitCode: -1, Error: "timed out", StartedAt: now},
+			{CourseID: "go", LessonSlug: "hello", StartedAt: now},
+		}
+		for i, r := range runs {
+			if err := s.RecordRun(r); err != nil {
+				return err
+			}
+			if i > 0 && r.ID <= runs[i-1].ID {
+				return mismatch("run ID", r.ID, fmt.Sprintf("more than %d", runs[i-1].ID))
+			}
+		}
+
+		list, total, err := s.ListRuns(u.ID, RunQuery{Limit: 2})
+		if err != nil {
+			return err
+		}
+		if total != 3 || len(list) != 2 || list[0].ID != runs[2].ID || list[0].Error != "timed out" {
+			return mismatch("first page of runs", list, "the 2 newest of 3")
+		}
+		list, total, err = s.ListRuns(u.ID, RunQuery{LessonSlug: "hello", Limit: 10, Offset: 1})
+		if err != nil {
+			return err
+		}
+		if total != 2 || len(list) != 1 || list[0].ID != runs[0].ID || list[0].Output != "" {
+			return mismatch("second page of hello runs", list, "the first run without output")
+		}
+
+		got, err := s.GetRun(u.ID, runs[0].ID)
+		if err != nil {
+			return err
+		}
+		if got.Output != output || got.OutputSize != len(output) || got.DurationMS != 1200 || got.Failed != 1 {
+			return mismatch("GetRun", got, runs[0])
+		}
+		if _, err := s.GetRun(other.ID, runs[0].ID); !errors.Is(err, sql.ErrNoRows) {
+			return mismatch("GetRun of another user's run", err, sql.ErrNoRows)
+		}
+		return nil
+	}},
+
+	{"groups", func(s Store) error {
+		teacher, err := s.CreateUser("teacher")
+		if err != nil {
+			return err
+		}
+		student, err := s.CreateUser("student")
+		if err != nil {
+			return err
+		}
+		g, err := s.CreateGroup("class", "go", teacher.ID)
+		if err != nil {
+			return err
+		}
+		if len(g.JoinCode) != joinCodeLen {
+			return mismatch("join code", g.JoinCode, fmt.Sprintf("%d characters", joinCodeLen))
+		}
+		if got, err := s.GetGroupByJoinCode(g.JoinCode); err != nil || got.ID != g.ID {
+			return mismatch("GetGroupByJoinCode", got, g)
+		}
+
+		if err := s.AddGroupMember(g.ID, student.ID, groupStudent); err != nil {
+			return err
+		}
+		// Joining again keeps the existing role.
+		if err := s.AddGroupMember(g.ID, teacher.ID, groupStudent); err != nil {
+			return err
+		}
+		if role, err := s.GetGroupRole(g.ID, teacher.ID); err != nil || role != groupInstructor {
+			return mismatch("role after joining twice", role, groupInstructor)
+		}
+		got, err := s.GetGroup(g.ID)
+		if err != nil {
+			return err
+		}
+		if got.Members != 2 || got.CourseID != "go" {
+			return mismatch("GetGroup", got, "2 members working on go")
+		}
+		groups, err := s.ListUserGroups(student.ID)
+		if err != nil {
+			return err
+		}
+		if len(groups) != 1 || groups[0].Role != groupStudent {
+			return mismatch("ListUserGroups", groups, "one group as a student")
+		}
+
+		if ok, err := s.SetGroupRole(g.ID, student.ID, groupInstructor); err != nil || !ok {
+			return mismatch("SetGroupRole", ok, true)
+		}
+		members, err := s.ListGroupMembers(g.ID)
+		if err != nil {
+			return err
+		}
+		if len(members) != 2 || members[0].Role != groupInstructor || members[1].Role != groupInstructor {
+			return mismatch("ListGroupMembers", members, "two instructors")
+		}
+		if ok, err := s.RemoveGroupMember(g.ID, student.ID); err != nil || !ok {
+			return mismatch("RemoveGroupMember", ok, true)
+		}
+		if ok, err := s.RemoveGroupMember(g.ID, student.ID); err != nil || ok {
+			return mismatch("RemoveGroupMember of a non-member", ok, false)
+		}
+		if role, err := s.GetGroupRole(g.ID, student.ID); err != nil || role != "" {
+			return mismatch("role of a removed member", role, "")
+		}
+
+		code, err := s.RotateJoinCode(g.ID)
+		if err != nil {
+			return err
+		}
+		if code == g.JoinCode {
+			return errors.New("RotateJoinCode kept the old code")
+		}
+		if _, err := s.GetGroupByJoinCode(g.JoinCode); !errors.Is(err, sql.ErrNoRows) {
+			return mismatch("GetGroupByJoinCode of the old code", err, sql.ErrNoRows)
+		}
+		return nil
+	}},
+
+	{"group progress", func(s Store) error {
+		teacher, err := s.CreateUser("teacher")
+		if err != nil {
+			return err
+		}
+		student, err := s.CreateUser("student")
+		if err != nil {
+			return err
+		}
+		g, err := s.CreateGroup("class", "go", teacher.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.AddGroupMember(g.ID, student.ID, groupStudent); err != nil {
+			return err
+		}
+		now := time.Now().UTC().Format(timeFormat)
+		for _, id := range []string{teacher.ID, student.ID, student.ID} {
+			if err := s.RecordRun(&Run{UserID: id, CourseID: "go", LessonSlug: "hello", StartedAt: now}); err != nil {
+				return err
+			}
+		}
+		if err := s.RecordCompletion(student.ID, "go", "hello", 100, true, nil); err != nil {
+			return err
+		}
+		if err := s.RecordSolutionView(student.ID, "go", "hello"); err != nil {
+			return err
+		}
+		if err := s.RecordCompletion(teacher.ID, "go", "hello", 100, false, nil); err != nil {
+			return err
+		}
+
+		progress, err := s.GetGroupProgress(g.ID, "go")
+		if err != nil {
+			return err
+		}
+		if progress[teacher.ID] != nil {
+			return errors.New("GetGroupProgress includes the instructor")
+		}
+		st := progress[student.ID]["hello"]
+		if st == nil || !st.Completed || st.Points != 100 || st.Attempts != 2 || !st.ViewedSolution {
+			return mismatch("student's progress", st, "completed for 100 points in 2 attempts with the solution viewed")
+		}
+		return nil
+	}},
+
+	{"lesson activity", func(s Store) error {
+		now := time.Now().UTC().Format(timeFormat)
+		for i, name := range []string{"ada", "bob"} {
+			u, err := s.CreateUser(name)
+			if err != nil {
+				return err
+			}
+			for j := 0; j <= i; j++ {
+				if err := s.RecordRun(&Run{UserID: u.ID, CourseID: "go", LessonSlug: "hello", ExitCode: 1, StartedAt: now}); err != nil {
+					return err
+				}
+			}
+			if err := s.RecordRun(&Run{UserID: u.ID, CourseID: "go", LessonSlug: "hello", StartedAt: now}); err != nil {
+				return err
+			}
+			if err := s.RecordCompletion(u.ID, "go", "hello", 100, false, nil); err != nil {
+				return err
+			}
+		}
+		if err := s.RecordRun(&Run{CourseID: "go", LessonSlug: "hello", StartedAt: now}); err != nil {
+			return err
+		}
+
+		activity, err := s.GetLessonActivity("go")
+		if err != nil {
+			return err
+		}
+		a := activity["hello"]
+		if a == nil {
+			return errors.New("no activity on the lesson")
+		}
+		if a.Started != 2 || len(a.Completers) != 2 || a.Runs != 5 || a.PassingRuns != 2 {
+			return mismatch("lesson activity", *a, "2 students, 5 runs, 2 passing")
+		}
+		attempts := append([]int(nil), a.AttemptsToPass...)
+		if len(attempts) != 2 || attempts[0]+attempts[1] != 5 {
+			return mismatch("attempts to pass", attempts, "2 and 3")
+		}
+		return nil
+	}},
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// runTestsMarker is what run-tests prints to ask the runner for a run. It
+// is an OSC sequence, which terminals that see it anyway ignore.
+var runTestsMarker = []byte("\x1b]vibe-train;run-tests\a")
+
+// runTestsStatus comes before the exit status in the line the runner types
+// into the terminal when a run ends. Anything the student typed ahead ends
+// up before it, and run-tests skips that.
+const runTestsStatus = "vibe-train-status "
+
+// runTestsScript is the run-tests command. It can't run the tests itself:
+// the shell is a student's, and runs go through the executor and scheduler
+// like the Run button's. So it asks the runner with runTestsMarker, which
+// the session strips from the output, and waits for the runner to type the
+// exit status into the terminal.
+const runTestsScript = `#!/usr/bin/env bash
+# Asks the runner to run this lesson's tests the way the Run button does.
+if [ ! -t 0 ] || [ ! -t 1 ]; then
+	echo "run-tests: only works in the lesson terminal" >&2
+	exit 2
+fi
+saved=$(stty -g)
+trap 'stty "$saved"' EXIT
+stty -echo
+printf '\033]vibe-train;run-tests\007'
+while IFS= read -r line; do
+	case $line in
+	*"` + runTestsStatus + `"[0-9]*) exit "${line##*` + runTestsStatus + `}" ;;
+	esac
+done
+exit 1
+`
+
+// terminalTests runs a lesson's tests for run-tests in its terminal, one
+// run at a time, on the same executor and scheduler as the Run button.
+type terminalTests struct {
+	executor  Executor
+	scheduler *Scheduler
+	course    *Course
+	slug      string
+	key       string   // the user, for the scheduler
+	env       []string // the session's namespace
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // non-nil while a run is in progress
+}
+
+// start begins a run and returns its context, or false if one is already
+// in progress.
+func (tt *terminalTests) start() (context.Context, bool) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.cancel != nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	tt.cancel = cancel
+	return ctx, true
+}
+
+func (tt *terminalTests) finish() {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.cancel != nil {
+		tt.cancel()
+		tt.cancel = nil
+	}
+}
+
+// interrupt reports whether a run is in progress, in which case the
+// client's input is not for the shell. Ctrl-C in it cancels the run.
+func (tt *terminalTests) interrupt(input string) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.cancel == nil {
+		return false
+	}
+	if strings.ContainsRune(input, '\x03') {
+		tt.cancel()
+	}
+	return true
+}
+
+// run runs the tests on code, printing their output a line at a time, and
+// returns the exit status for run-tests: the tests', or 130 like bash if
+// the run was canceled.
+func (tt *terminalTests) run(ctx context.Context, code map[string]string, print func(line string)) int {
+	release, err := tt.scheduler.Acquire(ctx, tt.key, func(position int) {
+		print(fmt.Sprintf("Waiting for a free slot (%d in line)...", position))
+	})
+	if err != nil {
+		print("error: " + errRunCanceled.Error())
+		return 130
+	}
+	defer release()
+
+	result, err := runLesson(ctx, tt.executor, tt.course, tt.slug, code, tt.env, func(m RunMessage) {
+		switch m.Type {
+		case "stdout", "stderr":
+			print(m.Data)
+		case "error":
+			print("error: " + m.Data)
+		case "summary":
+			print(fmt.Sprintf("%d passed, %d failed, %d skipped", m.Passed, m.Failed, m.Skipped))
+		}
+	})
+	switch {
+	case err != nil:
+		print("error: " + err.Error())
+		return 1
+	case result.Err == errRunCanceled:
+		return 130
+	case result.Err != nil || result.ExitCode < 0:
+		return 1
+	}
+	return result.ExitCode
+}
+
+// runTests runs the lesson's tests for a run-tests waiting in the shell,
+// on the code the workspace has, and answers it with their exit status.
+func (s *terminalSession) runTests() {
+	ctx, ok := s.tests.start()
+	if !ok {
+		return
+	}
+	go func() {
+		status := s.tests.run(ctx, s.ws.Code(), func(line string) {
+			s.output([]byte(line + "\r\n"))
+		})
+		// Answer while input is still held back, so nothing the student
+		// types gets ahead of the status.
+		s.ptmx.Write([]byte(runTestsStatus + strconv.Itoa(status) + "\n"))
+		s.tests.finish()
+	}()
+}
+
+// partialRunTestsMarker returns how many bytes at the end of p could be
+// the start of runTestsMarker, to hold back until the next read.
+func partialRunTestsMarker(p []byte) int {
+	for n := min(len(p), len(runTestsMarker)-1); n > 0; n-- {
+		if bytes.HasPrefix(runTestsMarker, p[len(p)-n:]) {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// terminalScrollback is how much of a session's latest output is kept
+	// to replay to a client that reattaches.
+	terminalScrollback = 256 << 10
+	// maxTerminalSessionsPerUser caps the sessions one user keeps open;
+	// opening another ends their oldest.
+	maxTerminalSessionsPerUser = 3
+	// terminalWriteTimeout bounds each write to a client, so a stalled
+	// socket can't hold up the shell's output.
+	terminalWriteTimeout = 10 * time.Second
+)
+
+// TerminalSessions keeps terminal sessions running on the server while no
+// client is connected. A session whose client goes away, on a page reload
+// or a dropped connection, lives on for the grace period; a client that
+// attaches to it in that time gets the session's recent output replayed
+// and carries on where the last one stopped.
+type TerminalSessions struct {
+	grace time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*terminalSession // by ID
+}
+
+// NewTerminalSessions returns a registry that ends sessions once they have
+// been detached for grace.
+func NewTerminalSessions(grace time.Duration) *TerminalSessions {
+	return &TerminalSessions{grace: grace, sessions: make(map[string]*terminalSession)}
+}
+
+// terminalSession is a shell on a pty in a lesson's terminal workspace. It
+// outlives the sockets attached to it, one at a time.
+type terminalSession struct {
+	id      string
+	owner   string // the user ID; only they may attach
+	created time.Time
+
+	stop    func() // hangs up the shell and waits for it
+	ptmx    *os.File
+	ws      *terminalWorkspace
+	tests   *terminalTests
+	release []func() // the cluster and namespace the session holds
+	done    chan struct{}
+
+	mu         sync.Mutex
+	client     *terminalClient // nil while detached
+	scrollback *ringBuffer
+	expiry     *time.Timer // ends the session while detached
+	closed     bool
+}
+
+// terminalClient is one socket attached to a session.
+type terminalClient struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // serializes writes
+}
+
+func (c *terminalClient) send(msg TerminalMessage) error {
+	b, _ := json.Marshal(msg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(terminalWriteTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+func (c *terminalClient) ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(terminalWriteTimeout))
+	return c.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+// Start registers a session for a shell already running on ptmx in ws,
+// which stop ends, and starts relaying its output. tests runs the tests
+// when the shell asks with run-tests. release is called when the session
+// ends. If owner now has too many sessions, their oldest is ended.
+func (ts *TerminalSessions) Start(owner string, stop func(), ptmx *os.File, ws *terminalWorkspace, tests *terminalTests, release ...func()) *terminalSession {
+	s := &terminalSession{
+		id:         uuid.New().String(),
+		owner:      owner,
+		created:    time.Now(),
+		stop:       stop,
+		ptmx:       ptmx,
+		ws:         ws,
+		tests:      tests,
+		release:    release,
+		done:       make(chan struct{}),
+		scrollback: newRingBuffer(terminalScrollback),
+	}
+
+	ts.mu.Lock()
+	var mine []*terminalSession
+	for _, other := range ts.sessions {
+		if other.owner == owner {
+			mine = append(mine, other)
+		}
+	}
+	ts.sessions[s.id] = s
+	ts.mu.Unlock()
+
+	sort.Slice(mine, func(i, j int) bool { return mine[i].created.Before(mine[j].created) })
+	for len(mine) >= maxTerminalSessionsPerUser {
+		ts.end(mine[0], "this terminal was closed because you opened too many others")
+		mine = mine[1:]
+	}
+
+	go ts.pump(s)
+	go s.sync()
+	return s
+}
+
+// Get returns owner's session id, or nil if there is none.
+func (ts *TerminalSessions) Get(id, owner string) *terminalSession {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if s := ts.sessions[id]; s != nil && s.owner == owner {
+		return s
+	}
+	return nil
+}
+
+// end removes s and stops its shell. An attached client is told why.
+func (ts *TerminalSessions) end(s *terminalSession, reason string) {
+	ts.mu.Lock()
+	if ts.sessions[s.id] == s {
+		delete(ts.sessions, s.id)
+	}
+	ts.mu.Unlock()
+	s.close(reason)
+}
+
+// pump relays the shell's output to the scrollback and the attached client
+// until the shell exits. It takes run-tests' requests out of the output and
+// starts the runs.
+func (ts *TerminalSessions) pump(s *terminalSession) {
+	buf := make([]byte, 4096)
+	var pending []byte // the start of a character or request the next read finishes
+	for {
+		n, err := s.ptmx.Read(buf)
+		if err != nil {
+			ts.end(s, "shell exited")
+			return
+		}
+		data := append(pending, buf[:n]...)
+		for {
+			i := bytes.Index(data, runTestsMarker)
+			if i < 0 {
+				break
+			}
+			if i > 0 {
+				s.output(data[:i])
+			}
+			s.runTests()
+			data = data[i+len(runTestsMarker):]
+		}
+		cut := min(utf8Boundary(data), len(data)-partialRunTestsMarker(data))
+		pending = append([]byte(nil), data[cut:]...)
+		if cut > 0 {
+			s.output(data[:cut])
+		}
+	}
+}
+
+func (s *terminalSession) output(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scrollback.Write(p)
+	if s.client != nil {
+		if err := s.client.send(TerminalMessage{Type: "output", Data: string(p)}); err != nil {
+			s.client.conn.Close()
+		}
+	}
+}
+
+// input passes the client's keystrokes to the shell. While run-tests waits
+// for a run they are dropped, except Ctrl-C, which cancels the run.
+func (s *terminalSession) input(data string) error {
+	if s.tests.interrupt(data) {
+		return nil
+	}
+	_, err := s.ptmx.Write([]byte(data))
+	return err
+}
+
+// sync sends files the shell changed to the attached client. Changes made
+// while detached wait for the next client.
+func (s *terminalSession) sync() {
+	ticker := time.NewTicker(terminalSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		if s.client != nil {
+			s.sendChanges()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// sendChanges sends the attached client the files the shell changed since
+// they were last sent, and returns them. Callers hold s.mu.
+func (s *terminalSession) sendChanges() map[string]string {
+	changed := s.ws.Changes()
+	for path, content := range changed {
+		if err := s.client.send(TerminalMessage{Type: "file", Path: path, Data: content}); err != nil {
+			s.client.conn.Close()
+			break
+		}
+	}
+	return changed
+}
+
+// attach makes c the session's client, replacing any other, and replays
+// the scrollback to it. code is the client's editor; files in it that the
+// shell hasn't changed since the last client left are updated from it, and
+// the client gets the ones it has. It returns false if the session has
+// ended.
+func (ts *TerminalSessions) attach(s *terminalSession, c *terminalClient, code map[string]string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if old := s.client; old != nil {
+		old.send(TerminalMessage{Type: "error", Data: "this terminal was opened somewhere else"})
+		old.conn.Close()
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.client = c
+
+	c.send(TerminalMessage{Type: "session", SessionID: s.id})
+	if replay := s.scrollback.Bytes(); len(replay) > 0 {
+		c.send(TerminalMessage{Type: "output", Data: string(replay)})
+	}
+	changed := s.sendChanges()
+	for path, content := range code {
+		if _, ok := changed[path]; ok {
+			continue
+		}
+		if err := s.ws.WriteFile(path, content); err != nil {
+			log.Printf("terminal file sync: %v", err)
+		}
+	}
+	return true
+}
+
+// detach lets go of c, if it is still the session's client, and ends the
+// session unless a client attaches within the grace period.
+func (ts *TerminalSessions) detach(s *terminalSession, c *terminalClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != c {
+		return
+	}
+	s.client = nil
+	var expiry *time.Timer
+	expiry = time.AfterFunc(ts.grace, func() {
+		s.mu.Lock()
+		expired := s.expiry == expiry
+		s.mu.Unlock()
+		if expired {
+			ts.end(s, "")
+		}
+	})
+	s.expiry = expiry
+}
+
+// close stops the shell, deletes the workspace and releases what the
+// session held.
+func (s *terminalSession) close(reason string) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	c := s.client
+	s.client = nil
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.mu.Unlock()
+
+	if c != nil {
+		c.send(TerminalMessage{Type: "exit", Data: reason})
+		c.conn.Close()
+	}
+	s.tests.finish()
+	s.ptmx.Close()
+	s.stop()
+	s.ws.Close()
+	for _, release := range s.release {
+		release()
+	}
+	close(s.done)
+}
+
+// utf8Boundary returns how much of p can be sent without splitting a
+// character: all of it, unless it ends partway through one.
+func utf8Boundary(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(p)
+}
+
+// ringBuffer keeps the last bytes written to it, up to its size.
+type ringBuffer struct {
+	buf  []byte
+	pos  int // where the next write goes
+	full bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, size)}
+}
+
+func (rb *ringBuffer) Write(p []byte) {
+	size := len(rb.buf)
+	if len(p) >= size {
+		copy(rb.buf, p[len(p)-size:])
+		rb.pos, rb.full = 0, true
+		return
+	}
+	n := copy(rb.buf[rb.pos:], p)
+	copy(rb.buf, p[n:])
+	if rb.pos+len(p) >= size {
+		rb.full = true
+	}
+	rb.pos = (rb.pos + len(p)) % size
+}
+
+// Bytes returns a copy of what the buffer holds, oldest first, starting at
+// a character boundary.
+func (rb *ringBuffer) Bytes() []byte {
+	if !rb.full {
+		return append([]byte(nil), rb.buf[:rb.pos]...)
+	}
+	b := append(append([]byte(nil), rb.buf[rb.pos:]...), rb.buf[:rb.pos]...)
+	for len(b) > 0 && !utf8.RuneStart(b[0]) {
+		b = b[1:]
+	}
+	return b
+}
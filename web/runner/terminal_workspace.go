@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// terminalSyncInterval is how often the terminal workspace is checked
+	// for files the shell changed.
+	terminalSyncInterval = time.Second
+	// maxSyncedFile caps the size of a file sent back to the editor.
+	maxSyncedFile = 1 << 20
+)
+
+// terminalWorkspace is a terminal session's copy of a lesson: the workspace
+// a run would get, with the student's code, plus a bin directory holding
+// run-tests. It keeps the student's code files in step with the editor.
+// Only files the editor has are synced; files the shell creates stay in the
+// terminal.
+type terminalWorkspace struct {
+	root string   // session directory, removed by Close
+	work string   // the lesson workspace; the shell starts here
+	bin  string   // put first on the shell's PATH
+	rc   string   // the shell's startup file
+	spec *RunSpec // the lesson's sandbox and cache, which the shell shares
+
+	mu    sync.Mutex
+	files map[string]*syncedFile // by path relative to work
+}
+
+// syncedFile is what both sides last agreed a file contains.
+type syncedFile struct {
+	content string
+	modTime time.Time
+	size    int64
+}
+
+// newTerminalWorkspace builds the workspace for slug with the editor's code
+// and writes the run-tests command.
+func newTerminalWorkspace(course *Course, slug string, code map[string]string) (*terminalWorkspace, error) {
+	root, err := os.MkdirTemp("", "vibe-term-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+	tw := &terminalWorkspace{
+		root:  root,
+		work:  filepath.Join(root, "work"),
+		bin:   filepath.Join(root, "bin"),
+		rc:    filepath.Join(root, "bashrc"),
+		files: make(map[string]*syncedFile),
+	}
+	if err := tw.build(course, slug, code); err != nil {
+		os.RemoveAll(root)
+		return nil, err
+	}
+	return tw, nil
+}
+
+func (tw *terminalWorkspace) build(course *Course, slug string, code map[string]string) error {
+	for _, dir := range []string{tw.work, tw.bin} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := fillWorkspace(tw.work, course, slug, code); err != nil {
+		return err
+	}
+	for name, content := range code {
+		path, _ := workspaceFile(tw.work, name)
+		if info, err := os.Stat(path); err == nil {
+			tw.files[name] = &syncedFile{content: content, modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+
+	spec, err := ResolveRunSpec(course, slug, tw.work)
+	if err != nil {
+		return fmt.Errorf("runner error: %w", err)
+	}
+	tw.spec = spec
+
+	if err := os.WriteFile(filepath.Join(tw.bin, "run-tests"), []byte(runTestsScript), 0755); err != nil {
+		return err
+	}
+
+	// A login shell would reset PATH and PS1 from /etc/profile, so read it
+	// first and set them after.
+	rc := `[ -f /etc/profile ] && . /etc/profile
+[ -f ~/.bashrc ] && . ~/.bashrc
+export PATH=` + shellQuote(tw.bin) + `:"$PATH"
+PS1='\[\e[32m\]'` + shellQuote(slug) + `'\[\e[0m\]:\w$ '
+cd ` + shellQuote(tw.work) + `
+echo "Type run-tests to run this lesson's tests. Files you change here show up in the editor."
+`
+	return os.WriteFile(tw.rc, []byte(rc), 0644)
+}
+
+// WriteFile applies an edit from the editor to the workspace. Writing the
+// content the file already has is a no-op, so edits don't echo back and
+// forth.
+func (tw *terminalWorkspace) WriteFile(name, content string) error {
+	path, err := workspaceFile(tw.work, name)
+	if err != nil {
+		return err
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if f := tw.files[name]; f != nil && f.content == content {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	f := &syncedFile{content: content}
+	if info, err := os.Stat(path); err == nil {
+		f.modTime, f.size = info.ModTime(), info.Size()
+	}
+	tw.files[name] = f
+	return nil
+}
+
+// Changes returns the synced files the shell has changed since the last
+// call, by name. Deleted files, and files over maxSyncedFile, are left out.
+func (tw *terminalWorkspace) Changes() map[string]string {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	var changed map[string]string
+	for name, f := range tw.files {
+		path, _ := workspaceFile(tw.work, name)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxSyncedFile {
+			continue
+		}
+		if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		f.modTime, f.size = info.ModTime(), info.Size()
+		if string(b) == f.content {
+			continue
+		}
+		f.content = string(b)
+		if changed == nil {
+			changed = make(map[string]string)
+		}
+		changed[name] = f.content
+	}
+	return changed
+}
+
+// Code returns the synced files as they are now, by name, for a run of the
+// lesson's tests. Files the shell deleted are left out.
+func (tw *terminalWorkspace) Code() map[string]string {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	code := make(map[string]string, len(tw.files))
+	for name := range tw.files {
+		path, _ := workspaceFile(tw.work, name)
+		if b, err := os.ReadFile(path); err == nil {
+			code[name] = string(b)
+		}
+	}
+	return code
+}
+
+// Close deletes the workspace.
+func (tw *terminalWorkspace) Close() error {
+	return os.RemoveAll(tw.root)
+}
+
+// shellQuote quotes s as a single bash word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats a runner can declare so test results are reported
+// individually instead of only as raw output.
+const (
+	formatGoJSON     = "go-json"    // go test -json
+	formatJUnit      = "junit"      // JUnit XML report written by the runner
+	formatCheckmarks = "checkmarks" // ✓/✗ lines from shared/test-helpers.sh
+)
+
+// defaultJUnitReport is where junit runners write their report, relative to
+// the workspace.
+const defaultJUnitReport = "test-results.xml"
+
+// TestSummary counts test results for a run.
+type TestSummary struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	Total   int `json:"total"`
+}
+
+func (s *TestSummary) add(msgType string) {
+	switch msgType {
+	case "test_pass":
+		s.Passed++
+	case "test_fail":
+		s.Failed++
+	case "test_skip":
+		s.Skipped++
+	default:
+		return
+	}
+	s.Total++
+}
+
+// resultParser turns a run's output into RunMessages. It is not safe for
+// concurrent use.
+type resultParser interface {
+	// Line handles one line from stream ("stdout" or "stderr") and returns
+	// the messages to send in its place.
+	Line(stream, line string) []RunMessage
+	// Finish is called once the command exits and returns any remaining
+	// messages, e.g. results read from a report file in workDir.
+	Finish(workDir string) []RunMessage
+	Summary() TestSummary
+}
+
+// newResultParser returns the parser for a runner output format.
+func newResultParser(format, report string) resultParser {
+	switch format {
+	case formatGoJSON:
+		return &goJSONParser{output: make(map[string]*strings.Builder)}
+	case formatJUnit:
+		if report == "" {
+			report = defaultJUnitReport
+		}
+		return &junitParser{report: report, started: time.Now()}
+	case formatCheckmarks:
+		return &checkmarkParser{}
+	default:
+		return &plainParser{}
+	}
+}
+
+// plainParser passes output through unchanged.
+type plainParser struct{}
+
+func (plainParser) Line(stream, line string) []RunMessage {
+	return []RunMessage{{Type: stream, Data: line}}
+}
+
+func (plainParser) Finish(string) []RunMessage { return nil }
+
+func (plainParser) Summary() TestSummary { return TestSummary{} }
+
+// goTestEvent is one line of `go test -json` output.
+type goTestEvent struct {
+	Action  string  `json:"Action"`
+	Package string  `json:"Package"`
+	Test    string  `json:"Test"`
+	Elapsed float64 `json:"Elapsed"`
+	Output  string  `json:"Output"`
+}
+
+// goJSONParser converts test2json events back into readable output plus
+// per-test events. Only top-level tests count towards the summary; subtests
+// are reported but roll up into their parent.
+type goJSONParser struct {
+	summary TestSummary
+	output  map[string]*strings.Builder // per-test output, for failure messages
+}
+
+func (p *goJSONParser) Line(stream, line string) []RunMessage {
+	var ev goTestEvent
+	if stream != "stdout" || !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil {
+		return []RunMessage{{Type: stream, Data: line}}
+	}
+
+	switch ev.Action {
+	case "output", "build-output":
+		out := strings.TrimSuffix(ev.Output, "\n")
+		if ev.Test != "" {
+			b := p.output[ev.Test]
+			if b == nil {
+				b = &strings.Builder{}
+				p.output[ev.Test] = b
+			}
+			b.WriteString(ev.Output)
+		}
+		return []RunMessage{{Type: "stdout", Data: out}}
+	case "run":
+		if ev.Test != "" {
+			return []RunMessage{{Type: "test_start", Test: ev.Test}}
+		}
+	case "pass", "fail", "skip":
+		if ev.Test == "" {
+			return nil
+		}
+		msg := RunMessage{Type: "test_" + ev.Action, Test: ev.Test, Duration: ev.Elapsed}
+		if ev.Action == "fail" {
+			if b := p.output[ev.Test]; b != nil {
+				msg.Message = strings.TrimSpace(b.String())
+			}
+		}
+		delete(p.output, ev.Test)
+		if !strings.Contains(ev.Test, "/") {
+			p.summary.add(msg.Type)
+		}
+		return []RunMessage{msg}
+	}
+	return nil
+}
+
+func (p *goJSONParser) Finish(string) []RunMessage { return nil }
+
+func (p *goJSONParser) Summary() TestSummary { return p.summary }
+
+// junitParser streams output unchanged and reads test results from the
+// JUnit XML report once the run finishes.
+type junitParser struct {
+	report  string
+	started time.Time
+	summary TestSummary
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSuite struct {
+	TestCases []junitTestCase `xml:"testcase"`
+	Suites    []junitSuite    `xml:"testsuite"`
+}
+
+func (p *junitParser) Line(stream, line string) []RunMessage {
+	return []RunMessage{{Type: stream, Data: line}}
+}
+
+func (p *junitParser) Finish(workDir string) []RunMessage {
+	// Ignore a missing report, or a stale one that came in with the
+	// student's files rather than from this run.
+	path := filepath.Join(workDir, p.report)
+	if info, err := os.Stat(path); err != nil || info.ModTime().Before(p.started) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	// The root is <testsuites> or a single <testsuite>; both decode into
+	// junitSuite.
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return []RunMessage{{Type: "stderr", Data: "could not parse test report: " + err.Error()}}
+	}
+
+	var msgs []RunMessage
+	var walk func(s junitSuite)
+	walk = func(s junitSuite) {
+		for _, tc := range s.TestCases {
+			msg := RunMessage{Type: "test_pass", Test: tc.Name}
+			msg.Duration, _ = strconv.ParseFloat(tc.Time, 64)
+			if problem := firstProblem(tc.Failure, tc.Error); problem != nil {
+				msg.Type = "test_fail"
+				msg.Message = strings.TrimSpace(problem.Body)
+				if msg.Message == "" {
+					msg.Message = problem.Message
+				}
+			} else if tc.Skipped != nil {
+				msg.Type = "test_skip"
+			}
+			p.summary.add(msg.Type)
+			msgs = append(msgs, msg)
+		}
+		for _, child := range s.Suites {
+			walk(child)
+		}
+	}
+	walk(root)
+	return msgs
+}
+
+func firstProblem(problems ...*junitProblem) *junitProblem {
+	for _, p := range problems {
+		if p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+func (p *junitParser) Summary() TestSummary { return p.summary }
+
+var (
+	ansiEscape    = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	checkmarkLine = regexp.MustCompile(`^(\s*)([✓✗])\s+(.*)$`)
+)
+
+// checkmarkParser recognizes the assertion lines printed by the kubernetes
+// course's test-helpers.sh:
+//
+//	✓ Pod hello-pod is Running
+//	✗ Pod has label app=hello
+//	  expected: hello
+//	  actual:
+//
+// Indented lines following a ✗ become its failure message.
+type checkmarkParser struct {
+	summary TestSummary
+	failing *RunMessage
+	indent  int
+	detail  []string
+}
+
+func (p *checkmarkParser) Line(stream, line string) []RunMessage {
+	msgs := []RunMessage{{Type: stream, Data: line}}
+	plain := ansiEscape.ReplaceAllString(line, "")
+
+	if p.failing != nil && len(plain)-len(strings.TrimLeft(plain, " \t")) > p.indent && strings.TrimSpace(plain) != "" {
+		p.detail = append(p.detail, strings.TrimSpace(plain))
+		return msgs
+	}
+	pending := p.flush()
+
+	m := checkmarkLine.FindStringSubmatch(plain)
+	if m == nil {
+		return append(pending, msgs...)
+	}
+	if m[2] == "✓" {
+		p.summary.add("test_pass")
+		return append(append(pending, msgs...), RunMessage{Type: "test_pass", Test: m[3]})
+	}
+	p.failing = &RunMessage{Type: "test_fail", Test: m[3]}
+	p.indent = len(m[1])
+	return append(pending, msgs...)
+}
+
+// flush emits the pending failure, if any, with its collected detail lines.
+func (p *checkmarkParser) flush() []RunMessage {
+	if p.failing == nil {
+		return nil
+	}
+	msg := *p.failing
+	msg.Message = strings.Join(p.detail, "\n")
+	p.failing, p.detail = nil, nil
+	p.summary.add("test_fail")
+	return []RunMessage{msg}
+}
+
+func (p *checkmarkParser) Finish(string) []RunMessage { return p.flush() }
+
+func (p *checkmarkParser) Summary() TestSummary { return p.summary }
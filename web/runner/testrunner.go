@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RunnerConfig describes how a lesson's tests are executed. It can appear in
+// course.yaml as `test_runner` on the course or on an individual lesson.
+// Empty fields inherit from the level below: lesson → course → the
+// built-in runner for the course language.
+//
+// A plain string is shorthand: the name of a registered runner
+// (e.g. `test_runner: pytest`) or otherwise a shell command
+// (e.g. `test_runner: "bash tests/validate.sh"`).
+type RunnerConfig struct {
+	Runner  string            `yaml:"runner" json:"runner,omitempty"`   // registered runner to start from
+	Command string            `yaml:"command" json:"command,omitempty"` // shell command, run with bash -c
+	Setup   string            `yaml:"setup" json:"setup,omitempty"`     // shell command run before Command
+	Dir     string            `yaml:"dir" json:"dir,omitempty"`         // "workspace", "lesson" or "course"
+	Env     map[string]string `yaml:"env" json:"env,omitempty"`
+	Timeout string            `yaml:"timeout" json:"timeout,omitempty"` // Go duration, e.g. "3m"
+	Format  string            `yaml:"format" json:"format,omitempty"`   // output format: "go-json", "junit", "checkmarks"
+	Report  string            `yaml:"report" json:"report,omitempty"`   // junit report path, relative to the workspace
+}
+
+// UnmarshalYAML accepts either the scalar shorthand or the full mapping.
+func (rc *RunnerConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var s string
+		if err := node.Decode(&s); err != nil {
+			return err
+		}
+		if _, ok := testRunners[s]; ok {
+			*rc = RunnerConfig{Runner: s}
+		} else {
+			*rc = RunnerConfig{Command: s}
+		}
+		return nil
+	}
+	type plain RunnerConfig
+	return node.Decode((*plain)(rc))
+}
+
+// Working directories a runner command can start in.
+const (
+	runDirWorkspace = "workspace"
+	runDirLesson    = "lesson"
+	runDirCourse    = "course"
+)
+
+// testRunners is the registry of named runners. languageRunners picks the
+// default entry for a course that doesn't configure test_runner.
+var testRunners = map[string]RunnerConfig{
+	"go-test": {
+		Command: "go test -json -count=1 ./...",
+		Format:  formatGoJSON,
+		Timeout: "30s",
+	},
+	"pytest": {
+		Command: "python -m pytest -v --junitxml=" + defaultJUnitReport,
+		Format:  formatJUnit,
+		Timeout: "30s",
+	},
+	"npm-test": {
+		// Results are picked up if the project's test config writes a JUnit
+		// report (e.g. vitest's junit reporter).
+		Command: "npm test",
+		Format:  formatJUnit,
+		Timeout: "30s",
+	},
+	"cargo-test": {
+		Command: "cargo test",
+		Timeout: "2m",
+	},
+	"validate-sh": {
+		Command: "bash tests/validate.sh",
+		Dir:     runDirLesson,
+		Format:  formatCheckmarks,
+		Timeout: "30s",
+	},
+	"kubernetes": {
+		// Scripts resolve the shared helpers relative to their own path, so they
+		// run from the lesson directory and cd into $WORK_DIR themselves. The
+		// cluster is brought up by the runner's ClusterManager, not per run.
+		Command: "bash tests/validate.sh",
+		Dir:     runDirLesson,
+		Format:  formatCheckmarks,
+		Timeout: "3m",
+	},
+}
+
+var languageRunners = map[string]string{
+	"go":         "go-test",
+	"python":     "pytest",
+	"javascript": "npm-test",
+	"typescript": "npm-test",
+	"rust":       "cargo-test",
+	"kubernetes": "kubernetes",
+	"shell":      "validate-sh",
+}
+
+// RegisterTestRunner adds or replaces a named runner in the registry.
+// It must be called before courses are loaded.
+func RegisterTestRunner(name string, cfg RunnerConfig) {
+	testRunners[name] = cfg
+}
+
+// TestRunnerNames returns the registered runner names in sorted order.
+func TestRunnerNames() []string {
+	names := make([]string, 0, len(testRunners))
+	for name := range testRunners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunSpec is a fully resolved test command for one lesson run.
+type RunSpec struct {
+	Command  string
+	Dir      string
+	Env      []string // added to the executor's base environment
+	Timeout  time.Duration
+	Format   string // output format, see newResultParser
+	Report   string
+	WorkDir  string   // the run's writable workspace
+	ReadOnly []string // host files the run may read even if the sandbox hides them
+	CacheKey string   // groups runs that may share build caches
+	Sandbox  SandboxConfig
+}
+
+// ResolveRunSpec merges the language default, course and lesson runner
+// configs for a lesson and binds them to a workspace directory.
+func ResolveRunSpec(course *Course, slug, workDir string) (*RunSpec, error) {
+	if strings.Contains(slug, "..") || strings.Contains(slug, "/") {
+		return nil, fmt.Errorf("invalid lesson slug")
+	}
+
+	var cfg RunnerConfig
+	if name, ok := languageRunners[course.Language]; ok {
+		cfg = testRunners[name]
+		cfg.Runner = name
+	}
+	cfg, err := mergeRunnerConfig(cfg, course.TestRunner)
+	if err != nil {
+		return nil, err
+	}
+	if lesson := course.findLesson(slug); lesson != nil {
+		if cfg, err = mergeRunnerConfig(cfg, lesson.TestRunner); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("no test runner for language %q", course.Language)
+	}
+
+	courseDir := course.Path
+	lessonDir := filepath.Join(courseDir, "lessons", slug)
+
+	spec := &RunSpec{
+		Command:  cfg.Command,
+		Timeout:  defaultRunTimeout,
+		Format:   cfg.Format,
+		Report:   cfg.Report,
+		WorkDir:  workDir,
+		CacheKey: course.ID,
+		Sandbox:  course.Sandbox,
+	}
+	if cfg.Setup != "" {
+		spec.Command = cfg.Setup + " && " + cfg.Command
+	}
+
+	switch cfg.Dir {
+	case "", runDirWorkspace:
+		spec.Dir = workDir
+	case runDirLesson:
+		spec.Dir = lessonDir
+	case runDirCourse:
+		spec.Dir = courseDir
+	default:
+		return nil, fmt.Errorf("unknown test runner dir %q", cfg.Dir)
+	}
+
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid test runner timeout %q: %w", cfg.Timeout, err)
+		}
+		spec.Timeout = d
+	}
+
+	spec.Env = append(spec.Env,
+		"WORK_DIR="+workDir,
+		"COURSE_DIR="+courseDir,
+		"LESSON_DIR="+lessonDir,
+	)
+	keys := make([]string, 0, len(cfg.Env))
+	for k := range cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		spec.Env = append(spec.Env, k+"="+cfg.Env[k])
+	}
+
+	return spec, nil
+}
+
+// mergeRunnerConfig overlays o on base. If o names a runner, that registry
+// entry replaces base before o's own fields are applied.
+func mergeRunnerConfig(base RunnerConfig, o *RunnerConfig) (RunnerConfig, error) {
+	if o == nil {
+		return base, nil
+	}
+
+	if o.Runner != "" {
+		named, ok := testRunners[o.Runner]
+		if !ok {
+			return base, fmt.Errorf("unknown test runner %q", o.Runner)
+		}
+		base = named
+		base.Runner = o.Runner
+	}
+
+	merged := base
+	if o.Command != "" {
+		merged.Command = o.Command
+	}
+	if o.Setup != "" {
+		merged.Setup = o.Setup
+	}
+	if o.Dir != "" {
+		merged.Dir = o.Dir
+	}
+	if o.Timeout != "" {
+		merged.Timeout = o.Timeout
+	}
+	if o.Format != "" {
+		merged.Format = o.Format
+	}
+	if o.Report != "" {
+		merged.Report = o.Report
+	}
+	if len(base.Env) > 0 || len(o.Env) > 0 {
+		merged.Env = make(map[string]string, len(base.Env)+len(o.Env))
+		for k, v := range base.Env {
+			merged.Env[k] = v
+		}
+		for k, v := range o.Env {
+			merged.Env[k] = v
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// VerifyRun is the outcome of running one lesson's tests against one copy of
+// its code.
+type VerifyRun struct {
+	ExitCode int          `json:"exit_code"`
+	Summary  *TestSummary `json:"summary,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Output   string       `json:"output,omitempty"` // tail of the output, for failed checks
+}
+
+// VerifyResult reports whether a lesson's tests tell its starter and
+// solution apart: the starter must fail and the solution must pass.
+type VerifyResult struct {
+	Course   string     `json:"course"`
+	Lesson   string     `json:"lesson"`
+	Starter  *VerifyRun `json:"starter"`
+	Solution *VerifyRun `json:"solution,omitempty"` // nil if the lesson has no solution
+	Problems []string   `json:"problems,omitempty"`
+}
+
+// VerifyReport is the result of verifying every selected lesson.
+type VerifyReport struct {
+	Lessons int            `json:"lessons"`
+	Failed  int            `json:"failed"`
+	Results []VerifyResult `json:"results"`
+}
+
+// verifyOutputLines is how much output is kept for a failed check.
+const verifyOutputLines = 30
+
+// VerifyCourses runs every lesson of courses with its starter and its
+// solution code, up to parallel lessons at a time. Lessons of courses that
+// share external state (the kubernetes courses' cluster) run one at a time.
+func VerifyCourses(executor Executor, courses []*Course, lesson string, parallel int) *VerifyReport {
+	type job struct {
+		course *Course
+		slug   string
+		index  int
+	}
+	var jobs []job
+	for _, c := range courses {
+		for _, l := range c.Lessons {
+			if lesson == "" || l.Slug == lesson {
+				jobs = append(jobs, job{c, l.Slug, len(jobs)})
+			}
+		}
+	}
+
+	report := &VerifyReport{Lessons: len(jobs), Results: make([]VerifyResult, len(jobs))}
+	serial := make(map[string]*sync.Mutex)
+	for _, c := range courses {
+		if c.Language == "kubernetes" {
+			serial[c.ID] = &sync.Mutex{}
+		}
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	queue := make(chan job)
+	var wg sync.WaitGroup
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				if mu := serial[j.course.ID]; mu != nil {
+					mu.Lock()
+					report.Results[j.index] = verifyLesson(executor, j.course, j.slug)
+					mu.Unlock()
+				} else {
+					report.Results[j.index] = verifyLesson(executor, j.course, j.slug)
+				}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, r := range report.Results {
+		if len(r.Problems) > 0 {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// verifyLesson runs one lesson's tests against its starter and its solution.
+func verifyLesson(executor Executor, course *Course, slug string) VerifyResult {
+	result := VerifyResult{Course: course.ID, Lesson: slug}
+	lessonDir := filepath.Join(course.Path, "lessons", slug)
+
+	result.Starter = verifyRun(executor, course, slug, readCodeDir(filepath.Join(lessonDir, "starter")))
+	switch {
+	case result.Starter.Error != "":
+		result.Problems = append(result.Problems, "starter: "+result.Starter.Error)
+	case result.Starter.ExitCode == 0:
+		result.Problems = append(result.Problems, "starter passes the tests")
+	default:
+		result.Starter.Output = ""
+	}
+
+	if !LessonHasSolution(course, slug) {
+		return result
+	}
+	result.Solution = verifyRun(executor, course, slug, readCodeDir(filepath.Join(lessonDir, "solution")))
+	switch {
+	case result.Solution.Error != "":
+		result.Problems = append(result.Problems, "solution: "+result.Solution.Error)
+	case result.Solution.ExitCode != 0:
+		result.Problems = append(result.Problems, fmt.Sprintf("solution fails the tests (exit %d)", result.Solution.ExitCode))
+	default:
+		result.Solution.Output = ""
+	}
+	return result
+}
+
+// verifyRun runs the lesson with code the same way handleRun does and keeps
+// the tail of its output.
+func verifyRun(executor Executor, course *Course, slug string, code map[string]string) *VerifyRun {
+	var lines []string
+	var runErr string
+	res, err := runLesson(context.Background(), executor, course, slug, code, nil, func(m RunMessage) {
+		switch m.Type {
+		case "stdout", "stderr":
+			lines = append(lines, m.Data)
+			if len(lines) > verifyOutputLines {
+				lines = lines[1:]
+			}
+		case "error":
+			runErr = m.Data
+		}
+	})
+	if err != nil {
+		return &VerifyRun{ExitCode: -1, Error: err.Error()}
+	}
+
+	run := &VerifyRun{ExitCode: res.ExitCode, Error: runErr, Output: strings.Join(lines, "\n")}
+	if res.Summary.Total > 0 {
+		run.Summary = &res.Summary
+	}
+	return run
+}
+
+// runVerify implements `runner verify`. It prints the report and returns the
+// process exit code: 1 if any lesson fails verification.
+func runVerify(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	coursesRoot := fs.String("courses-root", "/courses", "path to courses directory")
+	courseID := fs.String("course", "", "only verify this course")
+	lesson := fs.String("lesson", "", "only verify lessons with this slug")
+	parallel := fs.Int("parallel", runtime.NumCPU(), "lessons to verify at once")
+	format := fs.String("format", "json", "report format: json or text")
+	executorName := fs.String("executor", "sandbox", "how to run course code: sandbox or local (no isolation)")
+	sandboxCache := fs.String("sandbox-cache", "/tmp/vibe-cache", "directory for per-course build caches in the sandbox")
+	sandboxCgroup := fs.String("sandbox-cgroup", "/sys/fs/cgroup/vibe-train", "cgroup v2 directory for per-run resource limits (empty to use rlimits)")
+	fs.Parse(args)
+
+	if *format != "json" && *format != "text" {
+		fmt.Fprintf(os.Stderr, "verify: unknown format %q\n", *format)
+		return 2
+	}
+
+	courses, parseErrs, err := ScanCourses(*coursesRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 2
+	}
+	for _, e := range parseErrs {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", e)
+	}
+	if *courseID != "" {
+		var selected []*Course
+		for _, c := range courses {
+			if c.ID == *courseID {
+				selected = append(selected, c)
+			}
+		}
+		if len(selected) == 0 {
+			fmt.Fprintf(os.Stderr, "verify: course %q not found\n", *courseID)
+			return 2
+		}
+		courses = selected
+	}
+
+	executor, err := NewExecutor(*executorName, SandboxOptions{
+		CacheDir:  *sandboxCache,
+		CgroupDir: *sandboxCgroup,
+		Hide:      []string{"/var/run/docker.sock"},
+		Courses:   *coursesRoot,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: setting up %s executor: %v\n", *executorName, err)
+		return 2
+	}
+
+	// Bring up the clusters kubernetes lessons run against
+	clusters := NewClusterManager(&K3dProvisioner{}, clusterCheckInterval)
+	clusters.Sync(courses)
+	for _, c := range courses {
+		release, err := clusters.Acquire(context.Background(), c, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verify: %s: %v\n", c.ID, err)
+			return 2
+		}
+		defer release()
+	}
+
+	report := VerifyCourses(executor, courses, *lesson, *parallel)
+
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, r := range report.Results {
+			if len(r.Problems) == 0 {
+				fmt.Fprintf(stdout, "ok   %s/%s\n", r.Course, r.Lesson)
+				continue
+			}
+			fmt.Fprintf(stdout, "FAIL %s/%s\n", r.Course, r.Lesson)
+			for _, p := range r.Problems {
+				fmt.Fprintf(stdout, "     %s\n", p)
+			}
+			for _, run := range []*VerifyRun{r.Starter, r.Solution} {
+				if run != nil && run.Output != "" {
+					fmt.Fprintf(stdout, "     | %s\n", strings.ReplaceAll(run.Output, "\n", "\n     | "))
+				}
+			}
+		}
+		fmt.Fprintf(stdout, "%d lesson(s), %d failed\n", report.Lessons, report.Failed)
+	}
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,29 +15,94 @@ import (
 // npmCacheMu serializes npm install per course to avoid races.
 var npmCacheMu sync.Mutex
 
-// BuildWorkspace creates a temporary directory with shared files, student code, and tests.
-func BuildWorkspace(course *Course, slug string, code map[string]string) (string, error) {
-	// Validate slug
-	if strings.Contains(slug, "..") || strings.Contains(slug, "/") {
-		return "", fmt.Errorf("invalid lesson slug")
+// npmInstallErrs holds the error of each course's last failed npm install,
+// until an install succeeds. Guarded by npmCacheMu.
+var npmInstallErrs = make(map[string]error)
+
+// States of a JS/TS course's node_modules cache.
+const (
+	npmCacheReady   = "ready"   // installed
+	npmCachePending = "pending" // installed by the course's next run
+	npmCacheFailed  = "failed"  // the last install failed; the next run tries again
+)
+
+// npmCacheDir is where a JS/TS course's node_modules are installed once and
+// shared by all its runs.
+func npmCacheDir(course *Course) string {
+	return filepath.Join("/tmp", "node-cache-"+course.ID)
+}
+
+// NpmCacheStatus reports the state of course's node_modules cache and, if
+// the last install failed, why.
+func NpmCacheStatus(course *Course) (string, error) {
+	npmCacheMu.Lock()
+	defer npmCacheMu.Unlock()
+	if err := npmInstallErrs[course.ID]; err != nil {
+		return npmCacheFailed, err
+	}
+	if _, err := os.Stat(filepath.Join(npmCacheDir(course), "node_modules")); err != nil {
+		return npmCachePending, nil
 	}
+	return npmCacheReady, nil
+}
 
+// workspacePatterns match the temporary directories that runs, terminals
+// and the sandbox probe work in.
+var workspacePatterns = []string{"vibe-run-*", "vibe-term-*", "vibe-probe-*"}
+
+// ReapWorkspaces deletes workspaces that a runner which crashed or was
+// killed left behind in dir, and returns how many it removed. It must run
+// before the runner starts any runs or terminals of its own, and assumes no
+// other runner shares dir.
+func ReapWorkspaces(dir string) int {
+	n := 0
+	for _, pattern := range workspacePatterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, m := range matches {
+			if info, err := os.Lstat(m); err != nil || !info.IsDir() {
+				continue
+			}
+			if err := os.RemoveAll(m); err != nil {
+				log.Printf("removing leftover workspace %s: %v", m, err)
+				continue
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// BuildWorkspace creates a temporary directory with shared files, student code, and tests.
+func BuildWorkspace(course *Course, slug string, code map[string]string) (string, error) {
 	tmpDir, err := os.MkdirTemp("", "vibe-run-*")
 	if err != nil {
 		return "", fmt.Errorf("creating temp dir: %w", err)
 	}
+	if err := fillWorkspace(tmpDir, course, slug, code); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return tmpDir, nil
+}
+
+// fillWorkspace puts a lesson's shared files, student code and tests in
+// dir, which must exist.
+func fillWorkspace(dir string, course *Course, slug string, code map[string]string) error {
+	// Validate slug
+	if strings.Contains(slug, "..") || strings.Contains(slug, "/") {
+		return fmt.Errorf("invalid lesson slug")
+	}
 
 	// 1. Copy shared files (skip node_modules — handled separately)
 	sharedDir := filepath.Join(course.Path, "shared")
-	if err := copyDirSkip(sharedDir, tmpDir, "node_modules"); err != nil && !os.IsNotExist(err) {
-		os.RemoveAll(tmpDir)
-		return "", fmt.Errorf("copying shared: %w", err)
+	if err := copyDirSkip(sharedDir, dir, "node_modules"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("copying shared: %w", err)
 	}
 
 	// For JS/TS courses, install node_modules into a per-course cache dir,
 	// then symlink into the workspace to avoid copying thousands of files.
 	if course.Language == "javascript" || course.Language == "typescript" {
-		cacheDir := filepath.Join("/tmp", "node-cache-"+course.ID)
+		cacheDir := npmCacheDir(course)
 		cachedModules := filepath.Join(cacheDir, "node_modules")
 
 		npmCacheMu.Lock()
@@ -51,54 +117,61 @@ func BuildWorkspace(course *Course, slug string, code map[string]string) (string
 			cmd := exec.Command("npm", "install")
 			cmd.Dir = cacheDir
 			if out, err := cmd.CombinedOutput(); err != nil {
+				// Don't let a half-installed cache pass for a good one.
+				os.RemoveAll(cachedModules)
+				npmInstallErrs[course.ID] = err
 				npmCacheMu.Unlock()
-				os.RemoveAll(tmpDir)
-				return "", fmt.Errorf("npm install: %s: %w", string(out), err)
+				return fmt.Errorf("npm install: %s: %w", string(out), err)
 			}
+			delete(npmInstallErrs, course.ID)
 		}
 		npmCacheMu.Unlock()
 
-		dstModules := filepath.Join(tmpDir, "node_modules")
+		dstModules := filepath.Join(dir, "node_modules")
 		if err := os.Symlink(cachedModules, dstModules); err != nil {
-			os.RemoveAll(tmpDir)
-			return "", fmt.Errorf("symlinking node_modules: %w", err)
+			return fmt.Errorf("symlinking node_modules: %w", err)
 		}
 	}
 
 	// 2. Write student code files
 	for filename, content := range code {
-		// Sanitize filename — reject path traversal
-		if strings.Contains(filename, "..") {
-			os.RemoveAll(tmpDir)
-			return "", fmt.Errorf("invalid filename: %s", filename)
-		}
-		dest := filepath.Join(tmpDir, filename)
-		// Ensure the resolved path stays within tmpDir
-		if !strings.HasPrefix(filepath.Clean(dest), filepath.Clean(tmpDir)+string(os.PathSeparator)) {
-			os.RemoveAll(tmpDir)
-			return "", fmt.Errorf("invalid filename: %s", filename)
+		dest, err := workspaceFile(dir, filename)
+		if err != nil {
+			return err
 		}
 		// Create parent directories for nested files (e.g. "subdir/file.yaml")
-		if dir := filepath.Dir(dest); dir != tmpDir {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				os.RemoveAll(tmpDir)
-				return "", fmt.Errorf("creating directory for %s: %w", filename, err)
+		if parent := filepath.Dir(dest); parent != dir {
+			if err := os.MkdirAll(parent, 0755); err != nil {
+				return fmt.Errorf("creating directory for %s: %w", filename, err)
 			}
 		}
 		if err := os.WriteFile(dest, []byte(content), 0644); err != nil {
-			os.RemoveAll(tmpDir)
-			return "", fmt.Errorf("writing %s: %w", filename, err)
+			return fmt.Errorf("writing %s: %w", filename, err)
 		}
 	}
 
 	// 3. Copy test files
 	testsDir := filepath.Join(course.Path, "lessons", slug, "tests")
-	if err := copyDir(testsDir, tmpDir); err != nil && !os.IsNotExist(err) {
-		os.RemoveAll(tmpDir)
-		return "", fmt.Errorf("copying tests: %w", err)
+	if err := copyDir(testsDir, dir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("copying tests: %w", err)
 	}
 
-	return tmpDir, nil
+	return nil
+}
+
+// workspaceFile returns the path of a student code file in the workspace
+// dir, rejecting names that would land outside it.
+func workspaceFile(dir, filename string) (string, error) {
+	// Sanitize filename — reject path traversal
+	if strings.Contains(filename, "..") {
+		return "", fmt.Errorf("invalid filename: %s", filename)
+	}
+	dest := filepath.Join(dir, filename)
+	// Ensure the resolved path stays within dir
+	if !strings.HasPrefix(filepath.Clean(dest), filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid filename: %s", filename)
+	}
+	return dest, nil
 }
 
 // copyDirSkip copies all files from src to dst, skipping directories with the given name.